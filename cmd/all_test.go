@@ -0,0 +1,84 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package cmd
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/pusher/mocks"
+	"github.com/topfreegames/pusher/util"
+)
+
+var _ = Describe("All", func() {
+	cfg := "../config/test.yaml"
+
+	var config *viper.Viper
+	var mockClient *mocks.GCMClientMock
+	var mockDb *mocks.PGMock
+	var mockStatsDClient *mocks.StatsDClientMock
+
+	BeforeEach(func() {
+		var err error
+		config, err = util.NewViperWithConfigFile(cfg)
+		Expect(err).NotTo(HaveOccurred())
+		mockDb = mocks.NewPGMock(0, 1)
+		mockClient = mocks.NewGCMClientMock()
+		mockStatsDClient = mocks.NewStatsDClientMock()
+	})
+
+	Describe("[Unit]", func() {
+		It("Should return allPusher without errors", func() {
+			allPusher, err := startAll(false, false, false, config, mockStatsDClient, mockDb, mockClient)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(allPusher).NotTo(BeNil())
+			Expect(allPusher.Config).NotTo(BeNil())
+			Expect(allPusher.IsProduction).To(BeFalse())
+			Expect(allPusher.Logger.Level).To(Equal(logrus.InfoLevel))
+			Expect(fmt.Sprintf("%T", allPusher.Logger.Formatter)).To(Equal(fmt.Sprintf("%T", &logrus.TextFormatter{})))
+			Expect(allPusher.MessageHandlers).To(HaveKey("apns"))
+			Expect(allPusher.MessageHandlers).To(HaveKey("gcm"))
+		})
+
+		It("Should set log to json format", func() {
+			allPusher, err := startAll(false, true, false, config, mockStatsDClient, mockDb, mockClient)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fmt.Sprintf("%T", allPusher.Logger.Formatter)).To(Equal(fmt.Sprintf("%T", &logrus.JSONFormatter{})))
+		})
+
+		It("Should set log to debug", func() {
+			allPusher, err := startAll(true, false, false, config, mockStatsDClient, mockDb, mockClient)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(allPusher.Logger.Level).To(Equal(logrus.DebugLevel))
+		})
+
+		It("Should set log to production", func() {
+			allPusher, err := startAll(false, false, true, config, mockStatsDClient, mockDb, mockClient)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(allPusher.IsProduction).To(BeTrue())
+		})
+	})
+})
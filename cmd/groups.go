@@ -0,0 +1,139 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/pusher/extensions"
+	"github.com/topfreegames/pusher/interfaces"
+	"github.com/topfreegames/pusher/util"
+)
+
+func manageDeviceGroup(
+	debug bool,
+	operation, app, name string,
+	tokens []string,
+	config *viper.Viper,
+	dbOrNil ...interfaces.DB,
+) (*extensions.DeviceGroup, error) {
+	var log = logrus.New()
+	if debug {
+		log.Level = logrus.DebugLevel
+	} else {
+		log.Level = logrus.InfoLevel
+	}
+	deviceGroups, err := extensions.NewDeviceGroups(app, config, log, dbOrNil...)
+	if err != nil {
+		return nil, err
+	}
+	defer deviceGroups.Cleanup()
+	switch operation {
+	case "create":
+		return deviceGroups.Create(name, tokens)
+	case "add":
+		return deviceGroups.Add(name, tokens)
+	case "remove":
+		return deviceGroups.Remove(name, tokens)
+	case "delete":
+		return nil, deviceGroups.Delete(name)
+	}
+	return nil, fmt.Errorf("invalid device group operation: %s", operation)
+}
+
+func runDeviceGroupOperation(operation string, minArgs int, args []string) {
+	if len(args) < minArgs {
+		fmt.Println("usage: pusher groups create|add|remove <app> <name> <token>... or pusher groups delete <app> <name>")
+		os.Exit(1)
+	}
+	config, err := util.NewViperWithConfigFile(cfgFile)
+	if err != nil {
+		panic(err)
+	}
+	group, err := manageDeviceGroup(debug, operation, args[0], args[1], args[2:], config)
+	if err != nil {
+		panic(err)
+	}
+	if group == nil || len(group.Tokens) == 0 {
+		fmt.Printf("device group %s deleted\n", args[1])
+		return
+	}
+	fmt.Printf("%s: %s\n", group.NotificationKeyName, group.NotificationKey)
+	fmt.Printf("tokens: %s\n", strings.Join(group.Tokens, ", "))
+}
+
+// groupsCmd represents the groups command
+var groupsCmd = &cobra.Command{
+	Use:   "groups",
+	Short: "manages fcm device groups",
+	Long:  `manages fcm device groups, keeping their notification keys in PostgreSQL`,
+}
+
+var groupsCreateCmd = &cobra.Command{
+	Use:   "create <app> <name> <token>...",
+	Short: "creates a device group with the tokens",
+	Long:  `creates a device group with the tokens`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runDeviceGroupOperation("create", 3, args)
+	},
+}
+
+var groupsAddCmd = &cobra.Command{
+	Use:   "add <app> <name> <token>...",
+	Short: "adds tokens to a device group",
+	Long:  `adds tokens to a device group, creating it if it does not exist`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runDeviceGroupOperation("add", 3, args)
+	},
+}
+
+var groupsRemoveCmd = &cobra.Command{
+	Use:   "remove <app> <name> <token>...",
+	Short: "removes tokens from a device group",
+	Long:  `removes tokens from a device group, deleting it if no tokens are left`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runDeviceGroupOperation("remove", 3, args)
+	},
+}
+
+var groupsDeleteCmd = &cobra.Command{
+	Use:   "delete <app> <name>",
+	Short: "deletes a device group",
+	Long:  `deletes a device group`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runDeviceGroupOperation("delete", 2, args)
+	},
+}
+
+func init() {
+	groupsCmd.AddCommand(groupsCreateCmd)
+	groupsCmd.AddCommand(groupsAddCmd)
+	groupsCmd.AddCommand(groupsRemoveCmd)
+	groupsCmd.AddCommand(groupsDeleteCmd)
+	RootCmd.AddCommand(groupsCmd)
+}
@@ -0,0 +1,75 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package cmd
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/pusher/mocks"
+	. "github.com/topfreegames/pusher/testing"
+	"github.com/topfreegames/pusher/util"
+)
+
+var _ = Describe("Groups", func() {
+	cfg := "../config/test.yaml"
+
+	var config *viper.Viper
+	var server *DeviceGroupServer
+	var db *mocks.PGMock
+
+	BeforeEach(func() {
+		var err error
+		config, err = util.NewViperWithConfigFile(cfg)
+		Expect(err).NotTo(HaveOccurred())
+		server = NewDeviceGroupServer()
+		config.Set("gcm.deviceGroups.host", server.URL)
+		db = mocks.NewPGMock(0, 1)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Describe("[Unit]", func() {
+		It("Should create a device group and store it", func() {
+			group, err := manageDeviceGroup(false, "create", "game", "user1", []string{"token1", "token2"}, config, db)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(group.NotificationKey).To(Equal(server.NotificationKey("user1")))
+			Expect(server.Tokens("user1")).To(Equal([]string{"token1", "token2"}))
+			Expect(db.Execs[len(db.Execs)-1][0]).To(ContainSubstring("INSERT INTO game_gcm_device_groups"))
+			Expect(db.Closed).To(BeTrue())
+		})
+
+		It("Should return an error if operation is invalid", func() {
+			_, err := manageDeviceGroup(false, "rename", "game", "user1", []string{"token1"}, config, db)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("invalid device group operation: rename"))
+		})
+
+		It("Should return an error if app is not configured", func() {
+			_, err := manageDeviceGroup(false, "create", "other", "user1", []string{"token1"}, config, db)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,110 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/pusher/extensions"
+	"github.com/topfreegames/pusher/util"
+)
+
+func manageTopicSubscriptions(
+	debug, subscribe bool,
+	app, topic string,
+	tokens []string,
+	config *viper.Viper,
+) ([]*extensions.TopicManagementResult, error) {
+	var log = logrus.New()
+	if debug {
+		log.Level = logrus.DebugLevel
+	} else {
+		log.Level = logrus.InfoLevel
+	}
+	client, err := extensions.NewInstanceIDClient(app, config, log)
+	if err != nil {
+		return nil, err
+	}
+	if subscribe {
+		return client.Subscribe(topic, tokens)
+	}
+	return client.Unsubscribe(topic, tokens)
+}
+
+func runTopicSubscriptions(subscribe bool, args []string) {
+	if len(args) < 3 {
+		fmt.Println("usage: pusher topics subscribe|unsubscribe <app> <topic> <token>...")
+		os.Exit(1)
+	}
+	config, err := util.NewViperWithConfigFile(cfgFile)
+	if err != nil {
+		panic(err)
+	}
+	results, err := manageTopicSubscriptions(debug, subscribe, args[0], args[1], args[2:], config)
+	if err != nil {
+		panic(err)
+	}
+	failed := 0
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+			fmt.Printf("%s: %s\n", result.Token, result.Error)
+		}
+	}
+	fmt.Printf("%d of %d tokens succeeded\n", len(results)-failed, len(results))
+}
+
+// topicsCmd represents the topics command
+var topicsCmd = &cobra.Command{
+	Use:   "topics",
+	Short: "manages gcm topic subscriptions",
+	Long:  `manages gcm topic subscriptions through the Instance ID API`,
+}
+
+var topicsSubscribeCmd = &cobra.Command{
+	Use:   "subscribe <app> <topic> <token>...",
+	Short: "subscribes tokens to a gcm topic",
+	Long:  `subscribes tokens to a gcm topic`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runTopicSubscriptions(true, args)
+	},
+}
+
+var topicsUnsubscribeCmd = &cobra.Command{
+	Use:   "unsubscribe <app> <topic> <token>...",
+	Short: "unsubscribes tokens from a gcm topic",
+	Long:  `unsubscribes tokens from a gcm topic`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runTopicSubscriptions(false, args)
+	},
+}
+
+func init() {
+	topicsCmd.AddCommand(topicsSubscribeCmd)
+	topicsCmd.AddCommand(topicsUnsubscribeCmd)
+	RootCmd.AddCommand(topicsCmd)
+}
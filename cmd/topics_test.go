@@ -0,0 +1,71 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package cmd
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/pusher/extensions"
+	. "github.com/topfreegames/pusher/testing"
+	"github.com/topfreegames/pusher/util"
+)
+
+var _ = Describe("Topics", func() {
+	cfg := "../config/test.yaml"
+
+	var config *viper.Viper
+	var server *InstanceIDServer
+
+	BeforeEach(func() {
+		var err error
+		config, err = util.NewViperWithConfigFile(cfg)
+		Expect(err).NotTo(HaveOccurred())
+		server = NewInstanceIDServer()
+		config.Set("gcm.iid.host", server.URL)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Describe("[Unit]", func() {
+		It("Should subscribe and unsubscribe tokens", func() {
+			results, err := manageTopicSubscriptions(false, true, "game", "news", []string{"token1", "token2"}, config)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results).To(HaveLen(2))
+			Expect(server.Subscriptions("news")).To(Equal([]string{"token1", "token2"}))
+
+			server.SetTokenError("token2", "NOT_FOUND")
+			results, err = manageTopicSubscriptions(false, false, "game", "news", []string{"token1", "token2"}, config)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results).To(Equal([]*extensions.TopicManagementResult{{Token: "token1"}, {Token: "token2", Error: "NOT_FOUND"}}))
+			Expect(server.Subscriptions("news")).To(Equal([]string{"token2"}))
+		})
+
+		It("Should return an error if app is not configured", func() {
+			_, err := manageTopicSubscriptions(false, true, "other", "news", []string{"token1"}, config)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -21,6 +21,13 @@ CREATE DATABASE push
    PRIMARY KEY ("id")
  );
 
+ CREATE TABLE "apps" (
+   "game" text NOT NULL,
+   "platform" text NOT NULL,
+   "credentials" text NOT NULL DEFAULT '{}',
+   PRIMARY KEY ("game", "platform")
+ );
+
  CREATE TABLE "testapp_gcm" (
    "id" uuid DEFAULT uuid_generate_v4(),
    "user_id" text NOT NULL,
@@ -31,6 +38,26 @@ CREATE DATABASE push
    PRIMARY KEY ("id")
  );
 
+ CREATE TABLE "testapp_gcm_device_groups" (
+   "notification_key_name" varchar(255) NOT NULL,
+   "notification_key" varchar(255) NOT NULL,
+   "tokens" text[] NOT NULL,
+   "updated_at" timestamp NOT NULL DEFAULT now(),
+   PRIMARY KEY ("notification_key_name")
+ );
+
+ CREATE TABLE "scheduled_messages" (
+   "id" uuid NOT NULL,
+   "game" text NOT NULL,
+   "platform" text NOT NULL,
+   "topic" text NOT NULL,
+   "value" text NOT NULL,
+   "send_at" bigint NOT NULL,
+   PRIMARY KEY ("id")
+ );
+
+ CREATE INDEX "scheduled_messages_platform_game_send_at" ON "scheduled_messages" ("platform", "game", "send_at");
+
  INSERT INTO testapp_apns (user_id, token, region, locale, tz) VALUES ('9e558649-9c23-469d-a11c-59b05813e3d5', '1234', 'BR', 'pt', '-0300');
  INSERT INTO testapp_apns (user_id, token, region, locale, tz) VALUES ('57be9009-e616-42c6-9cfe-505508ede2d0', '1235', 'US', 'en', '-0300');
  INSERT INTO testapp_apns (user_id, token, region, locale, tz) VALUES ('a8e8d2d5-f178-4d90-9b31-683ad3aae920', '1236', 'BR', 'pt', '-0300');
@@ -0,0 +1,210 @@
+/*
+ * Copyright (c) 2026 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package extensions
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sideshow/apns2"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/pusher/structs"
+)
+
+// apnsConnection is a client of the pool along with its health stats
+type apnsConnection struct {
+	id                int
+	client            *apns2.Client
+	requests          int64
+	errors            int64
+	consecutiveErrors int
+	latency           time.Duration
+	recreated         int64
+}
+
+// APNSConnectionPool holds the clients used by an APNSPushQueue, tracking
+// errors and latency of each one and recreating the unhealthy ones
+type APNSConnectionPool struct {
+	Config               *viper.Viper
+	Logger               *log.Logger
+	connections          []*apnsConnection
+	newClient            func() *apns2.Client
+	next                 int
+	maxConsecutiveErrors int
+	maxErrorRate         float64
+	maxLatency           time.Duration
+	minRequests          int64
+	mutex                sync.Mutex
+}
+
+// NewAPNSConnectionPool returns a pool with size clients created by newClient
+func NewAPNSConnectionPool(
+	size int,
+	newClient func() *apns2.Client,
+	logger *log.Logger,
+	config *viper.Viper,
+) *APNSConnectionPool {
+	p := &APNSConnectionPool{
+		Config:    config,
+		Logger:    logger,
+		newClient: newClient,
+	}
+	p.configure(size)
+	return p
+}
+
+func (p *APNSConnectionPool) loadConfigurationDefaults() {
+	p.Config.SetDefault("apns.connectionPool.maxConsecutiveErrors", 5)
+	p.Config.SetDefault("apns.connectionPool.maxErrorRate", 0.5)
+	p.Config.SetDefault("apns.connectionPool.maxLatency", 10000)
+	p.Config.SetDefault("apns.connectionPool.minRequests", 20)
+}
+
+func (p *APNSConnectionPool) configure(size int) {
+	p.loadConfigurationDefaults()
+	p.maxConsecutiveErrors = p.Config.GetInt("apns.connectionPool.maxConsecutiveErrors")
+	p.maxErrorRate = p.Config.GetFloat64("apns.connectionPool.maxErrorRate")
+	p.maxLatency = time.Duration(p.Config.GetInt("apns.connectionPool.maxLatency")) * time.Millisecond
+	p.minRequests = p.Config.GetInt64("apns.connectionPool.minRequests")
+	if size < 1 {
+		size = 1
+	}
+	p.connections = make([]*apnsConnection, size)
+	for i := range p.connections {
+		p.connections[i] = &apnsConnection{id: i, client: p.newClient()}
+	}
+}
+
+// Get returns the next connection of the pool in a round robin fashion along
+// with its current client
+func (p *APNSConnectionPool) Get() (*apnsConnection, *apns2.Client) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	conn := p.connections[p.next]
+	p.next = (p.next + 1) % len(p.connections)
+	return conn, conn.client
+}
+
+// Size returns the number of connections in the pool
+func (p *APNSConnectionPool) Size() int {
+	return len(p.connections)
+}
+
+// Record stores the outcome of a request made with client and recreates it if
+// the connection became unhealthy
+func (p *APNSConnectionPool) Record(conn *apnsConnection, client *apns2.Client, latency time.Duration, failed bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if conn.client != client {
+		// the client was already recreated, its stats no longer matter
+		return
+	}
+
+	conn.requests++
+	if failed {
+		conn.errors++
+		conn.consecutiveErrors++
+	} else {
+		conn.consecutiveErrors = 0
+	}
+	if conn.latency == 0 {
+		conn.latency = latency
+	} else {
+		// exponentially weighted moving average, so old requests fade away
+		conn.latency = (conn.latency*4 + latency) / 5
+	}
+
+	if reason := p.unhealthyReason(conn); reason != "" {
+		p.Logger.WithFields(log.Fields{
+			"method":     "connectionPool.record",
+			"connection": conn.id,
+			"requests":   conn.requests,
+			"errors":     conn.errors,
+			"latency":    conn.latency,
+			"reason":     reason,
+		}).Warn("recreating unhealthy apns connection")
+		p.recreate(conn)
+		conn.recreated++
+		return
+	}
+
+	// keep the error rate relative to the most recent requests
+	if conn.requests >= p.minRequests*2 {
+		conn.requests /= 2
+		conn.errors /= 2
+	}
+}
+
+func (p *APNSConnectionPool) unhealthyReason(conn *apnsConnection) string {
+	if p.maxConsecutiveErrors > 0 && conn.consecutiveErrors >= p.maxConsecutiveErrors {
+		return "consecutive errors"
+	}
+	if conn.requests < p.minRequests {
+		return ""
+	}
+	if p.maxErrorRate > 0 && float64(conn.errors)/float64(conn.requests) > p.maxErrorRate {
+		return "error rate"
+	}
+	if p.maxLatency > 0 && conn.latency > p.maxLatency {
+		return "latency"
+	}
+	return ""
+}
+
+func (p *APNSConnectionPool) recreate(conn *apnsConnection) {
+	old := conn.client
+	conn.client = p.newClient()
+	conn.requests = 0
+	conn.errors = 0
+	conn.consecutiveErrors = 0
+	conn.latency = 0
+	go old.CloseIdleConnections()
+}
+
+// RecreateAll replaces the client of every connection, used when the
+// credentials change
+func (p *APNSConnectionPool) RecreateAll() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, conn := range p.connections {
+		p.recreate(conn)
+	}
+}
+
+// Stats returns the health of every connection in the pool
+func (p *APNSConnectionPool) Stats() []*structs.APNSConnectionStats {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	stats := make([]*structs.APNSConnectionStats, len(p.connections))
+	for i, conn := range p.connections {
+		stats[i] = &structs.APNSConnectionStats{
+			ID:        conn.id,
+			Requests:  conn.requests,
+			Errors:    conn.errors,
+			Latency:   conn.latency,
+			Recreated: conn.recreated,
+		}
+	}
+	return stats
+}
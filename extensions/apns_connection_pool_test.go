@@ -0,0 +1,150 @@
+/*
+ * Copyright (c) 2026 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package extensions
+
+import (
+	"crypto/tls"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sideshow/apns2"
+	"github.com/sirupsen/logrus/hooks/test"
+	. "github.com/topfreegames/pusher/testing"
+	"github.com/topfreegames/pusher/util"
+)
+
+var _ = Describe("APNS Connection Pool", func() {
+	var pool *APNSConnectionPool
+	var created int
+
+	configFile := "../config/test.yaml"
+	config, _ := util.NewViperWithConfigFile(configFile)
+	logger, hook := test.NewNullLogger()
+
+	Describe("[Unit]", func() {
+		BeforeEach(func() {
+			created = 0
+			newClient := func() *apns2.Client {
+				created++
+				return apns2.NewClient(tls.Certificate{})
+			}
+			config.Set("apns.connectionPool.maxConsecutiveErrors", 3)
+			config.Set("apns.connectionPool.maxErrorRate", 0.5)
+			config.Set("apns.connectionPool.maxLatency", 100)
+			config.Set("apns.connectionPool.minRequests", 4)
+			pool = NewAPNSConnectionPool(2, newClient, logger, config)
+			hook.Reset()
+		})
+
+		Describe("Creating new pool", func() {
+			It("should create one client per connection", func() {
+				Expect(pool.Size()).To(Equal(2))
+				Expect(created).To(Equal(2))
+			})
+
+			It("should create at least one connection", func() {
+				pool = NewAPNSConnectionPool(0, func() *apns2.Client { return apns2.NewClient(tls.Certificate{}) }, logger, config)
+				Expect(pool.Size()).To(Equal(1))
+			})
+		})
+
+		Describe("Getting connections", func() {
+			It("should rotate connections", func() {
+				first, _ := pool.Get()
+				second, _ := pool.Get()
+				third, _ := pool.Get()
+				Expect(first.id).To(Equal(0))
+				Expect(second.id).To(Equal(1))
+				Expect(third).To(Equal(first))
+			})
+		})
+
+		Describe("Recording requests", func() {
+			It("should keep healthy connections", func() {
+				conn, client := pool.Get()
+				for i := 0; i < 10; i++ {
+					pool.Record(conn, client, 10*time.Millisecond, false)
+				}
+				_, current := pool.Get()
+				_, current = pool.Get()
+				Expect(current).To(BeIdenticalTo(client))
+				Expect(created).To(Equal(2))
+			})
+
+			It("should recreate connection after consecutive errors", func() {
+				conn, client := pool.Get()
+				for i := 0; i < 3; i++ {
+					pool.Record(conn, client, time.Millisecond, true)
+				}
+				Expect(created).To(Equal(3))
+				Expect(conn.client).NotTo(BeIdenticalTo(client))
+				Expect(pool.Stats()[0].Recreated).To(Equal(int64(1)))
+				Expect(pool.Stats()[0].Requests).To(Equal(int64(0)))
+				Expect(hook.Entries).To(ContainLogMessage("recreating unhealthy apns connection"))
+			})
+
+			It("should recreate connection if error rate is too high", func() {
+				conn, client := pool.Get()
+				pool.Record(conn, client, time.Millisecond, true)
+				pool.Record(conn, client, time.Millisecond, false)
+				pool.Record(conn, client, time.Millisecond, true)
+				Expect(created).To(Equal(2))
+				pool.Record(conn, client, time.Millisecond, true)
+				Expect(created).To(Equal(3))
+			})
+
+			It("should recreate connection if latency is too high", func() {
+				conn, client := pool.Get()
+				for i := 0; i < 4; i++ {
+					pool.Record(conn, client, time.Second, false)
+				}
+				Expect(created).To(Equal(3))
+			})
+
+			It("should ignore requests made with a recreated client", func() {
+				conn, client := pool.Get()
+				for i := 0; i < 3; i++ {
+					pool.Record(conn, client, time.Millisecond, true)
+				}
+				pool.Record(conn, client, time.Millisecond, true)
+				Expect(pool.Stats()[0].Errors).To(Equal(int64(0)))
+			})
+		})
+
+		Describe("Stats", func() {
+			It("should return stats of every connection", func() {
+				conn, client := pool.Get()
+				pool.Record(conn, client, 10*time.Millisecond, false)
+				pool.Record(conn, client, 10*time.Millisecond, true)
+				stats := pool.Stats()
+				Expect(stats).To(HaveLen(2))
+				Expect(stats[0].ID).To(Equal(0))
+				Expect(stats[0].Requests).To(Equal(int64(2)))
+				Expect(stats[0].Errors).To(Equal(int64(1)))
+				Expect(stats[0].Latency).To(Equal(10 * time.Millisecond))
+				Expect(stats[1].Requests).To(Equal(int64(0)))
+			})
+		})
+	})
+})
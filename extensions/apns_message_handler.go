@@ -24,6 +24,7 @@ package extensions
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"sync"
 	"time"
@@ -46,20 +47,45 @@ type Notification struct {
 	Payload     interface{}
 	Metadata    map[string]interface{} `json:"metadata,omitempty"`
 	PushExpiry  int64                  `json:"push_expiry,omitempty"`
+	SendAt      int64                  `json:"send_at,omitempty"`
+	Priority    int                    `json:"apns_priority,omitempty"`
+	Expiration  *int64                 `json:"apns_expiration,omitempty"`
+	CollapseID  string                 `json:"apns_collapse_id,omitempty"`
+	PushType    string                 `json:"apns_push_type,omitempty"`
+	Topic       string                 `json:"apns_topic,omitempty"`
+}
+
+// Environments an APNS app can send pushes to. Auto starts with the environment
+// given by the -p flag and switches to the other one on BadDeviceToken
+const (
+	APNSEnvironmentProduction  = "production"
+	APNSEnvironmentDevelopment = "development"
+	APNSEnvironmentAuto        = "auto"
+)
+
+type inflightNotification struct {
+	notification        *apns2.Notification
+	attempts            int
+	isProduction        bool
+	switchedEnvironment bool
 }
 
 // APNSMessageHandler implements the messagehandler interface
 type APNSMessageHandler struct {
+	authType                     string
 	authKeyPath                  string
 	keyID                        string
 	teamID                       string
 	token                        *token.Token
+	certificatePath              string
+	passphrase                   string
 	appName                      string
 	Config                       *viper.Viper
 	clients                      chan *apns2.Client
 	failuresReceived             int64
 	feedbackReporters            []interfaces.FeedbackReporter
 	InflightMessagesMetadata     map[string]interface{}
+	inflightNotifications        map[string]*inflightNotification
 	IsProduction                 bool
 	Logger                       *log.Logger
 	LogStatsInterval             time.Duration
@@ -75,6 +101,14 @@ type APNSMessageHandler struct {
 	Topic                        string
 	requestsHeap                 *TimeoutHeap
 	CacheCleaningInterval        int
+	retryPolicy                  *RetryPolicy
+	retryTimers                  retryTimers
+	stopChannel                  chan struct{}
+	retriedMessages              int64
+	truncatePayloads             bool
+	environment                  string
+	fallbackQueue                interfaces.APNSPushQueue
+	topics                       map[string]string
 }
 
 // NewAPNSMessageHandler returns a new instance of a APNSMessageHandler
@@ -98,6 +132,7 @@ func NewAPNSMessageHandler(
 		failuresReceived:             0,
 		feedbackReporters:            feedbackReporters,
 		InflightMessagesMetadata:     map[string]interface{}{},
+		inflightNotifications:        map[string]*inflightNotification{},
 		IsProduction:                 isProduction,
 		Logger:                       logger,
 		pendingMessagesWG:            pendingMessagesWG,
@@ -108,6 +143,7 @@ func NewAPNSMessageHandler(
 		StatsReporters:               statsReporters,
 		successesReceived:            0,
 		requestsHeap:                 NewTimeoutHeap(config),
+		stopChannel:                  make(chan struct{}),
 		PushQueue:                    pushQueue,
 	}
 	if err := a.configure(); err != nil {
@@ -121,29 +157,129 @@ func (a *APNSMessageHandler) configure() error {
 	interval := a.Config.GetInt("apns.logStatsInterval")
 	a.LogStatsInterval = time.Duration(interval) * time.Millisecond
 	a.CacheCleaningInterval = a.Config.GetInt("feedback.cache.cleaningInterval")
+	a.retryPolicy = NewRetryPolicy(a.Config, "apns.retry")
+	a.truncatePayloads = a.Config.GetBool("apns.truncatePayloads")
+	a.authType = a.Config.GetString("apns.certs." + a.appName + ".authType")
+	a.certificatePath = a.Config.GetString("apns.certs." + a.appName + ".certificatePath")
+	a.passphrase = a.Config.GetString("apns.certs." + a.appName + ".passphrase")
+	a.environment = a.Config.GetString("apns.certs." + a.appName + ".environment")
+	a.topics = a.Config.GetStringMapString("apns.certs." + a.appName + ".topics")
+
+	switch a.environment {
+	case APNSEnvironmentProduction:
+		a.IsProduction = true
+	case APNSEnvironmentDevelopment:
+		a.IsProduction = false
+	case APNSEnvironmentAuto, "":
+	default:
+		return fmt.Errorf("invalid apns environment for app %s: %s", a.appName, a.environment)
+	}
 
 	if a.PushQueue == nil {
-		a.PushQueue = NewAPNSPushQueue(
+		queue, err := a.newPushQueue(a.IsProduction)
+		if err != nil {
+			return err
+		}
+		a.PushQueue = queue
+	}
+	if a.environment == APNSEnvironmentAuto && a.fallbackQueue == nil {
+		queue, err := a.newPushQueue(!a.IsProduction)
+		if err != nil {
+			return err
+		}
+		a.fallbackQueue = queue
+	}
+
+	return nil
+}
+
+func (a *APNSMessageHandler) newPushQueue(isProduction bool) (*APNSPushQueue, error) {
+	var queue *APNSPushQueue
+	switch a.authType {
+	case APNSAuthTypeCertificate:
+		queue = NewAPNSCertificatePushQueue(
+			a.certificatePath,
+			a.passphrase,
+			isProduction,
+			a.Logger,
+			a.Config,
+		)
+	case APNSAuthTypeToken, "":
+		queue = NewAPNSPushQueue(
 			a.authKeyPath,
 			a.keyID,
 			a.teamID,
-			a.IsProduction,
+			isProduction,
 			a.Logger,
 			a.Config,
 		)
-		err := a.PushQueue.Configure()
-		if err != nil {
-			return err
-		}
+	default:
+		return nil, fmt.Errorf("invalid apns auth type for app %s: %s", a.appName, a.authType)
+	}
+	queue.connectionPoolSize = a.appConfigInt("connectionPoolSize")
+	queue.concurrentWorkers = a.appConfigInt("concurrentWorkers")
+	queue.appName = a.appName
+	queue.StatsReporters = a.StatsReporters
+	if err := queue.Configure(); err != nil {
+		return nil, err
 	}
+	return queue, nil
+}
 
-	return nil
+// queueFor returns the queue sending pushes to the given environment
+func (a *APNSMessageHandler) queueFor(isProduction bool) interfaces.APNSPushQueue {
+	if a.fallbackQueue != nil && isProduction != a.IsProduction {
+		return a.fallbackQueue
+	}
+	return a.PushQueue
+}
+
+// topicFor returns the topic configured in apns.certs.<app>.topics for the
+// push type. When it is not configured, voip, complication and liveactivity
+// pushes follow the Apple convention of suffixing the app topic
+func (a *APNSMessageHandler) topicFor(pushType string) string {
+	if topic, ok := a.topics[pushType]; ok && topic != "" {
+		return topic
+	}
+	switch pushType {
+	case "voip":
+		return a.Topic + ".voip"
+	case "complication":
+		return a.Topic + ".complication"
+	case "liveactivity":
+		return a.Topic + ".push-type.liveactivity"
+	}
+	return a.Topic
+}
+
+func topicTag(topic string) string {
+	return fmt.Sprintf("topic:%s", topic)
+}
+
+func environmentName(isProduction bool) string {
+	if isProduction {
+		return APNSEnvironmentProduction
+	}
+	return APNSEnvironmentDevelopment
+}
+
+// appConfigInt returns apns.certs.<app>.<key> if set, falling back to apns.<key>
+func (a *APNSMessageHandler) appConfigInt(key string) int {
+	appKey := fmt.Sprintf("apns.certs.%s.%s", a.appName, key)
+	if a.Config.IsSet(appKey) {
+		return a.Config.GetInt(appKey)
+	}
+	return a.Config.GetInt("apns." + key)
 }
 
 func (a *APNSMessageHandler) loadConfigurationDefaults() {
 	a.Config.SetDefault("apns.concurrentWorkers", 10)
 	a.Config.SetDefault("apns.logStatsInterval", 5000)
 	a.Config.SetDefault("feedback.cache.cleaningInterval", 300000)
+	a.Config.SetDefault("apns.retry.maxAttempts", 3)
+	a.Config.SetDefault("apns.retry.baseDelay", 1000)
+	a.Config.SetDefault("apns.retry.maxDelay", 30000)
+	a.Config.SetDefault("apns.truncatePayloads", false)
 }
 
 func (a *APNSMessageHandler) sendMessage(message interfaces.KafkaMessage) error {
@@ -169,13 +305,6 @@ func (a *APNSMessageHandler) sendMessage(message interfaces.KafkaMessage) error
 		}
 		return nil
 	}
-	statsReporterHandleNotificationSent(a.StatsReporters, a.appName, "apns")
-	a.PushQueue.Push(&apns2.Notification{
-		Topic:       a.Topic,
-		DeviceToken: n.DeviceToken,
-		Payload:     payload,
-		ApnsID:      deviceIdentifier,
-	})
 	if n.Metadata == nil {
 		n.Metadata = map[string]interface{}{}
 	}
@@ -190,19 +319,102 @@ func (a *APNSMessageHandler) sendMessage(message interfaces.KafkaMessage) error
 		n.Metadata["hostname"] = hostname
 	}
 	n.Metadata["timestamp"] = time.Now().Unix()
+	notification := a.buildNotification(n, payload, deviceIdentifier)
+	n.Metadata["topic"] = notification.Topic
+
+	limit := apnsPayloadLimit(n.PushType)
+	if size := len(payload); size > limit {
+		fits := false
+		if a.truncatePayloads {
+			payload, fits = truncateAlertBody(n.Payload, limit)
+		}
+		if !fits {
+			return a.rejectOversizedPayload(n, deviceIdentifier, size, limit)
+		}
+		l.WithFields(log.Fields{
+			"size":  size,
+			"limit": limit,
+		}).Debug("truncated alert body to fit payload limit")
+		statsReporterReportMetricCount(a.StatsReporters, "payload_truncated", 1, a.appName, "apns", topicTag(notification.Topic))
+		notification.Payload = payload
+	}
 
 	a.inflightMessagesMetadataLock.Lock()
 	a.InflightMessagesMetadata[deviceIdentifier] = n.Metadata
+	a.inflightNotifications[deviceIdentifier] = &inflightNotification{
+		notification: notification,
+		isProduction: a.IsProduction,
+	}
 	a.requestsHeap.AddRequest(deviceIdentifier)
 	a.inflightMessagesMetadataLock.Unlock()
 
+	statsReporterHandleNotificationSent(a.StatsReporters, a.appName, "apns", topicTag(notification.Topic))
+	statsReporterReportSendAtLag(a.StatsReporters, n.SendAt, a.appName, "apns")
+	if err := a.PushQueue.Push(notification); err != nil {
+		l.WithError(err).Error("error pushing notification")
+		a.dropInflight(deviceIdentifier)
+		return err
+	}
+
+	apnsResMutex.Lock()
 	a.sentMessages++
+	apnsResMutex.Unlock()
 	return nil
 }
 
+// rejectOversizedPayload reports a payload-too-large failure without pushing
+// the notification, as APNS would reject it anyway
+func (a *APNSMessageHandler) rejectOversizedPayload(n *Notification, apnsID string, size, limit int) error {
+	a.Logger.WithFields(log.Fields{
+		"method": "rejectOversizedPayload",
+		"size":   size,
+		"limit":  limit,
+	}).Warn("payload too large, not sending message to apns")
+	a.inflightMessagesMetadataLock.Lock()
+	a.InflightMessagesMetadata[apnsID] = n.Metadata
+	a.inflightMessagesMetadataLock.Unlock()
+	return a.handleAPNSResponse(&structs.ResponseWithMetadata{
+		Reason:      apns2.ReasonPayloadTooLarge,
+		ApnsID:      apnsID,
+		Err:         errors.NewPushError("payload-too-large", fmt.Sprintf("payload has %d bytes, limit is %d", size, limit)),
+		DeviceToken: n.DeviceToken,
+	})
+}
+
+// buildNotification maps the request fields onto the APNS headers. The topic
+// defaults to the app topic for the push type and push_expiry is used as the
+// expiration when apns_expiration is not given
+func (a *APNSMessageHandler) buildNotification(n *Notification, payload []byte, apnsID string) *apns2.Notification {
+	notification := &apns2.Notification{
+		Topic:       a.topicFor(n.PushType),
+		DeviceToken: n.DeviceToken,
+		Payload:     payload,
+		ApnsID:      apnsID,
+		Priority:    n.Priority,
+		CollapseID:  n.CollapseID,
+		PushType:    apns2.EPushType(n.PushType),
+	}
+	if n.Topic != "" {
+		notification.Topic = n.Topic
+	}
+	if n.Expiration != nil {
+		notification.Expiration = time.Unix(*n.Expiration, 0)
+	} else if n.PushExpiry > 0 {
+		notification.Expiration = time.Unix(0, n.PushExpiry*int64(time.Millisecond))
+	}
+	return notification
+}
+
 // HandleResponses from apns
 func (a *APNSMessageHandler) HandleResponses() {
-	for response := range a.PushQueue.ResponseChannel() {
+	if a.fallbackQueue != nil {
+		go a.handleQueueResponses(a.fallbackQueue)
+	}
+	a.handleQueueResponses(a.PushQueue)
+}
+
+func (a *APNSMessageHandler) handleQueueResponses(queue interfaces.APNSPushQueue) {
+	for response := range queue.ResponseChannel() {
 		a.handleAPNSResponse(response)
 	}
 }
@@ -221,12 +433,17 @@ func (a *APNSMessageHandler) CleanMetadataCache() {
 				}
 			}
 			delete(a.InflightMessagesMetadata, deviceToken)
+			delete(a.inflightNotifications, deviceToken)
 			deviceToken, hasIndeed = a.requestsHeap.HasExpiredRequest()
 		}
 		a.inflightMessagesMetadataLock.Unlock()
 
 		duration := time.Duration(a.CacheCleaningInterval)
-		time.Sleep(duration * time.Millisecond)
+		select {
+		case <-a.stopChannel:
+			return
+		case <-time.After(duration * time.Millisecond):
+		}
 	}
 }
 
@@ -249,28 +466,48 @@ func (a *APNSMessageHandler) handleAPNSResponse(responseWithMetadata *structs.Re
 		Game:     a.appName,
 		Platform: "apns",
 	}
+	if a.isRetryable(responseWithMetadata.Reason) && a.retryNotification(responseWithMetadata.ApnsID) {
+		l.WithField(log.ErrorKey, responseWithMetadata.Reason).Debug("retrying notification")
+		return nil
+	}
+	if responseWithMetadata.Reason == apns2.ReasonBadDeviceToken && a.switchEnvironment(responseWithMetadata.ApnsID) {
+		l.Debug("retrying notification in the other environment")
+		return nil
+	}
 	var err error
 	a.inflightMessagesMetadataLock.Lock()
 	if val, ok := a.InflightMessagesMetadata[responseWithMetadata.ApnsID]; ok {
 		responseWithMetadata.Metadata = val.(map[string]interface{})
 		responseWithMetadata.Timestamp = responseWithMetadata.Metadata["timestamp"].(int64)
 		delete(responseWithMetadata.Metadata, "timestamp")
+		if inflight, ok := a.inflightNotifications[responseWithMetadata.ApnsID]; ok {
+			responseWithMetadata.Metadata["environment"] = environmentName(inflight.isProduction)
+		}
 		delete(a.InflightMessagesMetadata, responseWithMetadata.ApnsID)
+		delete(a.inflightNotifications, responseWithMetadata.ApnsID)
 
 		if a.pendingMessagesWG != nil {
 			a.pendingMessagesWG.Done()
 		}
 	}
 	a.inflightMessagesMetadataLock.Unlock()
+	var tags []string
+	if topic, ok := responseWithMetadata.Metadata["topic"].(string); ok {
+		tags = append(tags, topicTag(topic))
+	}
 
 	if responseWithMetadata.Reason != "" {
 		apnsResMutex.Lock()
 		a.failuresReceived++
 		apnsResMutex.Unlock()
 		reason := responseWithMetadata.Reason
-		pErr := errors.NewPushError(a.mapErrorReason(reason), reason)
+		description := reason
+		if responseWithMetadata.Err != nil {
+			description = responseWithMetadata.Err.Description
+		}
+		pErr := errors.NewPushError(a.mapErrorReason(reason), description)
 		responseWithMetadata.Err = pErr
-		statsReporterHandleNotificationFailure(a.StatsReporters, a.appName, "apns", pErr)
+		statsReporterHandleNotificationFailure(a.StatsReporters, a.appName, "apns", pErr, tags...)
 
 		err = pErr
 		switch reason {
@@ -303,6 +540,11 @@ func (a *APNSMessageHandler) handleAPNSResponse(responseWithMetadata *structs.Re
 				"category":   "AppleError",
 				log.ErrorKey: responseWithMetadata.Reason,
 			}).Debug("received an error")
+		case ReasonConnectionError:
+			l.WithFields(log.Fields{
+				"category":   "ConnectionError",
+				log.ErrorKey: pErr.Description,
+			}).Debug("received an error")
 		default:
 			l.WithFields(log.Fields{
 				"category":   "DefaultError",
@@ -323,10 +565,106 @@ func (a *APNSMessageHandler) handleAPNSResponse(responseWithMetadata *structs.Re
 	apnsResMutex.Lock()
 	a.successesReceived++
 	apnsResMutex.Unlock()
-	statsReporterHandleNotificationSuccess(a.StatsReporters, a.appName, "apns")
+	statsReporterHandleNotificationSuccess(a.StatsReporters, a.appName, "apns", tags...)
 	return nil
 }
 
+func (a *APNSMessageHandler) isRetryable(reason string) bool {
+	switch reason {
+	case apns2.ReasonTooManyRequests, apns2.ReasonServiceUnavailable, apns2.ReasonInternalServerError,
+		apns2.ReasonShutdown, apns2.ReasonIdleTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// retryNotification pushes an inflight notification again after a backoff delay.
+// It returns false if the notification is unknown or ran out of attempts, in which
+// case the failure is final and must be reported as usual
+func (a *APNSMessageHandler) retryNotification(apnsID string) bool {
+	a.inflightMessagesMetadataLock.Lock()
+	inflight, ok := a.inflightNotifications[apnsID]
+	if !ok {
+		a.inflightMessagesMetadataLock.Unlock()
+		return false
+	}
+	if !a.retryPolicy.CanRetry(inflight.attempts) {
+		topic := inflight.notification.Topic
+		a.inflightMessagesMetadataLock.Unlock()
+		statsReporterReportMetricCount(a.StatsReporters, "retry_exhausted", 1, a.appName, "apns", topicTag(topic))
+		return false
+	}
+	inflight.attempts++
+	attempt := inflight.attempts
+	notification := inflight.notification
+	a.inflightMessagesMetadataLock.Unlock()
+
+	apnsResMutex.Lock()
+	a.retriedMessages++
+	apnsResMutex.Unlock()
+	statsReporterReportMetricCount(a.StatsReporters, "retry", 1, a.appName, "apns", topicTag(notification.Topic))
+	queue := a.queueFor(inflight.isProduction)
+	a.retryTimers.afterFunc(a.retryPolicy.Backoff(attempt), func() {
+		a.pushInflight(queue, notification)
+	})
+	return true
+}
+
+// switchEnvironment pushes an inflight notification to the other environment
+// when the app environment is auto. It returns false if it was already tried
+// in both environments
+func (a *APNSMessageHandler) switchEnvironment(apnsID string) bool {
+	if a.fallbackQueue == nil {
+		return false
+	}
+	a.inflightMessagesMetadataLock.Lock()
+	inflight, ok := a.inflightNotifications[apnsID]
+	if !ok || inflight.switchedEnvironment {
+		a.inflightMessagesMetadataLock.Unlock()
+		return false
+	}
+	inflight.switchedEnvironment = true
+	inflight.isProduction = !inflight.isProduction
+	inflight.attempts = 0
+	notification := inflight.notification
+	queue := a.queueFor(inflight.isProduction)
+	a.inflightMessagesMetadataLock.Unlock()
+
+	statsReporterReportMetricCount(a.StatsReporters, "environment_fallback", 1, a.appName, "apns", topicTag(notification.Topic))
+	// pushing from another goroutine, as the queue may be waiting for its
+	// responses to be handled
+	go a.pushInflight(queue, notification)
+	return true
+}
+
+// pushInflight pushes an inflight notification again, dropping it if the
+// queue was closed in the meantime
+func (a *APNSMessageHandler) pushInflight(queue interfaces.APNSPushQueue, notification *apns2.Notification) {
+	if err := queue.Push(notification); err != nil {
+		a.Logger.WithFields(log.Fields{
+			"method": "pushInflight",
+			"apnsID": notification.ApnsID,
+		}).WithError(err).Warn("dropping inflight notification")
+		a.dropInflight(notification.ApnsID)
+	}
+}
+
+// dropInflight forgets a notification that will not get a response
+func (a *APNSMessageHandler) dropInflight(apnsID string) {
+	a.inflightMessagesMetadataLock.Lock()
+	defer a.inflightMessagesMetadataLock.Unlock()
+	if _, ok := a.InflightMessagesMetadata[apnsID]; !ok {
+		return
+	}
+	a.ignoredMessages++
+	if a.pendingMessagesWG != nil {
+		a.pendingMessagesWG.Done()
+	}
+	delete(a.InflightMessagesMetadata, apnsID)
+	delete(a.inflightNotifications, apnsID)
+}
+
 // LogStats from time to time
 func (a *APNSMessageHandler) LogStats() {
 	l := a.Logger.WithFields(log.Fields{
@@ -335,23 +673,47 @@ func (a *APNSMessageHandler) LogStats() {
 	})
 
 	ticker := time.NewTicker(a.LogStatsInterval)
-	for range ticker.C {
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.stopChannel:
+			return
+		case <-ticker.C:
+		}
 		apnsResMutex.Lock()
-		if a.sentMessages > 0 || a.responsesReceived > 0 || a.ignoredMessages > 0 || a.successesReceived > 0 || a.failuresReceived > 0 {
+		if a.sentMessages > 0 || a.responsesReceived > 0 || a.ignoredMessages > 0 || a.successesReceived > 0 || a.failuresReceived > 0 || a.retriedMessages > 0 {
 			l.WithFields(log.Fields{
 				"sentMessages":      a.sentMessages,
 				"ignoredMessages":   a.ignoredMessages,
 				"responsesReceived": a.responsesReceived,
 				"successesReceived": a.successesReceived,
 				"failuresReceived":  a.failuresReceived,
+				"retriedMessages":   a.retriedMessages,
 			}).Info("flushing stats")
 			a.sentMessages = 0
 			a.responsesReceived = 0
 			a.ignoredMessages = 0
 			a.successesReceived = 0
 			a.failuresReceived = 0
+			a.retriedMessages = 0
 		}
 		apnsResMutex.Unlock()
+		a.reportConnectionStats()
+	}
+}
+
+func (a *APNSMessageHandler) reportConnectionStats() {
+	for _, stats := range a.PushQueue.ConnectionStats() {
+		tag := fmt.Sprintf("connection:%d", stats.ID)
+		errorRate := 0.0
+		if stats.Requests > 0 {
+			errorRate = float64(stats.Errors) / float64(stats.Requests)
+		}
+		latency := float64(stats.Latency) / float64(time.Millisecond)
+		statsReporterReportMetricGauge(a.StatsReporters, "apns_connection_requests", float64(stats.Requests), a.appName, "apns", tag)
+		statsReporterReportMetricGauge(a.StatsReporters, "apns_connection_error_rate", errorRate, a.appName, "apns", tag)
+		statsReporterReportMetricGauge(a.StatsReporters, "apns_connection_latency_ms", latency, a.appName, "apns", tag)
+		statsReporterReportMetricGauge(a.StatsReporters, "apns_connection_recreated", float64(stats.Recreated), a.appName, "apns", tag)
 	}
 }
 
@@ -409,6 +771,8 @@ func (a *APNSMessageHandler) mapErrorReason(reason string) string {
 		return "invalid-provider-token"
 	case apns2.ReasonMissingProviderToken:
 		return "missing-provider-token"
+	case ReasonConnectionError:
+		return "connection-error"
 	default:
 		return "unexpected"
 	}
@@ -416,6 +780,15 @@ func (a *APNSMessageHandler) mapErrorReason(reason string) string {
 
 //Cleanup closes connections to APNS
 func (a *APNSMessageHandler) Cleanup() error {
+	a.retryTimers.stop()
+	select {
+	case <-a.stopChannel:
+	default:
+		close(a.stopChannel)
+	}
 	a.PushQueue.Close()
+	if a.fallbackQueue != nil {
+		a.fallbackQueue.Close()
+	}
 	return nil
 }
@@ -26,6 +26,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	. "github.com/onsi/ginkgo"
@@ -34,6 +35,7 @@ import (
 	"github.com/sideshow/apns2"
 	"github.com/sirupsen/logrus"
 	"github.com/sirupsen/logrus/hooks/test"
+	pushErrors "github.com/topfreegames/pusher/errors"
 	"github.com/topfreegames/pusher/interfaces"
 	"github.com/topfreegames/pusher/mocks"
 	"github.com/topfreegames/pusher/structs"
@@ -41,7 +43,7 @@ import (
 	"github.com/topfreegames/pusher/util"
 )
 
-var _ = FDescribe("APNS Message Handler", func() {
+var _ = Describe("APNS Message Handler", func() {
 	var db interfaces.DB
 	var feedbackClients []interfaces.FeedbackReporter
 	var handler *APNSMessageHandler
@@ -106,6 +108,32 @@ var _ = FDescribe("APNS Message Handler", func() {
 				Expect(handler.responsesReceived).To(Equal(int64(0)))
 				Expect(handler.sentMessages).To(Equal(int64(0)))
 			})
+
+			It("should use per app connection pool settings", func() {
+				appConfig, _ := util.NewViperWithConfigFile(configFile)
+				appConfig.Set("apns.certs.game.connectionPoolSize", 3)
+				appConfig.Set("apns.certs.game.concurrentWorkers", 2)
+				h, err := NewAPNSMessageHandler(
+					authKeyPath,
+					keyID,
+					teamID,
+					topic,
+					appName,
+					isProduction,
+					appConfig,
+					logger,
+					nil,
+					statsClients,
+					feedbackClients,
+					nil,
+				)
+				Expect(err).NotTo(HaveOccurred())
+				queue := h.PushQueue.(*APNSPushQueue)
+				Expect(queue.pool.Size()).To(Equal(3))
+				Expect(queue.concurrentWorkers).To(Equal(2))
+				Expect(h.appConfigInt("connectionPoolSize")).To(Equal(3))
+				Expect(handler.appConfigInt("connectionPoolSize")).To(Equal(appConfig.GetInt("apns.connectionPoolSize")))
+			})
 		})
 
 		Describe("Handle APNS response", func() {
@@ -251,6 +279,88 @@ var _ = FDescribe("APNS Message Handler", func() {
 				Expect(handler.failuresReceived).To(Equal(int64(1)))
 			})
 
+			It("should retry transient errors while attempts are left", func() {
+				handler.sendMessage(interfaces.KafkaMessage{
+					Topic: "push-game_apns",
+					Value: []byte(`{ "aps" : { "alert" : "Hello HTTP/2" } }`),
+				})
+				Expect(mockPushQueue.PushedNotifications()).To(HaveLen(1))
+				apnsID := mockPushQueue.PushedNotifications()[0].ApnsID
+				res := &structs.ResponseWithMetadata{
+					StatusCode: 503,
+					ApnsID:     apnsID,
+					Reason:     apns2.ReasonServiceUnavailable,
+				}
+				err := handler.handleAPNSResponse(res)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(handler.failuresReceived).To(Equal(int64(0)))
+				Expect(handler.retriedMessages).To(Equal(int64(1)))
+				Expect(handler.InflightMessagesMetadata).To(HaveKey(apnsID))
+				Expect(mockStatsDClient.Counts["retry"]).To(Equal(int64(1)))
+				Expect(mockStatsDClient.Tags["retry"]).To(ContainElement("topic:com.game.test"))
+				Eventually(mockPushQueue.PushedNotifications).Should(HaveLen(2))
+				Expect(mockPushQueue.PushedNotifications()[1].ApnsID).To(Equal(apnsID))
+			})
+
+			It("should report failure when retries are exhausted", func() {
+				handler.sendMessage(interfaces.KafkaMessage{
+					Topic: "push-game_apns",
+					Value: []byte(`{ "aps" : { "alert" : "Hello HTTP/2" } }`),
+				})
+				apnsID := mockPushQueue.PushedNotifications()[0].ApnsID
+				for i := 0; i < 3; i++ {
+					handler.handleAPNSResponse(&structs.ResponseWithMetadata{
+						StatusCode: 429,
+						ApnsID:     apnsID,
+						Reason:     apns2.ReasonTooManyRequests,
+					})
+				}
+				Expect(handler.retriedMessages).To(Equal(int64(2)))
+				Expect(handler.failuresReceived).To(Equal(int64(1)))
+				Expect(handler.InflightMessagesMetadata).NotTo(HaveKey(apnsID))
+				Expect(mockStatsDClient.Counts["retry"]).To(Equal(int64(2)))
+				Expect(mockStatsDClient.Counts["retry_exhausted"]).To(Equal(int64(1)))
+				Expect(mockStatsDClient.Tags["retry_exhausted"]).To(ContainElement("topic:com.game.test"))
+				Expect(mockStatsDClient.Counts["failed"]).To(Equal(int64(1)))
+			})
+
+			It("should not retry non transient errors", func() {
+				handler.sendMessage(interfaces.KafkaMessage{
+					Topic: "push-game_apns",
+					Value: []byte(`{ "aps" : { "alert" : "Hello HTTP/2" } }`),
+				})
+				apnsID := mockPushQueue.PushedNotifications()[0].ApnsID
+				handler.handleAPNSResponse(&structs.ResponseWithMetadata{
+					StatusCode: 400,
+					ApnsID:     apnsID,
+					Reason:     apns2.ReasonBadDeviceToken,
+				})
+				Expect(handler.retriedMessages).To(Equal(int64(0)))
+				Expect(handler.failuresReceived).To(Equal(int64(1)))
+				Expect(mockStatsDClient.Counts["retry"]).To(Equal(int64(0)))
+			})
+
+			It("if response has connection error", func() {
+				handler.sendMessage(interfaces.KafkaMessage{
+					Topic: "push-game_apns",
+					Value: []byte(`{ "aps" : { "alert" : "Hello HTTP/2" } }`),
+				})
+				apnsID := mockPushQueue.PushedNotifications()[0].ApnsID
+				res := &structs.ResponseWithMetadata{
+					ApnsID: apnsID,
+					Reason: ReasonConnectionError,
+					Err:    pushErrors.NewPushError("connection-error", "dial tcp: connection refused"),
+				}
+				handler.handleAPNSResponse(res)
+				Expect(handler.responsesReceived).To(Equal(int64(1)))
+				Expect(handler.failuresReceived).To(Equal(int64(1)))
+				Expect(handler.retriedMessages).To(Equal(int64(0)))
+				Expect(handler.InflightMessagesMetadata).NotTo(HaveKey(apnsID))
+				Expect(res.Err.Key).To(Equal("connection-error"))
+				Expect(res.Err.Description).To(Equal("dial tcp: connection refused"))
+				Expect(mockStatsDClient.Counts["failed"]).To(Equal(int64(1)))
+			})
+
 			It("if response has untracked error", func() {
 				res := &structs.ResponseWithMetadata{
 					StatusCode: 405,
@@ -273,6 +383,274 @@ var _ = FDescribe("APNS Message Handler", func() {
 			})
 		})
 
+		Describe("Environment", func() {
+			newHandler := func(environment string) (*APNSMessageHandler, error) {
+				appConfig, _ := util.NewViperWithConfigFile(configFile)
+				appConfig.Set("apns.certs.game.environment", environment)
+				return NewAPNSMessageHandler(
+					authKeyPath,
+					keyID,
+					teamID,
+					topic,
+					appName,
+					isProduction,
+					appConfig,
+					logger,
+					nil,
+					statsClients,
+					feedbackClients,
+					mockPushQueue,
+				)
+			}
+
+			It("should override the environment flag", func() {
+				h, err := newHandler("production")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(h.IsProduction).To(BeTrue())
+				Expect(h.fallbackQueue).To(BeNil())
+			})
+
+			It("should fail if invalid environment", func() {
+				_, err := newHandler("staging")
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal("invalid apns environment for app game: staging"))
+			})
+
+			Describe("Auto", func() {
+				var fallbackQueue *mocks.APNSPushQueueMock
+
+				BeforeEach(func() {
+					var err error
+					handler, err = newHandler("auto")
+					Expect(err).NotTo(HaveOccurred())
+					Expect(handler.fallbackQueue).NotTo(BeNil())
+					handler.fallbackQueue.Close()
+					fallbackQueue = mocks.NewAPNSPushQueueMock()
+					handler.fallbackQueue = fallbackQueue
+				})
+
+				It("should retry bad device tokens in the other environment", func() {
+					handler.sendMessage(interfaces.KafkaMessage{
+						Topic: "push-game_apns",
+						Value: []byte(`{ "aps" : { "alert" : "Hello HTTP/2" } }`),
+					})
+					apnsID := mockPushQueue.PushedNotifications()[0].ApnsID
+					metadata := handler.InflightMessagesMetadata[apnsID].(map[string]interface{})
+
+					handler.handleAPNSResponse(&structs.ResponseWithMetadata{
+						StatusCode: 400,
+						ApnsID:     apnsID,
+						Reason:     apns2.ReasonBadDeviceToken,
+					})
+					Expect(handler.failuresReceived).To(Equal(int64(0)))
+					Eventually(fallbackQueue.PushedNotifications).Should(HaveLen(1))
+					Expect(fallbackQueue.PushedNotifications()[0].ApnsID).To(Equal(apnsID))
+					Expect(mockStatsDClient.Counts["environment_fallback"]).To(Equal(int64(1)))
+					Expect(mockStatsDClient.Tags["environment_fallback"]).To(ContainElement("topic:com.game.test"))
+
+					handler.handleAPNSResponse(&structs.ResponseWithMetadata{
+						StatusCode: 200,
+						ApnsID:     apnsID,
+					})
+					Expect(handler.successesReceived).To(Equal(int64(1)))
+					Expect(metadata["environment"]).To(Equal("production"))
+				})
+
+				It("should fail if token is bad in both environments", func() {
+					handler.sendMessage(interfaces.KafkaMessage{
+						Topic: "push-game_apns",
+						Value: []byte(`{ "aps" : { "alert" : "Hello HTTP/2" } }`),
+					})
+					apnsID := mockPushQueue.PushedNotifications()[0].ApnsID
+					for i := 0; i < 2; i++ {
+						handler.handleAPNSResponse(&structs.ResponseWithMetadata{
+							StatusCode: 400,
+							ApnsID:     apnsID,
+							Reason:     apns2.ReasonBadDeviceToken,
+						})
+					}
+					Expect(handler.failuresReceived).To(Equal(int64(1)))
+					Expect(handler.InflightMessagesMetadata).NotTo(HaveKey(apnsID))
+					Expect(mockStatsDClient.Counts["environment_fallback"]).To(Equal(int64(1)))
+				})
+
+				It("should retry transient errors in the environment being tried", func() {
+					handler.sendMessage(interfaces.KafkaMessage{
+						Topic: "push-game_apns",
+						Value: []byte(`{ "aps" : { "alert" : "Hello HTTP/2" } }`),
+					})
+					apnsID := mockPushQueue.PushedNotifications()[0].ApnsID
+					handler.handleAPNSResponse(&structs.ResponseWithMetadata{
+						StatusCode: 400,
+						ApnsID:     apnsID,
+						Reason:     apns2.ReasonBadDeviceToken,
+					})
+					handler.handleAPNSResponse(&structs.ResponseWithMetadata{
+						StatusCode: 503,
+						ApnsID:     apnsID,
+						Reason:     apns2.ReasonServiceUnavailable,
+					})
+					Eventually(fallbackQueue.PushedNotifications).Should(HaveLen(2))
+					Expect(mockPushQueue.PushedNotifications()).To(HaveLen(1))
+				})
+			})
+		})
+
+		Describe("Topics", func() {
+			sendWithPushType := func(pushType string) *apns2.Notification {
+				handler.sendMessage(interfaces.KafkaMessage{
+					Topic: "push-game_apns",
+					Value: []byte(fmt.Sprintf(`{ "Payload": { "aps" : {} }, "apns_push_type": "%s" }`, pushType)),
+				})
+				pushed := mockPushQueue.PushedNotifications()
+				return pushed[len(pushed)-1]
+			}
+
+			It("should use app topic for alerts", func() {
+				Expect(sendWithPushType("alert").Topic).To(Equal(topic))
+				Expect(mockStatsDClient.Tags["sent"]).To(ContainElement("topic:com.game.test"))
+			})
+
+			It("should use conventional topics when not configured", func() {
+				Expect(sendWithPushType("voip").Topic).To(Equal("com.game.test.voip"))
+				Expect(sendWithPushType("complication").Topic).To(Equal("com.game.test.complication"))
+				Expect(sendWithPushType("liveactivity").Topic).To(Equal("com.game.test.push-type.liveactivity"))
+			})
+
+			It("should use configured topic for the push type", func() {
+				appConfig, _ := util.NewViperWithConfigFile(configFile)
+				appConfig.Set("apns.certs.game.topics", map[string]interface{}{
+					"voip": "com.game.voip",
+				})
+				var err error
+				handler, err = NewAPNSMessageHandler(
+					authKeyPath,
+					keyID,
+					teamID,
+					topic,
+					appName,
+					isProduction,
+					appConfig,
+					logger,
+					nil,
+					statsClients,
+					feedbackClients,
+					mockPushQueue,
+				)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(sendWithPushType("voip").Topic).To(Equal("com.game.voip"))
+				Expect(sendWithPushType("alert").Topic).To(Equal(topic))
+			})
+
+			It("should tag responses with the topic used", func() {
+				notification := sendWithPushType("voip")
+				handler.handleAPNSResponse(&structs.ResponseWithMetadata{
+					StatusCode: 400,
+					ApnsID:     notification.ApnsID,
+					Reason:     apns2.ReasonBadDeviceToken,
+				})
+				Expect(mockStatsDClient.Tags["failed"]).To(ContainElement("topic:com.game.test.voip"))
+			})
+		})
+
+		Describe("Payload size", func() {
+			It("should not send payloads larger than the limit", func() {
+				message := fmt.Sprintf(`{ "Payload": { "aps" : { "alert" : "%s" } } }`, strings.Repeat("a", 4200))
+				err := handler.sendMessage(interfaces.KafkaMessage{
+					Topic: "push-game_apns",
+					Value: []byte(message),
+				})
+				Expect(err).To(HaveOccurred())
+				Expect(mockPushQueue.PushedNotifications()).To(BeEmpty())
+				Expect(handler.sentMessages).To(Equal(int64(0)))
+				Expect(handler.failuresReceived).To(Equal(int64(1)))
+				Expect(handler.InflightMessagesMetadata).To(BeEmpty())
+				Expect(mockStatsDClient.Counts["failed"]).To(Equal(int64(1)))
+			})
+
+			It("should allow larger voip payloads", func() {
+				message := fmt.Sprintf(`{ "Payload": { "aps" : { "alert" : "%s" } }, "apns_push_type": "voip" }`, strings.Repeat("a", 4200))
+				handler.sendMessage(interfaces.KafkaMessage{
+					Topic: "push-game_apns",
+					Value: []byte(message),
+				})
+				Expect(mockPushQueue.PushedNotifications()).To(HaveLen(1))
+			})
+
+			It("should truncate alert body if enabled", func() {
+				handler.truncatePayloads = true
+				message := fmt.Sprintf(`{ "Payload": { "aps" : { "alert" : { "body": "%s" } } } }`, strings.Repeat("a", 4200))
+				handler.sendMessage(interfaces.KafkaMessage{
+					Topic: "push-game_apns",
+					Value: []byte(message),
+				})
+				Expect(mockPushQueue.PushedNotifications()).To(HaveLen(1))
+				payload := mockPushQueue.PushedNotifications()[0].Payload.([]byte)
+				Expect(len(payload)).To(BeNumerically("<=", APNSMaxPayloadSize))
+				Expect(mockStatsDClient.Counts["payload_truncated"]).To(Equal(int64(1)))
+			})
+		})
+
+		Describe("APNS headers", func() {
+			It("should use app topic and no headers by default", func() {
+				handler.sendMessage(interfaces.KafkaMessage{
+					Topic: "push-game_apns",
+					Value: []byte(`{ "DeviceToken": "token", "Payload": { "aps" : { "alert" : "Hello HTTP/2" } } }`),
+				})
+				Expect(mockPushQueue.PushedNotifications()).To(HaveLen(1))
+				n := mockPushQueue.PushedNotifications()[0]
+				Expect(n.Topic).To(Equal(topic))
+				Expect(n.DeviceToken).To(Equal("token"))
+				Expect(n.Priority).To(Equal(0))
+				Expect(n.CollapseID).To(BeEmpty())
+				Expect(string(n.PushType)).To(BeEmpty())
+				Expect(n.Expiration.IsZero()).To(BeTrue())
+			})
+
+			It("should map apns fields onto the notification", func() {
+				expiration := time.Now().Add(time.Hour).Unix()
+				handler.sendMessage(interfaces.KafkaMessage{
+					Topic: "push-game_apns",
+					Value: []byte(fmt.Sprintf(`{
+						"DeviceToken": "token",
+						"Payload": { "aps" : { "alert" : "Hello HTTP/2" } },
+						"apns_priority": 5,
+						"apns_expiration": %d,
+						"apns_collapse_id": "collapse",
+						"apns_push_type": "background",
+						"apns_topic": "com.game.other"
+					}`, expiration)),
+				})
+				Expect(mockPushQueue.PushedNotifications()).To(HaveLen(1))
+				n := mockPushQueue.PushedNotifications()[0]
+				Expect(n.Topic).To(Equal("com.game.other"))
+				Expect(n.Priority).To(Equal(apns2.PriorityLow))
+				Expect(n.CollapseID).To(Equal("collapse"))
+				Expect(n.PushType).To(Equal(apns2.PushTypeBackground))
+				Expect(n.Expiration.Unix()).To(Equal(expiration))
+			})
+
+			It("should use push_expiry as expiration when apns_expiration is not given", func() {
+				pushExpiry := makeTimestamp() + int64(60000)
+				handler.sendMessage(interfaces.KafkaMessage{
+					Topic: "push-game_apns",
+					Value: []byte(fmt.Sprintf(`{ "Payload": { "aps" : { "alert" : "Hello HTTP/2" } }, "push_expiry": %d }`, pushExpiry)),
+				})
+				n := mockPushQueue.PushedNotifications()[0]
+				Expect(n.Expiration.UnixNano() / int64(time.Millisecond)).To(Equal(pushExpiry))
+			})
+
+			It("should keep an explicit zero apns_expiration", func() {
+				pushExpiry := makeTimestamp() + int64(60000)
+				handler.sendMessage(interfaces.KafkaMessage{
+					Topic: "push-game_apns",
+					Value: []byte(fmt.Sprintf(`{ "Payload": { "aps" : { "alert" : "Hello HTTP/2" } }, "push_expiry": %d, "apns_expiration": 0 }`, pushExpiry)),
+				})
+				n := mockPushQueue.PushedNotifications()[0]
+				Expect(n.Expiration.Unix()).To(Equal(int64(0)))
+			})
+		})
+
 		Describe("Clean Cache", func() {
 			It("should remove from push queue after timeout", func() {
 				handler.sendMessage(interfaces.KafkaMessage{
@@ -350,6 +728,20 @@ var _ = FDescribe("APNS Message Handler", func() {
 			})
 		})
 
+		Describe("Connection stats", func() {
+			It("should report the stats of each connection tagged by connection", func() {
+				mockPushQueue.Connections = []*structs.APNSConnectionStats{
+					{ID: 1, Requests: 10, Errors: 5, Latency: 20 * time.Millisecond, Recreated: 2},
+				}
+				handler.reportConnectionStats()
+				Expect(mockStatsDClient.Gauges["apns_connection_requests"]).To(Equal(float64(10)))
+				Expect(mockStatsDClient.Gauges["apns_connection_error_rate"]).To(Equal(0.5))
+				Expect(mockStatsDClient.Gauges["apns_connection_latency_ms"]).To(Equal(float64(20)))
+				Expect(mockStatsDClient.Gauges["apns_connection_recreated"]).To(Equal(float64(2)))
+				Expect(mockStatsDClient.Tags["apns_connection_requests"]).To(ContainElement("connection:1"))
+			})
+		})
+
 		Describe("Stats Reporter sent message", func() {
 			It("should call HandleNotificationSent upon message sent to queue", func() {
 				Expect(handler).NotTo(BeNil())
@@ -365,6 +757,17 @@ var _ = FDescribe("APNS Message Handler", func() {
 				Expect(mockStatsDClient.Counts["sent"]).To(Equal(int64(2)))
 			})
 
+			It("should report how late scheduled messages are sent", func() {
+				sendAt := time.Now().Add(-time.Minute).UnixNano() / int64(time.Millisecond)
+				handler.sendMessage(interfaces.KafkaMessage{
+					Game:  "game",
+					Topic: "push-game_apns",
+					Value: []byte(fmt.Sprintf(`{"aps": {"alert": "Hello HTTP/2"}, "send_at": %d}`, sendAt)),
+				})
+
+				Expect(mockStatsDClient.Timings["send_at_lag"]).To(BeNumerically(">=", time.Minute))
+			})
+
 			It("should call HandleNotificationSuccess upon message response received", func() {
 				Expect(handler).NotTo(BeNil())
 				Expect(handler.StatsReporters).To(Equal(statsClients))
@@ -423,6 +826,23 @@ var _ = FDescribe("APNS Message Handler", func() {
 				Expect(err).NotTo(HaveOccurred())
 			})
 
+			It("should include the topic used in metadata", func() {
+				go handler.sendMessage(interfaces.KafkaMessage{
+					Topic: "push-game_apns",
+					Value: []byte(`{ "Payload": { "aps" : {} }, "apns_push_type": "voip" }`),
+				})
+				Eventually(mockPushQueue.PushedNotifications).Should(HaveLen(1))
+				go handler.handleAPNSResponse(&structs.ResponseWithMetadata{
+					StatusCode: 200,
+					ApnsID:     mockPushQueue.PushedNotifications()[0].ApnsID,
+				})
+
+				fromKafka := &structs.ResponseWithMetadata{}
+				msg := <-mockKafkaProducerClient.ProduceChannel()
+				json.Unmarshal(msg.Value, fromKafka)
+				Expect(fromKafka.Metadata["topic"]).To(Equal("com.game.test.voip"))
+			})
+
 			It("should include a timestamp in feedback root and the hostname in metadata", func() {
 				timestampNow := time.Now().Unix()
 				hostname, err := os.Hostname()
@@ -533,6 +953,22 @@ var _ = FDescribe("APNS Message Handler", func() {
 				Expect(string(msg.Value)).To(ContainSubstring("BadMessageId"))
 			})
 
+			It("should send payload-too-large feedback without pushing", func() {
+				message := fmt.Sprintf(`{ "Payload": { "aps" : { "alert" : "%s" } }, "metadata": { "some": "metadata" } }`, strings.Repeat("a", 4200))
+				go handler.sendMessage(interfaces.KafkaMessage{
+					Topic: "push-game_apns",
+					Value: []byte(message),
+				})
+
+				fromKafka := &structs.ResponseWithMetadata{}
+				msg := <-mockKafkaProducerClient.ProduceChannel()
+				json.Unmarshal(msg.Value, fromKafka)
+				Expect(fromKafka.Reason).To(Equal(apns2.ReasonPayloadTooLarge))
+				Expect(fromKafka.Err.Key).To(Equal("payload-too-large"))
+				Expect(fromKafka.Metadata["some"]).To(Equal("metadata"))
+				Expect(mockPushQueue.PushedNotifications()).To(BeEmpty())
+			})
+
 			It("should send feedback if error and metadata is not present", func() {
 				res := &structs.ResponseWithMetadata{
 					DeviceToken: uuid.NewV4().String(),
@@ -557,6 +993,48 @@ var _ = FDescribe("APNS Message Handler", func() {
 				Expect(err).NotTo(HaveOccurred())
 				Expect(handler.PushQueue.(*mocks.APNSPushQueueMock).Closed).To(BeTrue())
 			})
+
+			It("should stop logging stats and cleaning the cache", func() {
+				done := make(chan bool, 2)
+				go func() {
+					handler.LogStats()
+					done <- true
+				}()
+				go func() {
+					handler.CleanMetadataCache()
+					done <- true
+				}()
+				Expect(handler.Cleanup()).To(Succeed())
+				Eventually(done).Should(Receive())
+				Eventually(done).Should(Receive())
+				Expect(handler.Cleanup()).To(Succeed())
+			})
+
+			It("should drop the pending retries without pushing to the closed queue", func() {
+				handler.sendMessage(interfaces.KafkaMessage{
+					Topic: "push-game_apns",
+					Value: []byte(`{ "aps" : { "alert" : "Hello HTTP/2" } }`),
+				})
+				notification := mockPushQueue.PushedNotifications()[0]
+				queue := NewAPNSPushQueue(authKeyPath, keyID, teamID, isProduction, logger, config)
+				Expect(queue.Configure()).To(Succeed())
+				handler.PushQueue = queue
+				handler.retryPolicy = &RetryPolicy{MaxAttempts: 2, BaseDelay: 50 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+				handler.handleAPNSResponse(&structs.ResponseWithMetadata{
+					StatusCode: 503,
+					ApnsID:     notification.ApnsID,
+					Reason:     apns2.ReasonServiceUnavailable,
+				})
+				Expect(handler.retryTimers.pending()).To(Equal(1))
+
+				Expect(handler.Cleanup()).To(Succeed())
+				Expect(handler.retryTimers.pending()).To(Equal(0))
+				time.Sleep(100 * time.Millisecond)
+
+				Expect(func() { handler.pushInflight(queue, notification) }).NotTo(Panic())
+				Expect(handler.InflightMessagesMetadata).NotTo(HaveKey(notification.ApnsID))
+				Expect(hook.Entries).To(ContainLogMessage("dropping inflight notification"))
+			})
 		})
 	})
 	Describe("[Integration]", func() {
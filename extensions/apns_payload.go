@@ -0,0 +1,136 @@
+/*
+ * Copyright (c) 2026 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package extensions
+
+import (
+	"encoding/json"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/sideshow/apns2"
+)
+
+// Max payload sizes accepted by APNS, in bytes
+const (
+	APNSMaxPayloadSize     = 4096
+	APNSMaxVoIPPayloadSize = 5120
+)
+
+const truncationSuffix = "\u2026"
+
+// apnsPayloadLimit returns the max payload size for the given push type
+func apnsPayloadLimit(pushType string) int {
+	if apns2.EPushType(pushType) == apns2.PushTypeVOIP {
+		return APNSMaxVoIPPayloadSize
+	}
+	return APNSMaxPayloadSize
+}
+
+// truncateAlertBody shortens aps.alert.body (or aps.alert when it is a string)
+// until the marshaled payload fits in limit. It returns the new payload and
+// whether it fits
+func truncateAlertBody(payload interface{}, limit int) ([]byte, bool) {
+	marshaled, err := json.Marshal(payload)
+	if err != nil {
+		return nil, false
+	}
+	if len(marshaled) <= limit {
+		return marshaled, true
+	}
+	get, set := alertBodyAccessors(payload)
+	if get == nil {
+		return marshaled, false
+	}
+
+	body := get()
+	for len(marshaled) > limit && body != "" {
+		body = truncateString(body, len(body)-(len(marshaled)-limit)-len(truncationSuffix))
+		if body == "" {
+			set("")
+		} else {
+			set(body + truncationSuffix)
+		}
+		marshaled, err = json.Marshal(payload)
+		if err != nil {
+			return nil, false
+		}
+	}
+	return marshaled, len(marshaled) <= limit
+}
+
+// alertBodyAccessors returns functions to read and write the alert body of an
+// aps dictionary, or nil if the payload has no alert body
+func alertBodyAccessors(payload interface{}) (func() string, func(string)) {
+	p, ok := payload.(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	aps, ok := p["aps"].(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	switch alert := aps["alert"].(type) {
+	case string:
+		return func() string { return aps["alert"].(string) },
+			func(body string) { aps["alert"] = body }
+	case map[string]interface{}:
+		if _, ok := alert["body"].(string); !ok {
+			return nil, nil
+		}
+		return func() string { return alert["body"].(string) },
+			func(body string) { alert["body"] = body }
+	}
+	return nil, nil
+}
+
+// truncateString cuts s to at most size bytes without breaking UTF-8 sequences
+// or separating combining marks, variation selectors and zero width joiners
+// from the character they belong to
+func truncateString(s string, size int) string {
+	if size <= 0 {
+		return ""
+	}
+	if size >= len(s) {
+		return s
+	}
+	for size > 0 && !utf8.RuneStart(s[size]) {
+		size--
+	}
+	for size > 0 {
+		next, _ := utf8.DecodeRuneInString(s[size:])
+		last, lastSize := utf8.DecodeLastRuneInString(s[:size])
+		if !isGraphemeExtend(next) && last != zeroWidthJoiner {
+			break
+		}
+		size -= lastSize
+	}
+	return s[:size]
+}
+
+const zeroWidthJoiner = '\u200d'
+
+func isGraphemeExtend(r rune) bool {
+	return r == zeroWidthJoiner ||
+		unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc, unicode.Variation_Selector) ||
+		(r >= 0x1F3FB && r <= 0x1F3FF) // emoji skin tone modifiers
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright (c) 2026 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package extensions
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("APNS Payload", func() {
+	Describe("[Unit]", func() {
+		Describe("Payload limit", func() {
+			It("should return 4KB for regular pushes", func() {
+				Expect(apnsPayloadLimit("")).To(Equal(4096))
+				Expect(apnsPayloadLimit("alert")).To(Equal(4096))
+			})
+
+			It("should return 5KB for voip pushes", func() {
+				Expect(apnsPayloadLimit("voip")).To(Equal(5120))
+			})
+		})
+
+		Describe("Truncating alert body", func() {
+			It("should not change payloads that fit", func() {
+				payload := map[string]interface{}{
+					"aps": map[string]interface{}{"alert": "Hello"},
+				}
+				res, fits := truncateAlertBody(payload, 100)
+				Expect(fits).To(BeTrue())
+				Expect(string(res)).To(Equal(`{"aps":{"alert":"Hello"}}`))
+			})
+
+			It("should truncate alert body", func() {
+				payload := map[string]interface{}{
+					"aps": map[string]interface{}{
+						"alert": map[string]interface{}{
+							"title": "Title",
+							"body":  strings.Repeat("a", 200),
+						},
+					},
+				}
+				res, fits := truncateAlertBody(payload, 100)
+				Expect(fits).To(BeTrue())
+				Expect(len(res)).To(BeNumerically("<=", 100))
+				parsed := map[string]interface{}{}
+				Expect(json.Unmarshal(res, &parsed)).To(Succeed())
+				body := parsed["aps"].(map[string]interface{})["alert"].(map[string]interface{})["body"].(string)
+				Expect(body).To(HaveSuffix("…"))
+				Expect(body).To(HavePrefix("aaa"))
+			})
+
+			It("should truncate string alerts", func() {
+				payload := map[string]interface{}{
+					"aps": map[string]interface{}{"alert": strings.Repeat("á", 100)},
+				}
+				res, fits := truncateAlertBody(payload, 100)
+				Expect(fits).To(BeTrue())
+				Expect(len(res)).To(BeNumerically("<=", 100))
+				Expect(utf8.Valid(res)).To(BeTrue())
+			})
+
+			It("should not fit if there is no alert body", func() {
+				payload := map[string]interface{}{
+					"aps":  map[string]interface{}{"badge": 1},
+					"data": strings.Repeat("a", 200),
+				}
+				_, fits := truncateAlertBody(payload, 100)
+				Expect(fits).To(BeFalse())
+			})
+
+			It("should not fit if custom data alone is too large", func() {
+				payload := map[string]interface{}{
+					"aps":  map[string]interface{}{"alert": "Hello"},
+					"data": strings.Repeat("a", 200),
+				}
+				_, fits := truncateAlertBody(payload, 100)
+				Expect(fits).To(BeFalse())
+			})
+		})
+
+		Describe("Truncating strings", func() {
+			It("should not break utf-8 sequences", func() {
+				Expect(truncateString("ááá", 3)).To(Equal("á"))
+			})
+
+			It("should keep combining marks with their character", func() {
+				Expect(truncateString("aé", 3)).To(Equal("a"))
+			})
+
+			It("should keep emoji sequences together", func() {
+				family := "\U0001F468‍\U0001F469‍\U0001F467"
+				Expect(truncateString("a"+family, 10)).To(Equal("a"))
+				Expect(truncateString("a\U0001F44D\U0001F3FD", 6)).To(Equal("a"))
+			})
+
+			It("should return the whole string if it fits", func() {
+				Expect(truncateString("abc", 10)).To(Equal("abc"))
+			})
+		})
+	})
+})
@@ -23,27 +23,66 @@
 package extensions
 
 import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 
 	"github.com/sideshow/apns2"
+	"github.com/sideshow/apns2/certificate"
 	token "github.com/sideshow/apns2/token"
+	"github.com/topfreegames/pusher/errors"
+	"github.com/topfreegames/pusher/interfaces"
 	"github.com/topfreegames/pusher/structs"
+	"golang.org/x/net/http2"
+)
+
+// Authentication types supported by the APNSPushQueue
+const (
+	APNSAuthTypeToken       = "token"
+	APNSAuthTypeCertificate = "certificate"
 )
 
+// ReasonConnectionError is the reason of the responses created when the request
+// to APNS fails before any response is received (network, TLS or timeout errors)
+const ReasonConnectionError = "ConnectionError"
+
+var errPushQueueClosed = fmt.Errorf("apns push queue is closed")
+
 // APNSPushQueue implements interfaces.APNSPushQueue
 type APNSPushQueue struct {
-	authKeyPath     string
-	keyID           string
-	teamID          string
-	token           *token.Token
-	pushChannel     chan *apns2.Notification
-	responseChannel chan *structs.ResponseWithMetadata
-	Logger          *log.Logger
-	Config          *viper.Viper
-	clients         chan *apns2.Client
-	IsProduction    bool
-	Closed          bool
+	authType           string
+	authKeyPath        string
+	keyID              string
+	teamID             string
+	token              *token.Token
+	tokenMutex         sync.RWMutex
+	authKeyHash        [sha256.Size]byte
+	appName            string
+	certificatePath    string
+	passphrase         string
+	certificate        tls.Certificate
+	pushChannel        chan *apns2.Notification
+	responseChannel    chan *structs.ResponseWithMetadata
+	stopChannel        chan struct{}
+	closeLock          sync.RWMutex
+	workers            sync.WaitGroup
+	Logger             *log.Logger
+	Config             *viper.Viper
+	StatsReporters     []interfaces.StatsReporter
+	pool               *APNSConnectionPool
+	connectionPoolSize int
+	concurrentWorkers  int
+	IsProduction       bool
+	Closed             bool
 }
 
 // NewAPNSPushQueue returns a new instance of a APNSPushQueue
@@ -55,6 +94,7 @@ func NewAPNSPushQueue(
 	config *viper.Viper,
 ) *APNSPushQueue {
 	return &APNSPushQueue{
+		authType:     APNSAuthTypeToken,
 		authKeyPath:  authKeyPath,
 		keyID:        keyID,
 		teamID:       teamID,
@@ -64,50 +104,262 @@ func NewAPNSPushQueue(
 	}
 }
 
+// NewAPNSCertificatePushQueue returns a new instance of a APNSPushQueue that
+// authenticates using a .p12 or .pem push certificate
+func NewAPNSCertificatePushQueue(
+	certificatePath, passphrase string,
+	isProduction bool,
+	logger *log.Logger,
+	config *viper.Viper,
+) *APNSPushQueue {
+	return &APNSPushQueue{
+		authType:        APNSAuthTypeCertificate,
+		certificatePath: certificatePath,
+		passphrase:      passphrase,
+		Logger:          logger,
+		Config:          config,
+		IsProduction:    isProduction,
+	}
+}
+
+func (p *APNSPushQueue) loadConfigurationDefaults() {
+	p.Config.SetDefault("apns.connectionPoolSize", 1)
+	p.Config.SetDefault("apns.concurrentWorkers", 10)
+	p.Config.SetDefault("apns.certificateExpirationWarningDays", 30)
+	p.Config.SetDefault("apns.authKeyReloadInterval", 60000)
+}
+
 // Configure configures queues and token
 func (p *APNSPushQueue) Configure() error {
 	l := p.Logger.WithField("method", "configure")
+	p.loadConfigurationDefaults()
 	err := p.configureCertificate()
 	if err != nil {
 		return err
 	}
 	p.Closed = false
-	connectionPoolSize := p.Config.GetInt("apns.connectionPoolSize")
-	p.clients = make(chan *apns2.Client, connectionPoolSize)
-	for i := 0; i < connectionPoolSize; i++ {
-		client := apns2.NewTokenClient(p.token)
-		if p.IsProduction {
-			client = client.Production()
-		} else {
-			client = client.Development()
-		}
-		p.clients <- client
+	if p.connectionPoolSize == 0 {
+		p.connectionPoolSize = p.Config.GetInt("apns.connectionPoolSize")
+	}
+	if p.concurrentWorkers == 0 {
+		p.concurrentWorkers = p.Config.GetInt("apns.concurrentWorkers")
 	}
-	l.Debug("clients configured")
+	p.pool = NewAPNSConnectionPool(p.connectionPoolSize, p.newClient, p.Logger, p.Config)
+	l.WithFields(log.Fields{
+		"connectionPoolSize": p.pool.Size(),
+		"concurrentWorkers":  p.concurrentWorkers,
+	}).Debug("clients configured")
 	p.pushChannel = make(chan *apns2.Notification)
 	p.responseChannel = make(chan *structs.ResponseWithMetadata)
 
-	for i := 0; i < p.Config.GetInt("apns.concurrentWorkers"); i++ {
+	p.workers.Add(p.concurrentWorkers)
+	for i := 0; i < p.concurrentWorkers; i++ {
 		go p.pushWorker()
 	}
+
+	p.stopChannel = make(chan struct{})
+	interval := p.Config.GetInt("apns.authKeyReloadInterval")
+	if p.authType != APNSAuthTypeCertificate && interval > 0 {
+		go p.watchAuthKey(time.Duration(interval) * time.Millisecond)
+	}
 	return nil
 }
 
+func (p *APNSPushQueue) newClient() *apns2.Client {
+	var client *apns2.Client
+	if p.authType == APNSAuthTypeCertificate {
+		client = apns2.NewClient(p.certificate)
+	} else {
+		p.tokenMutex.RLock()
+		client = apns2.NewTokenClient(p.token)
+		p.tokenMutex.RUnlock()
+	}
+	if p.IsProduction {
+		client = client.Production()
+	} else {
+		client = client.Development()
+	}
+	// overrides used to send pushes to a fake APNS server in tests
+	if host := p.Config.GetString("apns.host"); host != "" {
+		client.Host = host
+	}
+	if p.Config.GetBool("apns.insecureSkipVerify") {
+		if transport, ok := client.HTTPClient.Transport.(*http2.Transport); ok {
+			if transport.TLSClientConfig == nil {
+				transport.TLSClientConfig = &tls.Config{}
+			}
+			transport.TLSClientConfig.InsecureSkipVerify = true
+		}
+	}
+	return client
+}
+
 func (p *APNSPushQueue) configureCertificate() error {
-	l := p.Logger.WithField("method", "configureCertificate")
-	authKey, err := token.AuthKeyFromFile(p.authKeyPath)
+	switch p.authType {
+	case APNSAuthTypeCertificate:
+		return p.configureTLSCertificate()
+	case APNSAuthTypeToken, "":
+		return p.configureToken()
+	default:
+		return fmt.Errorf("invalid apns auth type: %s", p.authType)
+	}
+}
+
+func (p *APNSPushQueue) configureToken() error {
+	l := p.Logger.WithField("method", "configureToken")
+	t, hash, err := p.loadToken(p.authKeyPath, p.keyID, p.teamID)
 	if err != nil {
 		l.WithError(err).Error("token error")
 		return err
 	}
-	p.token = &token.Token{
+	p.tokenMutex.Lock()
+	p.token = t
+	p.authKeyHash = hash
+	p.tokenMutex.Unlock()
+	l.Debug("token loaded")
+	return nil
+}
+
+func (p *APNSPushQueue) loadToken(authKeyPath, keyID, teamID string) (*token.Token, [sha256.Size]byte, error) {
+	var hash [sha256.Size]byte
+	content, err := ioutil.ReadFile(authKeyPath)
+	if err != nil {
+		return nil, hash, err
+	}
+	authKey, err := token.AuthKeyFromBytes(content)
+	if err != nil {
+		return nil, hash, err
+	}
+	return &token.Token{
 		AuthKey: authKey,
 		// KeyID from developer account (Certificates, Identifiers & Profiles -> Keys)
-		KeyID: p.keyID,
+		KeyID: keyID,
 		// TeamID from developer account (View Account -> Membership)
-		TeamID: p.teamID,
+		TeamID: teamID,
+	}, sha256.Sum256(content), nil
+}
+
+// watchAuthKey reloads the token whenever the key file or the app keyID and
+// teamID change, so keys can be rotated without restarting
+func (p *APNSPushQueue) watchAuthKey(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stopChannel:
+			return
+		case <-ticker.C:
+			p.reloadToken()
+		}
+	}
+}
+
+// reloadToken swaps the token and the clients using it if the key changed.
+// Pushes in flight finish with the clients they started with
+func (p *APNSPushQueue) reloadToken() (bool, error) {
+	keyID, teamID := p.authKeyIDs()
+	l := p.Logger.WithFields(log.Fields{
+		"method":      "reloadToken",
+		"authKeyPath": p.authKeyPath,
+		"keyID":       keyID,
+		"teamID":      teamID,
+	})
+
+	content, err := ioutil.ReadFile(p.authKeyPath)
+	if err != nil {
+		l.WithError(err).Error("error reading auth key")
+		statsReporterReportMetricCount(p.StatsReporters, "apns_key_reload_failure", 1, p.appName, "apns")
+		return false, err
+	}
+	p.tokenMutex.RLock()
+	unchanged := sha256.Sum256(content) == p.authKeyHash && keyID == p.token.KeyID && teamID == p.token.TeamID
+	p.tokenMutex.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	t, hash, err := p.loadToken(p.authKeyPath, keyID, teamID)
+	if err != nil {
+		l.WithError(err).Error("error reloading auth key, keeping the current one")
+		statsReporterReportMetricCount(p.StatsReporters, "apns_key_reload_failure", 1, p.appName, "apns")
+		return false, err
+	}
+	p.tokenMutex.Lock()
+	p.token = t
+	p.authKeyHash = hash
+	p.tokenMutex.Unlock()
+	if p.pool != nil {
+		p.pool.RecreateAll()
+	}
+	l.Info("auth key reloaded")
+	statsReporterReportMetricCount(p.StatsReporters, "apns_key_reload_success", 1, p.appName, "apns")
+	return true, nil
+}
+
+// authKeyIDs returns the keyID and teamID of the app in the config, falling
+// back to the ones the queue was created with
+func (p *APNSPushQueue) authKeyIDs() (string, string) {
+	keyID, teamID := p.keyID, p.teamID
+	if p.appName == "" {
+		return keyID, teamID
+	}
+	if id := p.Config.GetString("apns.certs." + p.appName + ".keyID"); id != "" {
+		keyID = id
+	}
+	if id := p.Config.GetString("apns.certs." + p.appName + ".teamID"); id != "" {
+		teamID = id
+	}
+	return keyID, teamID
+}
+
+func (p *APNSPushQueue) configureTLSCertificate() error {
+	l := p.Logger.WithFields(log.Fields{
+		"method":          "configureTLSCertificate",
+		"certificatePath": p.certificatePath,
+	})
+	var cert tls.Certificate
+	var err error
+	if strings.ToLower(filepath.Ext(p.certificatePath)) == ".p12" {
+		cert, err = certificate.FromP12File(p.certificatePath, p.passphrase)
+	} else {
+		cert, err = certificate.FromPemFile(p.certificatePath, p.passphrase)
+	}
+	if err != nil {
+		l.WithError(err).Error("certificate error")
+		return err
+	}
+	err = p.validateCertificate(cert)
+	if err != nil {
+		l.WithError(err).Error("certificate validation error")
+		return err
+	}
+	p.certificate = cert
+	l.Debug("certificate loaded")
+	return nil
+}
+
+// validateCertificate fails if the certificate has already expired and warns
+// when it is about to expire
+func (p *APNSPushQueue) validateCertificate(cert tls.Certificate) error {
+	l := p.Logger.WithField("method", "validateCertificate")
+	if len(cert.Certificate) == 0 {
+		return certificate.ErrNoCertificate
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if now.After(leaf.NotAfter) {
+		return fmt.Errorf("certificate %s expired at %s", leaf.Subject.CommonName, leaf.NotAfter.Format(time.RFC3339))
+	}
+	warningDays := p.Config.GetInt("apns.certificateExpirationWarningDays")
+	if leaf.NotAfter.Before(now.AddDate(0, 0, warningDays)) {
+		l.WithFields(log.Fields{
+			"subject":  leaf.Subject.CommonName,
+			"notAfter": leaf.NotAfter,
+		}).Warn("certificate is about to expire")
 	}
-	l.Debug("token loaded")
 	return nil
 }
 
@@ -118,16 +370,23 @@ func (p *APNSPushQueue) ResponseChannel() chan *structs.ResponseWithMetadata {
 
 func (p *APNSPushQueue) pushWorker() {
 	l := p.Logger.WithField("method", "pushWorker")
+	defer p.workers.Done()
 
 	for notification := range p.pushChannel {
-		client := <-p.clients
-		p.clients <- client
-
+		conn, client := p.pool.Get()
+		start := time.Now()
 		res, err := client.Push(notification)
+		p.pool.Record(conn, client, time.Since(start), res == nil || res.StatusCode >= 500)
 		if err != nil {
 			l.WithError(err).Error("push error")
 		}
 		if res == nil {
+			p.responseChannel <- &structs.ResponseWithMetadata{
+				Reason:      ReasonConnectionError,
+				ApnsID:      notification.ApnsID,
+				Err:         errors.NewPushError("connection-error", errorDescription(err)),
+				DeviceToken: notification.DeviceToken,
+			}
 			continue
 		}
 		newRes := &structs.ResponseWithMetadata{
@@ -142,14 +401,47 @@ func (p *APNSPushQueue) pushWorker() {
 	}
 }
 
-// Push sends the notification
-func (p *APNSPushQueue) Push(notification *apns2.Notification) {
+func errorDescription(err error) string {
+	if err == nil {
+		return "no response from apns"
+	}
+	return err.Error()
+}
+
+// ConnectionStats returns the health of each connection used by the queue
+func (p *APNSPushQueue) ConnectionStats() []*structs.APNSConnectionStats {
+	if p.pool == nil {
+		return nil
+	}
+	return p.pool.Stats()
+}
+
+// Push sends the notification. It fails once the queue is closed, as it may
+// be called by retries scheduled before the queue was closed
+func (p *APNSPushQueue) Push(notification *apns2.Notification) error {
+	p.closeLock.RLock()
+	defer p.closeLock.RUnlock()
+	if p.Closed {
+		return errPushQueueClosed
+	}
 	p.pushChannel <- notification
+	return nil
 }
 
-// Close close all the open channels
+// Close close all the open channels. The response channel is closed after the
+// workers send the responses of the pushes in progress
 func (p *APNSPushQueue) Close() {
+	p.closeLock.Lock()
+	if p.Closed {
+		p.closeLock.Unlock()
+		return
+	}
+	p.Closed = true
+	if p.stopChannel != nil {
+		close(p.stopChannel)
+	}
 	close(p.pushChannel)
+	p.closeLock.Unlock()
+	p.workers.Wait()
 	close(p.responseChannel)
-	p.Closed = true
 }
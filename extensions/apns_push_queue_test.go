@@ -23,11 +23,27 @@
 package extensions
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/sideshow/apns2"
 	"github.com/sirupsen/logrus"
 	"github.com/sirupsen/logrus/hooks/test"
-	// . "github.com/topfreegames/pusher/testing"
+	"github.com/topfreegames/pusher/interfaces"
+	"github.com/topfreegames/pusher/mocks"
+	"github.com/topfreegames/pusher/structs"
+	. "github.com/topfreegames/pusher/testing"
 	"github.com/topfreegames/pusher/util"
 )
 
@@ -85,6 +101,333 @@ var _ = Describe("APNS Message Handler", func() {
 				err := queue.configureCertificate()
 				Expect(err).To(HaveOccurred())
 			})
+
+			It("should fail if invalid auth type", func() {
+				queue.authType = "invalid"
+				err := queue.configureCertificate()
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal("invalid apns auth type: invalid"))
+			})
+		})
+
+		Describe("Push worker", func() {
+			It("should send connection error response if request fails", func() {
+				err := queue.Configure()
+				Expect(err).NotTo(HaveOccurred())
+				for _, conn := range queue.pool.connections {
+					conn.client.Host = "https://127.0.0.1:1"
+				}
+
+				queue.Push(&apns2.Notification{
+					ApnsID:      "idTest1",
+					DeviceToken: "token",
+					Payload:     []byte(`{"aps":{"alert":"Hello"}}`),
+				})
+
+				var res *structs.ResponseWithMetadata
+				Eventually(queue.responseChannel, 5*time.Second).Should(Receive(&res))
+				Expect(res.Reason).To(Equal(ReasonConnectionError))
+				Expect(res.ApnsID).To(Equal("idTest1"))
+				Expect(res.DeviceToken).To(Equal("token"))
+				Expect(res.Err).NotTo(BeNil())
+				Expect(res.Err.Description).NotTo(BeEmpty())
+			})
+
+			It("should fail to push once closed", func() {
+				err := queue.Configure()
+				Expect(err).NotTo(HaveOccurred())
+				queue.Close()
+				Expect(queue.ResponseChannel()).To(BeClosed())
+
+				err = queue.Push(&apns2.Notification{ApnsID: "idTest1", DeviceToken: "token"})
+				Expect(err).To(HaveOccurred())
+				Expect(func() { queue.Close() }).NotTo(Panic())
+			})
+		})
+
+		Describe("Sending to fake APNS server", func() {
+			var server *APNSServer
+
+			BeforeEach(func() {
+				var err error
+				server, err = NewAPNSServer("../tls/self_signed_cert.pem")
+				Expect(err).NotTo(HaveOccurred())
+				serverConfig, _ := util.NewViperWithConfigFile(configFile)
+				serverConfig.Set("apns.host", server.URL)
+				serverConfig.Set("apns.insecureSkipVerify", true)
+				serverConfig.Set("apns.concurrentWorkers", 2)
+				queue.Config = serverConfig
+				Expect(queue.Configure()).To(Succeed())
+			})
+
+			AfterEach(func() {
+				queue.Close()
+				server.Close()
+			})
+
+			push := func(deviceToken string) *structs.ResponseWithMetadata {
+				queue.Push(&apns2.Notification{
+					ApnsID:      "1f7b3e2a-0d4c-4a8e-9b6f-3c2d1e0f4a5b",
+					DeviceToken: deviceToken,
+					Topic:       "com.game.test",
+					PushType:    apns2.EPushType("alert"),
+					Payload:     []byte(`{"aps":{"alert":"Hello"}}`),
+				})
+				var res *structs.ResponseWithMetadata
+				Eventually(queue.responseChannel, 5*time.Second).Should(Receive(&res))
+				return res
+			}
+
+			It("should parse successful responses", func() {
+				res := push("token")
+				Expect(res.Sent).To(BeTrue())
+				Expect(res.StatusCode).To(Equal(200))
+				Expect(res.ApnsID).To(Equal("1f7b3e2a-0d4c-4a8e-9b6f-3c2d1e0f4a5b"))
+				Expect(res.DeviceToken).To(Equal("token"))
+
+				requests := server.Requests()
+				Expect(requests).To(HaveLen(1))
+				Expect(requests[0].Topic).To(Equal("com.game.test"))
+				Expect(requests[0].PushType).To(Equal("alert"))
+				Expect(string(requests[0].Payload)).To(Equal(`{"aps":{"alert":"Hello"}}`))
+			})
+
+			It("should parse error responses", func() {
+				server.ScriptResponses("bad-token", &APNSResponse{
+					StatusCode: 400,
+					Reason:     apns2.ReasonBadDeviceToken,
+				})
+				res := push("bad-token")
+				Expect(res.Sent).To(BeFalse())
+				Expect(res.StatusCode).To(Equal(400))
+				Expect(res.Reason).To(Equal(apns2.ReasonBadDeviceToken))
+			})
+
+			It("should use scripted responses in order", func() {
+				server.ScriptResponses("token",
+					&APNSResponse{StatusCode: 503, Reason: apns2.ReasonServiceUnavailable},
+					&APNSResponse{StatusCode: 200},
+				)
+				Expect(push("token").Reason).To(Equal(apns2.ReasonServiceUnavailable))
+				Expect(push("token").Sent).To(BeTrue())
+				Expect(push("token").Sent).To(BeTrue())
+			})
+
+			It("should report connection error if connection drops", func() {
+				server.ScriptResponses("token", &APNSResponse{DropConnection: true})
+				res := push("token")
+				Expect(res.Reason).To(Equal(ReasonConnectionError))
+				Expect(queue.ConnectionStats()[0].Errors).To(Equal(int64(1)))
+			})
+
+			It("should track connection latency", func() {
+				server.ScriptResponses("token", &APNSResponse{StatusCode: 200, Latency: 50 * time.Millisecond})
+				push("token")
+				Expect(queue.ConnectionStats()[0].Latency).To(BeNumerically(">=", 50*time.Millisecond))
+			})
+		})
+
+		Describe("Reloading auth key", func() {
+			var mockStatsDClient *mocks.StatsDClientMock
+			var keyPath string
+
+			writeKey := func(path string) {
+				key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+				Expect(err).NotTo(HaveOccurred())
+				der, err := x509.MarshalPKCS8PrivateKey(key)
+				Expect(err).NotTo(HaveOccurred())
+				content := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+				Expect(ioutil.WriteFile(path, content, 0600)).To(Succeed())
+			}
+
+			BeforeEach(func() {
+				dir, err := ioutil.TempDir("", "pusher")
+				Expect(err).NotTo(HaveOccurred())
+				keyPath = filepath.Join(dir, "authkey.p8")
+				writeKey(keyPath)
+
+				mockStatsDClient = mocks.NewStatsDClientMock()
+				statsD, err := NewStatsD(config, logger, mockStatsDClient)
+				Expect(err).NotTo(HaveOccurred())
+
+				queue.authKeyPath = keyPath
+				queue.StatsReporters = []interfaces.StatsReporter{statsD}
+				Expect(queue.Configure()).To(Succeed())
+			})
+
+			AfterEach(func() {
+				os.RemoveAll(filepath.Dir(keyPath))
+			})
+
+			It("should not reload if nothing changed", func() {
+				reloaded, err := queue.reloadToken()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(reloaded).To(BeFalse())
+			})
+
+			It("should swap token and clients if key file changed", func() {
+				oldToken := queue.token
+				_, oldClient := queue.pool.Get()
+				writeKey(keyPath)
+
+				reloaded, err := queue.reloadToken()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(reloaded).To(BeTrue())
+				Expect(queue.token).NotTo(BeIdenticalTo(oldToken))
+				Expect(queue.token.KeyID).To(Equal(keyID))
+				_, newClient := queue.pool.Get()
+				Expect(newClient).NotTo(BeIdenticalTo(oldClient))
+				Expect(newClient.Token).To(BeIdenticalTo(queue.token))
+				Expect(mockStatsDClient.Counts["apns_key_reload_success"]).To(Equal(int64(1)))
+			})
+
+			It("should reload if app key id changed", func() {
+				oldToken := queue.token
+				appConfig, _ := util.NewViperWithConfigFile(configFile)
+				appConfig.Set("apns.certs.game.keyID", "NEWKEY1234")
+				appConfig.Set("apns.certs.game.teamID", teamID)
+				queue.Config = appConfig
+				queue.appName = "game"
+
+				reloaded, err := queue.reloadToken()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(reloaded).To(BeTrue())
+				Expect(queue.token).NotTo(BeIdenticalTo(oldToken))
+				Expect(queue.token.KeyID).To(Equal("NEWKEY1234"))
+				Expect(queue.token.TeamID).To(Equal(teamID))
+				_, newClient := queue.pool.Get()
+				Expect(newClient.Token).To(BeIdenticalTo(queue.token))
+				Expect(mockStatsDClient.Counts["apns_key_reload_success"]).To(Equal(int64(1)))
+			})
+
+			It("should reload if app team id changed", func() {
+				appConfig, _ := util.NewViperWithConfigFile(configFile)
+				appConfig.Set("apns.certs.game.keyID", keyID)
+				appConfig.Set("apns.certs.game.teamID", "NEWTEAM123")
+				queue.Config = appConfig
+				queue.appName = "game"
+
+				reloaded, err := queue.reloadToken()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(reloaded).To(BeTrue())
+				Expect(queue.token.KeyID).To(Equal(keyID))
+				Expect(queue.token.TeamID).To(Equal("NEWTEAM123"))
+				Expect(mockStatsDClient.Counts["apns_key_reload_success"]).To(Equal(int64(1)))
+			})
+
+			It("should not reload if app key id and team id did not change", func() {
+				appConfig, _ := util.NewViperWithConfigFile(configFile)
+				appConfig.Set("apns.certs.game.keyID", keyID)
+				appConfig.Set("apns.certs.game.teamID", teamID)
+				queue.Config = appConfig
+				queue.appName = "game"
+
+				reloaded, err := queue.reloadToken()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(reloaded).To(BeFalse())
+			})
+
+			It("should keep current token if key id changed and key is invalid", func() {
+				oldToken := queue.token
+				appConfig, _ := util.NewViperWithConfigFile(configFile)
+				appConfig.Set("apns.certs.game.keyID", "NEWKEY1234")
+				appConfig.Set("apns.certs.game.teamID", teamID)
+				queue.Config = appConfig
+				queue.appName = "game"
+				Expect(ioutil.WriteFile(keyPath, []byte("invalid"), 0600)).To(Succeed())
+
+				reloaded, err := queue.reloadToken()
+				Expect(err).To(HaveOccurred())
+				Expect(reloaded).To(BeFalse())
+				Expect(queue.token).To(BeIdenticalTo(oldToken))
+				Expect(mockStatsDClient.Counts["apns_key_reload_failure"]).To(Equal(int64(1)))
+			})
+
+			It("should keep current token if new key is invalid", func() {
+				oldToken := queue.token
+				Expect(ioutil.WriteFile(keyPath, []byte("invalid"), 0600)).To(Succeed())
+
+				reloaded, err := queue.reloadToken()
+				Expect(err).To(HaveOccurred())
+				Expect(reloaded).To(BeFalse())
+				Expect(queue.token).To(BeIdenticalTo(oldToken))
+				Expect(mockStatsDClient.Counts["apns_key_reload_failure"]).To(Equal(int64(1)))
+				Expect(hook.Entries).To(ContainLogMessage("error reloading auth key, keeping the current one"))
+			})
+		})
+
+		Describe("Configuring push certificate", func() {
+			var certificatePath string
+
+			writeCertificate := func(notAfter time.Time) {
+				key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+				Expect(err).NotTo(HaveOccurred())
+				template := &x509.Certificate{
+					SerialNumber: big.NewInt(1),
+					Subject:      pkix.Name{CommonName: "com.game.test"},
+					NotBefore:    notAfter.AddDate(-1, 0, 0),
+					NotAfter:     notAfter,
+				}
+				der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+				Expect(err).NotTo(HaveOccurred())
+				keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+				Expect(err).NotTo(HaveOccurred())
+				content := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+				content = append(content, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer})...)
+				Expect(ioutil.WriteFile(certificatePath, content, 0600)).To(Succeed())
+			}
+
+			BeforeEach(func() {
+				dir, err := ioutil.TempDir("", "pusher")
+				Expect(err).NotTo(HaveOccurred())
+				certificatePath = filepath.Join(dir, "certificate.pem")
+				writeCertificate(time.Now().AddDate(1, 0, 0))
+
+				queue = NewAPNSCertificatePushQueue(
+					certificatePath,
+					"",
+					isProduction,
+					logger,
+					config,
+				)
+			})
+
+			AfterEach(func() {
+				os.RemoveAll(filepath.Dir(certificatePath))
+			})
+
+			It("should configure from pem file", func() {
+				err := queue.Configure()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(queue.certificate.Certificate).NotTo(BeEmpty())
+				Expect(queue.token).To(BeNil())
+			})
+
+			It("should warn if certificate is about to expire", func() {
+				writeCertificate(time.Now().Add(24 * time.Hour))
+				err := queue.configureCertificate()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(hook.Entries).To(ContainLogMessage("certificate is about to expire"))
+			})
+
+			It("should fail if certificate file does not exist", func() {
+				queue.certificatePath = "./invalid-certficate.p12"
+				err := queue.configureCertificate()
+				Expect(err).To(HaveOccurred())
+			})
+
+			It("should fail if pem file has no certificate", func() {
+				queue.certificatePath = "../tls/_fixtures/certificate-no-certificate.pem"
+				err := queue.configureCertificate()
+				Expect(err).To(HaveOccurred())
+			})
+
+			It("should fail if certificate is expired", func() {
+				writeCertificate(time.Now().Add(-time.Hour))
+				err := queue.configureCertificate()
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("expired at"))
+			})
 		})
 	})
 })
@@ -0,0 +1,109 @@
+/*
+ * Copyright (c) 2026 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package extensions
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/viper"
+	"github.com/topfreegames/pusher/interfaces"
+)
+
+// App is an app of a game in a platform. Its credentials override the
+// <platform>.certs.<game> settings of the config. Err is set when the
+// credentials of the app could not be parsed
+type App struct {
+	Game        string
+	Platform    string
+	Credentials map[string]string
+	Err         error
+}
+
+// AppStore keeps the apps of all games in the table in registry.table
+type AppStore struct {
+	Client *PGClient
+	Config *viper.Viper
+	table  string
+}
+
+// NewAppStore returns a new AppStore
+func NewAppStore(config *viper.Viper, dbOrNil ...interfaces.DB) (*AppStore, error) {
+	s := &AppStore{
+		Config: config,
+	}
+	s.loadConfigurationDefaults()
+	s.table = config.GetString("registry.table")
+	var db interfaces.DB
+	if len(dbOrNil) == 1 {
+		db = dbOrNil[0]
+	}
+	client, err := NewPGClient("registry.pg", config, db)
+	if err != nil {
+		return nil, err
+	}
+	s.Client = client
+	return s, nil
+}
+
+func (s *AppStore) loadConfigurationDefaults() {
+	s.Config.SetDefault("registry.table", "apps")
+}
+
+// List returns the apps of the platform. The apps with malformed credentials
+// are returned with Err set, so they don't prevent listing the others
+func (s *AppStore) List(platform string) ([]*App, error) {
+	var rows []struct {
+		Game        string
+		Platform    string
+		Credentials string
+	}
+	_, err := s.Client.DB.Query(&rows, fmt.Sprintf(
+		"SELECT game, platform, credentials FROM %s WHERE platform = ?0",
+		s.table,
+	), platform)
+	if err != nil {
+		return nil, err
+	}
+	apps := make([]*App, 0, len(rows))
+	for _, row := range rows {
+		app := &App{
+			Game:        row.Game,
+			Platform:    row.Platform,
+			Credentials: map[string]string{},
+		}
+		if row.Credentials != "" {
+			err = json.Unmarshal([]byte(row.Credentials), &app.Credentials)
+			if err != nil {
+				app.Err = fmt.Errorf("invalid credentials of app %s: %s", row.Game, err.Error())
+			}
+		}
+		apps = append(apps, app)
+	}
+	return apps, nil
+}
+
+// Cleanup closes the connection to PG
+func (s *AppStore) Cleanup() error {
+	return s.Client.Cleanup()
+}
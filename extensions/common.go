@@ -25,6 +25,7 @@ package extensions
 import (
 	"encoding/json"
 	"regexp"
+	"time"
 
 	"github.com/topfreegames/pusher/errors"
 	"github.com/topfreegames/pusher/interfaces"
@@ -64,20 +65,58 @@ func sendToFeedbackReporters(feedbackReporters []interfaces.FeedbackReporter, re
 	return nil
 }
 
-func statsReporterHandleNotificationSent(statsReporters []interfaces.StatsReporter, game string, platform string) {
+func sendToUpstreamReporters(upstreamReporters []interfaces.UpstreamReporter, res interface{}, topic ParsedTopic) error {
+	jres, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	for _, upstreamReporter := range upstreamReporters {
+		upstreamReporter.SendUpstreamMessage(topic.Game, topic.Platform, jres)
+	}
+	return nil
+}
+
+func statsReporterHandleNotificationSent(statsReporters []interfaces.StatsReporter, game string, platform string, tags ...string) {
+	for _, statsReporter := range statsReporters {
+		statsReporter.HandleNotificationSent(game, platform, tags...)
+	}
+}
+
+func statsReporterHandleNotificationSuccess(statsReporters []interfaces.StatsReporter, game string, platform string, tags ...string) {
+	for _, statsReporter := range statsReporters {
+		statsReporter.HandleNotificationSuccess(game, platform, tags...)
+	}
+}
+
+func statsReporterHandleNotificationFailure(statsReporters []interfaces.StatsReporter, game string, platform string, err *errors.PushError, tags ...string) {
 	for _, statsReporter := range statsReporters {
-		statsReporter.HandleNotificationSent(game, platform)
+		statsReporter.HandleNotificationFailure(game, platform, err, tags...)
 	}
 }
 
-func statsReporterHandleNotificationSuccess(statsReporters []interfaces.StatsReporter, game string, platform string) {
+func statsReporterReportMetricCount(statsReporters []interfaces.StatsReporter, metric string, value int64, game string, platform string, tags ...string) {
+	for _, statsReporter := range statsReporters {
+		statsReporter.ReportMetricCount(metric, value, game, platform, tags...)
+	}
+}
+
+// statsReporterReportSendAtLag reports how long after its send_at a message
+// was sent, if it has one
+func statsReporterReportSendAtLag(statsReporters []interfaces.StatsReporter, sendAt int64, game string, platform string, tags ...string) {
+	if sendAt <= 0 {
+		return
+	}
+	lag := time.Duration(makeTimestamp()-sendAt) * time.Millisecond
+	if lag < 0 {
+		lag = 0
+	}
 	for _, statsReporter := range statsReporters {
-		statsReporter.HandleNotificationSuccess(game, platform)
+		statsReporter.ReportMetricTiming("send_at_lag", lag, game, platform, tags...)
 	}
 }
 
-func statsReporterHandleNotificationFailure(statsReporters []interfaces.StatsReporter, game string, platform string, err *errors.PushError) {
+func statsReporterReportMetricGauge(statsReporters []interfaces.StatsReporter, metric string, value float64, game string, platform string, tags ...string) {
 	for _, statsReporter := range statsReporters {
-		statsReporter.HandleNotificationFailure(game, platform, err)
+		statsReporter.ReportMetricGauge(metric, value, game, platform, tags...)
 	}
 }
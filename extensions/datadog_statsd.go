@@ -25,6 +25,7 @@ package extensions
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/DataDog/datadog-go/statsd"
 	"github.com/sirupsen/logrus"
@@ -90,18 +91,18 @@ func (s *StatsD) configure(client interfaces.StatsDClient) error {
 }
 
 //HandleNotificationSent stores notification count in StatsD
-func (s *StatsD) HandleNotificationSent(game string, platform string) {
-	s.Client.Incr("sent", []string{fmt.Sprintf("platform:%s", platform), fmt.Sprintf("game:%s", game)}, 1)
+func (s *StatsD) HandleNotificationSent(game string, platform string, tags ...string) {
+	s.Client.Incr("sent", append([]string{fmt.Sprintf("platform:%s", platform), fmt.Sprintf("game:%s", game)}, tags...), 1)
 }
 
 //HandleNotificationSuccess stores notifications success in StatsD
-func (s *StatsD) HandleNotificationSuccess(game string, platform string) {
-	s.Client.Incr("ack", []string{fmt.Sprintf("platform:%s", platform), fmt.Sprintf("game:%s", game)}, 1)
+func (s *StatsD) HandleNotificationSuccess(game string, platform string, tags ...string) {
+	s.Client.Incr("ack", append([]string{fmt.Sprintf("platform:%s", platform), fmt.Sprintf("game:%s", game)}, tags...), 1)
 }
 
 //HandleNotificationFailure stores each type of failure
-func (s *StatsD) HandleNotificationFailure(game string, platform string, err *errors.PushError) {
-	s.Client.Incr("failed", []string{fmt.Sprintf("platform:%s", platform), fmt.Sprintf("game:%s", game), fmt.Sprintf("reason:%s", err.Key)}, 1)
+func (s *StatsD) HandleNotificationFailure(game string, platform string, err *errors.PushError, tags ...string) {
+	s.Client.Incr("failed", append([]string{fmt.Sprintf("platform:%s", platform), fmt.Sprintf("game:%s", game), fmt.Sprintf("reason:%s", err.Key)}, tags...), 1)
 }
 
 //InitializeFailure notifu error when is impossible tho initilizer an app
@@ -128,11 +129,12 @@ func (s *StatsD) Cleanup() error {
 	return nil
 }
 
-// ReportMetricGauge reports a metric as a Gauge with hostname, game and platform
-// as tags
+// ReportMetricGauge reports a metric as a Gauge with hostname, game, platform
+// and the extra tags as tags
 func (s *StatsD) ReportMetricGauge(
 	metric string, value float64,
 	game, platform string,
+	extraTags ...string,
 ) {
 	hostname, _ := os.Hostname()
 	tags := []string{
@@ -147,14 +149,15 @@ func (s *StatsD) ReportMetricGauge(
 		tags = append(tags, fmt.Sprintf("platform:%s", platform))
 	}
 
-	s.Client.Gauge(metric, value, tags, 1)
+	s.Client.Gauge(metric, value, append(tags, extraTags...), 1)
 }
 
-// ReportMetricCount reports a metric as a Count with hostname, game and platform
-// as tags
+// ReportMetricCount reports a metric as a Count with hostname, game, platform
+// and the extra tags as tags
 func (s *StatsD) ReportMetricCount(
 	metric string, value int64,
 	game, platform string,
+	extraTags ...string,
 ) {
 	hostname, _ := os.Hostname()
 	tags := []string{
@@ -169,5 +172,28 @@ func (s *StatsD) ReportMetricCount(
 		tags = append(tags, fmt.Sprintf("platform:%s", platform))
 	}
 
-	s.Client.Count(metric, value, tags, 1)
+	s.Client.Count(metric, value, append(tags, extraTags...), 1)
+}
+
+// ReportMetricTiming reports a metric as a Timing with hostname, game, platform
+// and the extra tags as tags
+func (s *StatsD) ReportMetricTiming(
+	metric string, value time.Duration,
+	game, platform string,
+	extraTags ...string,
+) {
+	hostname, _ := os.Hostname()
+	tags := []string{
+		fmt.Sprintf("hostname:%s", hostname),
+	}
+
+	if game != "" {
+		tags = append(tags, fmt.Sprintf("game:%s", game))
+	}
+
+	if platform != "" {
+		tags = append(tags, fmt.Sprintf("platform:%s", platform))
+	}
+
+	s.Client.Timing(metric, value, append(tags, extraTags...), 1)
 }
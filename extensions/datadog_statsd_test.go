@@ -23,6 +23,8 @@
 package extensions
 
 import (
+	"time"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/sirupsen/logrus/hooks/test"
@@ -98,6 +100,19 @@ var _ = Describe("StatsD Extension", func() {
 
 				Expect(mockClient.Counts["failed"]).To(Equal(int64(2)))
 			})
+
+			It("should report extra tags", func() {
+				statsd, err := NewStatsD(config, logger, mockClient)
+				Expect(err).NotTo(HaveOccurred())
+				defer statsd.Cleanup()
+
+				pErr := errors.NewPushError("some-key", "some description")
+				statsd.HandleNotificationFailure("game", "apns", pErr, "topic:com.game.test")
+
+				Expect(mockClient.Tags["failed"]).To(ConsistOf(
+					"platform:apns", "game:game", "reason:some-key", "topic:com.game.test",
+				))
+			})
 		})
 
 		Describe("Reporting metric count", func() {
@@ -115,6 +130,16 @@ var _ = Describe("StatsD Extension", func() {
 				Expect(mockClient.Counts["tokens_delete_success"]).To(Equal(int64(5)))
 				Expect(mockClient.Counts["tokens_delete_error"]).To(Equal(int64(3)))
 			})
+
+			It("should report extra tags", func() {
+				statsd, err := NewStatsD(config, logger, mockClient)
+				Expect(err).NotTo(HaveOccurred())
+				defer statsd.Cleanup()
+
+				statsd.ReportMetricCount("retry", 1, "game", "apns", "topic:com.game.test")
+				Expect(mockClient.Tags["retry"]).To(ContainElement("topic:com.game.test"))
+				Expect(mockClient.Tags["retry"]).To(ContainElement("game:game"))
+			})
 		})
 
 		Describe("Reporting metric gauge", func() {
@@ -133,6 +158,19 @@ var _ = Describe("StatsD Extension", func() {
 				Expect(mockClient.Gauges["in_chan_size"]).To(Equal(float64(3)))
 			})
 		})
+
+		Describe("Reporting metric timing", func() {
+			It("should report metric timing in statsd", func() {
+				statsd, err := NewStatsD(config, logger, mockClient)
+				Expect(err).NotTo(HaveOccurred())
+				defer statsd.Cleanup()
+
+				statsd.ReportMetricTiming("rate_limiter_wait", 50*time.Millisecond, "game", "apns", "limit:game")
+				Expect(mockClient.Timings["rate_limiter_wait"]).To(Equal(50 * time.Millisecond))
+				Expect(mockClient.Tags["rate_limiter_wait"]).To(ContainElement("limit:game"))
+				Expect(mockClient.Tags["rate_limiter_wait"]).To(ContainElement("platform:apns"))
+			})
+		})
 	})
 
 	Describe("[Integration]", func() {
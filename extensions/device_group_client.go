@@ -0,0 +1,247 @@
+/*
+ * Copyright (c) 2026 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package extensions
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	gcm "github.com/topfreegames/go-gcm"
+)
+
+// DeviceGroupMaxTokens is the max number of tokens of a device group
+const DeviceGroupMaxTokens = 20
+
+// DeviceGroupSendResult is the result of sending a message to a device
+// group, FailedRegistrationIDs has the tokens the message was not sent to
+type DeviceGroupSendResult struct {
+	Success               int      `json:"success"`
+	Failure               int      `json:"failure"`
+	FailedRegistrationIDs []string `json:"failed_registration_ids,omitempty"`
+}
+
+type deviceGroupRequest struct {
+	Operation           string   `json:"operation"`
+	NotificationKeyName string   `json:"notification_key_name"`
+	NotificationKey     string   `json:"notification_key,omitempty"`
+	RegistrationIDs     []string `json:"registration_ids"`
+}
+
+type deviceGroupResponse struct {
+	NotificationKey string `json:"notification_key"`
+	Error           string `json:"error"`
+}
+
+// DeviceGroupClient manages the device groups of a gcm app and sends
+// messages to them with the FCM legacy HTTP API, which reports how many
+// devices of the group received the message
+type DeviceGroupClient struct {
+	appName    string
+	authorize  func(*http.Request) error
+	host       string
+	httpClient *http.Client
+	senderID   string
+	Config     *viper.Viper
+	Logger     *log.Logger
+}
+
+// NewDeviceGroupClient returns a new DeviceGroupClient for the gcm app
+func NewDeviceGroupClient(appName string, config *viper.Viper, logger *log.Logger) (*DeviceGroupClient, error) {
+	c := &DeviceGroupClient{
+		appName: appName,
+		Config:  config,
+		Logger:  logger,
+	}
+	err := c.configure()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *DeviceGroupClient) loadConfigurationDefaults() {
+	c.Config.SetDefault("gcm.deviceGroups.host", "https://fcm.googleapis.com")
+	c.Config.SetDefault("gcm.deviceGroups.timeout", 10000)
+}
+
+func (c *DeviceGroupClient) configure() error {
+	c.loadConfigurationDefaults()
+	c.host = strings.TrimSuffix(c.Config.GetString("gcm.deviceGroups.host"), "/")
+	c.httpClient = &http.Client{
+		Timeout: time.Duration(c.Config.GetInt("gcm.deviceGroups.timeout")) * time.Millisecond,
+	}
+	c.senderID = c.Config.GetString("gcm.certs." + c.appName + ".senderID")
+	if c.senderID == "" {
+		return fmt.Errorf("no sender id for gcm app %s", c.appName)
+	}
+	authorize, err := gcmAppAuthorizer(c.appName, c.Config, c.Logger)
+	if err != nil {
+		return err
+	}
+	c.authorize = authorize
+	return nil
+}
+
+// Create creates a device group with the tokens, returning its notification key
+func (c *DeviceGroupClient) Create(name string, tokens []string) (string, error) {
+	return c.manage("create", name, "", tokens)
+}
+
+// Add adds the tokens to the device group
+func (c *DeviceGroupClient) Add(name, notificationKey string, tokens []string) (string, error) {
+	return c.manage("add", name, notificationKey, tokens)
+}
+
+// Remove removes the tokens from the device group, the group is deleted when
+// all its tokens are removed
+func (c *DeviceGroupClient) Remove(name, notificationKey string, tokens []string) (string, error) {
+	return c.manage("remove", name, notificationKey, tokens)
+}
+
+// NotificationKey returns the notification key of the device group with the
+// name, or an empty key if it doesn't exist
+func (c *DeviceGroupClient) NotificationKey(name string) (string, error) {
+	resBody, statusCode, err := c.do("GET", "/fcm/notification?notification_key_name="+url.QueryEscape(name), nil)
+	if err != nil {
+		return "", err
+	}
+	res := &deviceGroupResponse{}
+	json.Unmarshal(resBody, res)
+	if statusCode == http.StatusBadRequest && res.Error == "notification_key not found" {
+		return "", nil
+	}
+	if statusCode != http.StatusOK {
+		return "", fmt.Errorf("request to /fcm/notification failed with status %d: %s", statusCode, string(resBody))
+	}
+	return res.NotificationKey, nil
+}
+
+func (c *DeviceGroupClient) manage(operation, name, notificationKey string, tokens []string) (string, error) {
+	l := c.Logger.WithFields(log.Fields{
+		"method":    operation,
+		"app":       c.appName,
+		"groupName": name,
+	})
+	if name == "" {
+		return "", fmt.Errorf("device group name can not be empty")
+	}
+	if len(tokens) == 0 {
+		return "", fmt.Errorf("device group %s operation needs at least one token", operation)
+	}
+	if len(tokens) > DeviceGroupMaxTokens {
+		return "", fmt.Errorf("device groups can have at most %d tokens", DeviceGroupMaxTokens)
+	}
+	body, err := json.Marshal(&deviceGroupRequest{
+		Operation:           operation,
+		NotificationKeyName: name,
+		NotificationKey:     notificationKey,
+		RegistrationIDs:     tokens,
+	})
+	if err != nil {
+		return "", err
+	}
+	resBody, err := c.post("/fcm/notification", body)
+	if err != nil {
+		l.WithError(err).Error("error managing device group")
+		return "", err
+	}
+	res := &deviceGroupResponse{}
+	err = json.Unmarshal(resBody, res)
+	if err != nil {
+		return "", err
+	}
+	if res.Error != "" {
+		return "", fmt.Errorf("device group %s failed: %s", operation, res.Error)
+	}
+	l.WithField("tokens", len(tokens)).Debug("managed device group")
+	return res.NotificationKey, nil
+}
+
+// Send sends the message to the device group of the notification key
+func (c *DeviceGroupClient) Send(notificationKey string, msg gcm.XMPPMessage) (*DeviceGroupSendResult, error) {
+	msg.To = notificationKey
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	// message ids are only accepted by the xmpp api
+	fields := map[string]interface{}{}
+	err = json.Unmarshal(body, &fields)
+	if err != nil {
+		return nil, err
+	}
+	delete(fields, "message_id")
+	body, err = json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	resBody, err := c.post("/fcm/send", body)
+	if err != nil {
+		return nil, err
+	}
+	res := &DeviceGroupSendResult{}
+	err = json.Unmarshal(resBody, res)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (c *DeviceGroupClient) post(path string, body []byte) ([]byte, error) {
+	resBody, statusCode, err := c.do("POST", path, body)
+	if err != nil {
+		return nil, err
+	}
+	if statusCode != http.StatusOK {
+		return nil, fmt.Errorf("request to %s failed with status %d: %s", path, statusCode, string(resBody))
+	}
+	return resBody, nil
+}
+
+func (c *DeviceGroupClient) do(method, path string, body []byte) ([]byte, int, error) {
+	req, err := http.NewRequest(method, c.host+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("project_id", c.senderID)
+	err = c.authorize(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+	resBody, _ := ioutil.ReadAll(res.Body)
+	return resBody, res.StatusCode, nil
+}
@@ -0,0 +1,130 @@
+/*
+ * Copyright (c) 2026 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package extensions
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/spf13/viper"
+	gcm "github.com/topfreegames/go-gcm"
+	. "github.com/topfreegames/pusher/testing"
+	"github.com/topfreegames/pusher/util"
+)
+
+var _ = Describe("Device Group Client", func() {
+	var config *viper.Viper
+	var server *DeviceGroupServer
+	var client *DeviceGroupClient
+	logger, _ := test.NewNullLogger()
+
+	BeforeEach(func() {
+		var err error
+		config, err = util.NewViperWithConfigFile("../config/test.yaml")
+		Expect(err).NotTo(HaveOccurred())
+		server = NewDeviceGroupServer()
+		config.Set("gcm.deviceGroups.host", server.URL)
+		client, err = NewDeviceGroupClient("game", config, logger)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Describe("[Unit]", func() {
+		Describe("Creating new client", func() {
+			It("should fail if app has no sender id", func() {
+				_, err := NewDeviceGroupClient("other", config, logger)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal("no sender id for gcm app other"))
+			})
+		})
+
+		Describe("Managing device groups", func() {
+			It("should create, add to and remove from a device group", func() {
+				key, err := client.Create("user1", []string{"token1", "token2"})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(key).To(Equal(server.NotificationKey("user1")))
+				Expect(server.Tokens("user1")).To(Equal([]string{"token1", "token2"}))
+
+				_, err = client.Add("user1", key, []string{"token3"})
+				Expect(err).NotTo(HaveOccurred())
+				_, err = client.Remove("user1", key, []string{"token1"})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(server.Tokens("user1")).To(Equal([]string{"token2", "token3"}))
+				Expect(server.Authorizations()).To(ConsistOf("key=game-api-key", "key=game-api-key", "key=game-api-key"))
+			})
+
+			It("should fail if the group already exists", func() {
+				_, err := client.Create("user1", []string{"token1"})
+				Expect(err).NotTo(HaveOccurred())
+				_, err = client.Create("user1", []string{"token2"})
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("notification_key already exists"))
+			})
+
+			It("should return the notification key of a device group", func() {
+				key, err := client.NotificationKey("user1")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(key).To(BeEmpty())
+
+				_, err = client.Create("user1", []string{"token1"})
+				Expect(err).NotTo(HaveOccurred())
+				key, err = client.NotificationKey("user1")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(key).To(Equal(server.NotificationKey("user1")))
+			})
+
+			It("should fail without tokens or with too many tokens", func() {
+				_, err := client.Create("user1", []string{})
+				Expect(err).To(HaveOccurred())
+				_, err = client.Create("user1", make([]string, DeviceGroupMaxTokens+1))
+				Expect(err).To(HaveOccurred())
+				Expect(server.Authorizations()).To(BeEmpty())
+			})
+		})
+
+		Describe("Sending messages", func() {
+			It("should return the partial results of the group", func() {
+				key, err := client.Create("user1", []string{"token1", "token2", "token3"})
+				Expect(err).NotTo(HaveOccurred())
+				server.SetFailingToken("token2")
+				res, err := client.Send(key, gcm.XMPPMessage{
+					MessageID: "id",
+					Data:      gcm.Data{"title": "hello"},
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(res).To(Equal(&DeviceGroupSendResult{
+					Success:               2,
+					Failure:               1,
+					FailedRegistrationIDs: []string{"token2"},
+				}))
+				messages := server.Messages()
+				Expect(messages).To(HaveLen(1))
+				Expect(messages[0]["to"]).To(Equal(key))
+				Expect(messages[0]).NotTo(HaveKey("message_id"))
+			})
+		})
+	})
+})
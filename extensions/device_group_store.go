@@ -0,0 +1,110 @@
+/*
+ * Copyright (c) 2026 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package extensions
+
+import (
+	"fmt"
+
+	pg "gopkg.in/pg.v5"
+
+	"github.com/spf13/viper"
+	"github.com/topfreegames/pusher/interfaces"
+)
+
+// DeviceGroup is a device group of a gcm app, its name is chosen by the game,
+// e.g. an user id, and its notification key is the target of its messages
+type DeviceGroup struct {
+	NotificationKeyName string
+	NotificationKey     string
+	Tokens              []string `pg:",array"`
+}
+
+// DeviceGroupStore keeps the device groups of each game in the
+// <game>_gcm_device_groups table, next to the <game>_gcm token table
+type DeviceGroupStore struct {
+	Client *PGClient
+	Config *viper.Viper
+}
+
+// NewDeviceGroupStore returns a new DeviceGroupStore
+func NewDeviceGroupStore(config *viper.Viper, dbOrNil ...interfaces.DB) (*DeviceGroupStore, error) {
+	s := &DeviceGroupStore{
+		Config: config,
+	}
+	var db interfaces.DB
+	if len(dbOrNil) == 1 {
+		db = dbOrNil[0]
+	}
+	client, err := NewPGClient("gcm.deviceGroups.pg", config, db)
+	if err != nil {
+		return nil, err
+	}
+	s.Client = client
+	return s, nil
+}
+
+func deviceGroupsTable(game string) string {
+	return game + "_gcm_device_groups"
+}
+
+// Get returns the device group of the game with the name, or nil if it
+// doesn't exist
+func (s *DeviceGroupStore) Get(game, name string) (*DeviceGroup, error) {
+	var groups []*DeviceGroup
+	_, err := s.Client.DB.Query(&groups, fmt.Sprintf(
+		"SELECT notification_key_name, notification_key, tokens FROM %s WHERE notification_key_name = ?0",
+		deviceGroupsTable(game),
+	), name)
+	if err != nil {
+		return nil, err
+	}
+	if len(groups) == 0 {
+		return nil, nil
+	}
+	return groups[0], nil
+}
+
+// Save creates or replaces the device group of the game
+func (s *DeviceGroupStore) Save(game string, group *DeviceGroup) error {
+	_, err := s.Client.DB.Exec(fmt.Sprintf(
+		"INSERT INTO %s (notification_key_name, notification_key, tokens, updated_at) VALUES (?0, ?1, ?2, now()) "+
+			"ON CONFLICT (notification_key_name) DO UPDATE SET notification_key = EXCLUDED.notification_key, "+
+			"tokens = EXCLUDED.tokens, updated_at = EXCLUDED.updated_at",
+		deviceGroupsTable(game),
+	), group.NotificationKeyName, group.NotificationKey, pg.Array(group.Tokens))
+	return err
+}
+
+// Delete deletes the device group of the game with the name
+func (s *DeviceGroupStore) Delete(game, name string) error {
+	_, err := s.Client.DB.Exec(fmt.Sprintf(
+		"DELETE FROM %s WHERE notification_key_name = ?0",
+		deviceGroupsTable(game),
+	), name)
+	return err
+}
+
+// Cleanup closes the connection to PG
+func (s *DeviceGroupStore) Cleanup() error {
+	return s.Client.Cleanup()
+}
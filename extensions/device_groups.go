@@ -0,0 +1,199 @@
+/*
+ * Copyright (c) 2026 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package extensions
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	gcm "github.com/topfreegames/go-gcm"
+	"github.com/topfreegames/pusher/interfaces"
+)
+
+// DeviceGroups creates, updates and deletes the device groups of a gcm app,
+// keeping their notification keys and tokens in the DeviceGroupStore
+type DeviceGroups struct {
+	appName string
+	Client  *DeviceGroupClient
+	Store   *DeviceGroupStore
+	Logger  *log.Logger
+}
+
+// NewDeviceGroups returns a new DeviceGroups for the gcm app
+func NewDeviceGroups(
+	appName string,
+	config *viper.Viper,
+	logger *log.Logger,
+	dbOrNil ...interfaces.DB,
+) (*DeviceGroups, error) {
+	client, err := NewDeviceGroupClient(appName, config, logger)
+	if err != nil {
+		return nil, err
+	}
+	store, err := NewDeviceGroupStore(config, dbOrNil...)
+	if err != nil {
+		return nil, err
+	}
+	return &DeviceGroups{
+		appName: appName,
+		Client:  client,
+		Store:   store,
+		Logger:  logger,
+	}, nil
+}
+
+// Get returns the device group with the name, or nil if it doesn't exist
+func (d *DeviceGroups) Get(name string) (*DeviceGroup, error) {
+	return d.Store.Get(d.appName, name)
+}
+
+// Create creates a device group with the tokens. A group that exists in FCM
+// but was not stored, e.g. because saving it failed, is stored with the tokens
+func (d *DeviceGroups) Create(name string, tokens []string) (*DeviceGroup, error) {
+	group, err := d.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	if group != nil {
+		return nil, fmt.Errorf("device group %s already exists", name)
+	}
+	notificationKey, err := d.Client.NotificationKey(name)
+	if err != nil {
+		return nil, err
+	}
+	if notificationKey != "" {
+		_, err = d.Client.Add(name, notificationKey, tokens)
+	} else {
+		notificationKey, err = d.Client.Create(name, tokens)
+	}
+	if err != nil {
+		return nil, err
+	}
+	group = &DeviceGroup{
+		NotificationKeyName: name,
+		NotificationKey:     notificationKey,
+		Tokens:              uniqueTokens(nil, tokens),
+	}
+	return group, d.Store.Save(d.appName, group)
+}
+
+// Add adds the tokens to the device group, creating it if it doesn't exist
+func (d *DeviceGroups) Add(name string, tokens []string) (*DeviceGroup, error) {
+	group, err := d.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	if group == nil {
+		return d.Create(name, tokens)
+	}
+	newTokens := uniqueTokens(group.Tokens, tokens)
+	if len(newTokens) > DeviceGroupMaxTokens {
+		return nil, fmt.Errorf("device groups can have at most %d tokens", DeviceGroupMaxTokens)
+	}
+	_, err = d.Client.Add(name, group.NotificationKey, tokens)
+	if err != nil {
+		return nil, err
+	}
+	group.Tokens = newTokens
+	return group, d.Store.Save(d.appName, group)
+}
+
+// Remove removes the tokens from the device group, deleting it if no tokens
+// are left
+func (d *DeviceGroups) Remove(name string, tokens []string) (*DeviceGroup, error) {
+	group, err := d.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	if group == nil {
+		return nil, fmt.Errorf("device group %s does not exist", name)
+	}
+	_, err = d.Client.Remove(name, group.NotificationKey, tokens)
+	if err != nil {
+		return nil, err
+	}
+	removed := map[string]bool{}
+	for _, token := range tokens {
+		removed[token] = true
+	}
+	remaining := []string{}
+	for _, token := range group.Tokens {
+		if !removed[token] {
+			remaining = append(remaining, token)
+		}
+	}
+	group.Tokens = remaining
+	if len(remaining) == 0 {
+		return group, d.Store.Delete(d.appName, name)
+	}
+	return group, d.Store.Save(d.appName, group)
+}
+
+// Delete deletes the device group by removing all its tokens
+func (d *DeviceGroups) Delete(name string) error {
+	group, err := d.Get(name)
+	if err != nil {
+		return err
+	}
+	if group == nil {
+		return fmt.Errorf("device group %s does not exist", name)
+	}
+	if len(group.Tokens) > 0 {
+		_, err = d.Client.Remove(name, group.NotificationKey, group.Tokens)
+		if err != nil {
+			return err
+		}
+	}
+	return d.Store.Delete(d.appName, name)
+}
+
+// Send sends the message to the device group with the name
+func (d *DeviceGroups) Send(name string, msg gcm.XMPPMessage) (*DeviceGroupSendResult, error) {
+	group, err := d.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	if group == nil {
+		return nil, fmt.Errorf("device group %s does not exist", name)
+	}
+	return d.Client.Send(group.NotificationKey, msg)
+}
+
+// Cleanup closes the connection to PG
+func (d *DeviceGroups) Cleanup() error {
+	return d.Store.Cleanup()
+}
+
+// uniqueTokens appends the new tokens that are not in tokens yet
+func uniqueTokens(tokens, newTokens []string) []string {
+	seen := map[string]bool{}
+	result := []string{}
+	for _, token := range append(append([]string{}, tokens...), newTokens...) {
+		if !seen[token] {
+			seen[token] = true
+			result = append(result, token)
+		}
+	}
+	return result
+}
@@ -0,0 +1,156 @@
+/*
+ * Copyright (c) 2026 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package extensions
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/pusher/mocks"
+	. "github.com/topfreegames/pusher/testing"
+	"github.com/topfreegames/pusher/util"
+)
+
+var _ = Describe("Device Groups", func() {
+	var config *viper.Viper
+	var server *DeviceGroupServer
+	var db *mocks.PGMock
+	var stored *DeviceGroup
+	var deviceGroups *DeviceGroups
+	logger, _ := test.NewNullLogger()
+
+	BeforeEach(func() {
+		var err error
+		config, err = util.NewViperWithConfigFile("../config/test.yaml")
+		Expect(err).NotTo(HaveOccurred())
+		server = NewDeviceGroupServer()
+		config.Set("gcm.deviceGroups.host", server.URL)
+		stored = nil
+		db = mocks.NewPGMock(0, 1)
+		db.QueryModel = func(model interface{}) {
+			if stored != nil {
+				groups := model.(*[]*DeviceGroup)
+				*groups = append(*groups, stored)
+			}
+		}
+		deviceGroups, err = NewDeviceGroups("game", config, logger, db)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	lastExec := func() []interface{} {
+		return db.Execs[len(db.Execs)-1]
+	}
+
+	Describe("[Unit]", func() {
+		It("should create a device group and store it", func() {
+			group, err := deviceGroups.Create("user1", []string{"token1", "token2"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(group.NotificationKey).To(Equal(server.NotificationKey("user1")))
+			Expect(lastExec()[0]).To(ContainSubstring("INSERT INTO game_gcm_device_groups"))
+			Expect(lastExec()[1].([]interface{})[:2]).To(Equal([]interface{}{"user1", group.NotificationKey}))
+			Expect(group.Tokens).To(Equal([]string{"token1", "token2"}))
+		})
+
+		It("should store a device group created in FCM if saving it failed", func() {
+			group, err := deviceGroups.Create("user1", []string{"token1"})
+			Expect(err).NotTo(HaveOccurred())
+			key := group.NotificationKey
+
+			group, err = deviceGroups.Create("user1", []string{"token2"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(group.NotificationKey).To(Equal(key))
+			Expect(group.Tokens).To(Equal([]string{"token2"}))
+			Expect(lastExec()[0]).To(ContainSubstring("INSERT INTO game_gcm_device_groups"))
+			Expect(server.Tokens("user1")).To(Equal([]string{"token1", "token2"}))
+		})
+
+		It("should fail to create a stored device group", func() {
+			stored = &DeviceGroup{NotificationKeyName: "user1", NotificationKey: "key", Tokens: []string{"token1"}}
+			_, err := deviceGroups.Create("user1", []string{"token2"})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("device group user1 already exists"))
+		})
+
+		It("should add tokens to a stored device group", func() {
+			_, err := deviceGroups.Create("user1", []string{"token1"})
+			Expect(err).NotTo(HaveOccurred())
+			stored = &DeviceGroup{NotificationKeyName: "user1", NotificationKey: server.NotificationKey("user1"), Tokens: []string{"token1"}}
+			group, err := deviceGroups.Add("user1", []string{"token1", "token2"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(group.Tokens).To(Equal([]string{"token1", "token2"}))
+			Expect(server.Tokens("user1")).To(Equal([]string{"token1", "token2"}))
+		})
+
+		It("should create the device group when adding to a missing one", func() {
+			group, err := deviceGroups.Add("user1", []string{"token1"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(group.NotificationKey).To(Equal(server.NotificationKey("user1")))
+		})
+
+		It("should delete the stored device group when its last token is removed", func() {
+			_, err := deviceGroups.Create("user1", []string{"token1", "token2"})
+			Expect(err).NotTo(HaveOccurred())
+			stored = &DeviceGroup{NotificationKeyName: "user1", NotificationKey: server.NotificationKey("user1"), Tokens: []string{"token1", "token2"}}
+			group, err := deviceGroups.Remove("user1", []string{"token1"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(group.Tokens).To(Equal([]string{"token2"}))
+			Expect(lastExec()[0]).To(ContainSubstring("INSERT INTO"))
+
+			stored.Tokens = []string{"token2"}
+			_, err = deviceGroups.Remove("user1", []string{"token2"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lastExec()[0]).To(ContainSubstring("DELETE FROM game_gcm_device_groups"))
+			Expect(server.NotificationKey("user1")).To(BeEmpty())
+		})
+
+		It("should delete a device group", func() {
+			_, err := deviceGroups.Create("user1", []string{"token1", "token2"})
+			Expect(err).NotTo(HaveOccurred())
+			stored = &DeviceGroup{NotificationKeyName: "user1", NotificationKey: server.NotificationKey("user1"), Tokens: []string{"token1", "token2"}}
+			err = deviceGroups.Delete("user1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(server.NotificationKey("user1")).To(BeEmpty())
+			Expect(lastExec()[1]).To(Equal([]interface{}{"user1"}))
+		})
+
+		It("should fail to delete a missing device group", func() {
+			err := deviceGroups.Delete("user1")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("device group user1 does not exist"))
+		})
+
+		It("should return store errors", func() {
+			db.Error = fmt.Errorf("pg error")
+			_, err := deviceGroups.Add("user1", []string{"token1"})
+			Expect(err).To(HaveOccurred())
+			Expect(server.Authorizations()).To(BeEmpty())
+		})
+	})
+})
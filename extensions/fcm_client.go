@@ -0,0 +1,499 @@
+/*
+ * Copyright (c) 2026 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package extensions
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	uuid "github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	gcm "github.com/topfreegames/go-gcm"
+	"golang.org/x/net/http2"
+)
+
+// FCMScope is the OAuth scope needed to send messages with the FCM HTTP v1 API
+const FCMScope = "https://www.googleapis.com/auth/firebase.messaging"
+
+// fcmErrorCodes maps FCM HTTP v1 error codes to the legacy XMPP ones, so
+// responses are handled the same way regardless of the client
+var fcmErrorCodes = map[string]string{
+	"UNREGISTERED":       "DEVICE_UNREGISTERED",
+	"SENDER_ID_MISMATCH": "BAD_REGISTRATION",
+	"INVALID_ARGUMENT":   "INVALID_JSON",
+	"QUOTA_EXCEEDED":     "DEVICE_MESSAGE_RATE_EXCEEDED",
+	"UNAVAILABLE":        "SERVICE_UNAVAILABLE",
+	"INTERNAL":           "INTERNAL_SERVER_ERROR",
+}
+
+// FCMServiceAccount holds the fields used from a service account JSON file
+type FCMServiceAccount struct {
+	ProjectID   string `json:"project_id"`
+	PrivateKey  string `json:"private_key"`
+	ClientEmail string `json:"client_email"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// FCMMessage is a message of the FCM HTTP v1 API
+type FCMMessage struct {
+	Token        string            `json:"token,omitempty"`
+	Topic        string            `json:"topic,omitempty"`
+	Condition    string            `json:"condition,omitempty"`
+	Data         map[string]string `json:"data,omitempty"`
+	Notification *FCMNotification  `json:"notification,omitempty"`
+	Android      *FCMAndroidConfig `json:"android,omitempty"`
+	APNS         *FCMAPNSConfig    `json:"apns,omitempty"`
+}
+
+// FCMNotification is the platform independent notification of a FCMMessage
+type FCMNotification struct {
+	Title string `json:"title,omitempty"`
+	Body  string `json:"body,omitempty"`
+}
+
+// FCMAndroidConfig holds the android specific options of a FCMMessage
+type FCMAndroidConfig struct {
+	CollapseKey  string                  `json:"collapse_key,omitempty"`
+	Priority     string                  `json:"priority,omitempty"`
+	TTL          string                  `json:"ttl,omitempty"`
+	Notification *FCMAndroidNotification `json:"notification,omitempty"`
+}
+
+// FCMAndroidNotification holds the android specific notification fields
+type FCMAndroidNotification struct {
+	Icon         string   `json:"icon,omitempty"`
+	Color        string   `json:"color,omitempty"`
+	Sound        string   `json:"sound,omitempty"`
+	Tag          string   `json:"tag,omitempty"`
+	ClickAction  string   `json:"click_action,omitempty"`
+	BodyLocKey   string   `json:"body_loc_key,omitempty"`
+	BodyLocArgs  []string `json:"body_loc_args,omitempty"`
+	TitleLocKey  string   `json:"title_loc_key,omitempty"`
+	TitleLocArgs []string `json:"title_loc_args,omitempty"`
+}
+
+// FCMAPNSConfig holds the iOS specific options of a FCMMessage
+type FCMAPNSConfig struct {
+	Payload map[string]interface{} `json:"payload,omitempty"`
+}
+
+type fcmRequest struct {
+	ValidateOnly bool        `json:"validate_only,omitempty"`
+	Message      *FCMMessage `json:"message"`
+}
+
+type fcmErrorResponse struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+		Details []struct {
+			Type            string `json:"@type"`
+			ErrorCode       string `json:"errorCode"`
+			FieldViolations []struct {
+				Field string `json:"field"`
+			} `json:"fieldViolations"`
+		} `json:"details"`
+	} `json:"error"`
+}
+
+// FCMClient implements interfaces.GCMClient using the FCM HTTP v1 API. Like the
+// XMPP client, SendXMPP returns as soon as the message is queued and the result
+// is delivered to the response handler
+type FCMClient struct {
+	account      *FCMServiceAccount
+	projectID    string
+	host         string
+	httpClient   *http.Client
+	transport    *http.Transport
+	handler      func(gcm.CCSMessage) error
+	tokenMutex   sync.Mutex
+	refreshMutex sync.Mutex
+	accessToken  string
+	tokenExpiry  time.Time
+	inflight     sync.WaitGroup
+	sending      chan struct{}
+	Config       *viper.Viper
+	Logger       *log.Logger
+}
+
+// NewFCMClient returns a new FCMClient authenticated with the service account
+// JSON file in credentialsPath. projectID defaults to the service account project
+func NewFCMClient(
+	credentialsPath, projectID string,
+	config *viper.Viper,
+	logger *log.Logger,
+	handler func(gcm.CCSMessage) error,
+) (*FCMClient, error) {
+	c := &FCMClient{
+		projectID: projectID,
+		handler:   handler,
+		Config:    config,
+		Logger:    logger,
+	}
+	err := c.configure(credentialsPath)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *FCMClient) loadConfigurationDefaults() {
+	c.Config.SetDefault("gcm.fcm.host", "https://fcm.googleapis.com")
+	c.Config.SetDefault("gcm.fcm.timeout", 10000)
+	c.Config.SetDefault("gcm.fcm.maxIdleConnections", 100)
+	c.Config.SetDefault("gcm.fcm.maxConcurrentRequests", 100)
+}
+
+func (c *FCMClient) configure(credentialsPath string) error {
+	c.loadConfigurationDefaults()
+	b, err := ioutil.ReadFile(credentialsPath)
+	if err != nil {
+		return fmt.Errorf("error reading fcm service account: %s", err.Error())
+	}
+	account := &FCMServiceAccount{}
+	err = json.Unmarshal(b, account)
+	if err != nil {
+		return fmt.Errorf("error parsing fcm service account: %s", err.Error())
+	}
+	if account.ClientEmail == "" || account.PrivateKey == "" || account.TokenURI == "" {
+		return fmt.Errorf("fcm service account %s is missing client_email, private_key or token_uri", credentialsPath)
+	}
+	if c.projectID == "" {
+		c.projectID = account.ProjectID
+	}
+	if c.projectID == "" {
+		return fmt.Errorf("no project id for fcm service account %s", credentialsPath)
+	}
+	c.account = account
+	c.host = strings.TrimSuffix(c.Config.GetString("gcm.fcm.host"), "/")
+	maxConcurrentRequests := c.Config.GetInt("gcm.fcm.maxConcurrentRequests")
+	if maxConcurrentRequests < 1 {
+		maxConcurrentRequests = 1
+	}
+	c.sending = make(chan struct{}, maxConcurrentRequests)
+	c.transport = &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		MaxIdleConnsPerHost: c.Config.GetInt("gcm.fcm.maxIdleConnections"),
+		IdleConnTimeout:     90 * time.Second,
+	}
+	err = http2.ConfigureTransport(c.transport)
+	if err != nil {
+		return err
+	}
+	c.httpClient = &http.Client{
+		Transport: c.transport,
+		Timeout:   time.Duration(c.Config.GetInt("gcm.fcm.timeout")) * time.Millisecond,
+	}
+	return nil
+}
+
+// cachedToken returns the access token if it is not about to expire
+func (c *FCMClient) cachedToken() string {
+	c.tokenMutex.Lock()
+	defer c.tokenMutex.Unlock()
+	if c.accessToken != "" && time.Now().Add(time.Minute).Before(c.tokenExpiry) {
+		return c.accessToken
+	}
+	return ""
+}
+
+// token returns the cached access token, minting a new one if it is about to
+// expire. Only one request mints it, the others wait for its result
+func (c *FCMClient) token() (string, error) {
+	if accessToken := c.cachedToken(); accessToken != "" {
+		return accessToken, nil
+	}
+	c.refreshMutex.Lock()
+	defer c.refreshMutex.Unlock()
+	if accessToken := c.cachedToken(); accessToken != "" {
+		return accessToken, nil
+	}
+
+	key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(c.account.PrivateKey))
+	if err != nil {
+		return "", fmt.Errorf("error parsing fcm service account key: %s", err.Error())
+	}
+	now := time.Now()
+	assertion, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   c.account.ClientEmail,
+		"scope": FCMScope,
+		"aud":   c.account.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}).SignedString(key)
+	if err != nil {
+		return "", err
+	}
+
+	res, err := c.httpClient.PostForm(c.account.TokenURI, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	})
+	if err != nil {
+		return "", fmt.Errorf("error minting fcm access token: %s", err.Error())
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(res.Body)
+		return "", fmt.Errorf("error minting fcm access token: status %d: %s", res.StatusCode, body)
+	}
+	var tokenRes struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&tokenRes)
+	if err != nil {
+		return "", fmt.Errorf("error parsing fcm access token: %s", err.Error())
+	}
+	c.tokenMutex.Lock()
+	c.accessToken = tokenRes.AccessToken
+	c.tokenExpiry = now.Add(time.Duration(tokenRes.ExpiresIn) * time.Second)
+	c.tokenMutex.Unlock()
+	return tokenRes.AccessToken, nil
+}
+
+func (c *FCMClient) invalidateToken() {
+	c.tokenMutex.Lock()
+	c.accessToken = ""
+	c.tokenMutex.Unlock()
+}
+
+// SendXMPP translates msg to a FCM HTTP v1 message and sends it in background.
+// It blocks while gcm.fcm.maxConcurrentRequests messages are being sent
+func (c *FCMClient) SendXMPP(msg gcm.XMPPMessage) (string, int, error) {
+	return c.sendAsync(msg, NewFCMMessage(msg))
+}
+
+// SendToCondition sends the message to the devices subscribed to a
+// combination of topics, e.g. "'dogs' in topics || 'cats' in topics"
+func (c *FCMClient) SendToCondition(condition string, msg gcm.XMPPMessage) (string, int, error) {
+	m := NewFCMMessage(msg)
+	m.Token = ""
+	m.Topic = ""
+	m.Condition = condition
+	// responses are reported from the condition
+	msg.To = condition
+	return c.sendAsync(msg, m)
+}
+
+func (c *FCMClient) sendAsync(msg gcm.XMPPMessage, m *FCMMessage) (string, int, error) {
+	if msg.MessageID == "" {
+		msg.MessageID = uuid.NewV4().String()
+	}
+	body, err := json.Marshal(&fcmRequest{
+		ValidateOnly: msg.DryRun,
+		Message:      m,
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	toTopic := m.Topic != "" || m.Condition != ""
+	c.sending <- struct{}{}
+	c.inflight.Add(1)
+	go func() {
+		defer c.inflight.Done()
+		defer func() { <-c.sending }()
+		c.handler(c.send(msg, body, toTopic))
+	}()
+	return msg.MessageID, len(body), nil
+}
+
+func (c *FCMClient) send(msg gcm.XMPPMessage, body []byte, toTopic bool) gcm.CCSMessage {
+	l := c.Logger.WithFields(log.Fields{
+		"method":    "send",
+		"messageID": msg.MessageID,
+	})
+	cm := gcm.CCSMessage{
+		From:      msg.To,
+		MessageID: msg.MessageID,
+	}
+	failed := func(code, description string) gcm.CCSMessage {
+		cm.MessageType = "nack"
+		cm.Error = code
+		cm.ErrorDescription = description
+		return cm
+	}
+
+	accessToken, err := c.token()
+	if err != nil {
+		l.WithError(err).Error("error getting fcm access token")
+		return failed("SERVICE_UNAVAILABLE", err.Error())
+	}
+	req, err := http.NewRequest(
+		"POST",
+		fmt.Sprintf("%s/v1/projects/%s/messages:send", c.host, c.projectID),
+		bytes.NewReader(body),
+	)
+	if err != nil {
+		return failed("INVALID_JSON", err.Error())
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", "application/json")
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		l.WithError(err).Debug("error sending message to fcm")
+		return failed("SERVICE_UNAVAILABLE", err.Error())
+	}
+	defer res.Body.Close()
+	resBody, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode == http.StatusOK {
+		cm.MessageType = "ack"
+		return cm
+	}
+
+	if res.StatusCode == http.StatusUnauthorized {
+		c.invalidateToken()
+	}
+	code, description := fcmError(res.StatusCode, resBody)
+	if toTopic && code == "DEVICE_MESSAGE_RATE_EXCEEDED" {
+		code = "TOPICS_MESSAGE_RATE_EXCEEDED"
+	}
+	return failed(code, description)
+}
+
+// fcmError maps a FCM HTTP v1 error response to a legacy error code and a
+// description containing the v1 error code
+func fcmError(statusCode int, body []byte) (string, string) {
+	errRes := &fcmErrorResponse{}
+	json.Unmarshal(body, errRes)
+	code := errRes.Error.Status
+	invalidToken := false
+	for _, detail := range errRes.Error.Details {
+		if strings.HasSuffix(detail.Type, "google.firebase.fcm.v1.FcmError") && detail.ErrorCode != "" {
+			code = detail.ErrorCode
+		}
+		for _, violation := range detail.FieldViolations {
+			if violation.Field == "message.token" {
+				invalidToken = true
+			}
+		}
+	}
+	if code == "" {
+		code = fmt.Sprintf("HTTP_%d", statusCode)
+	}
+	description := code
+	if errRes.Error.Message != "" {
+		description = fmt.Sprintf("%s: %s", code, errRes.Error.Message)
+	}
+	// invalid arguments are only caused by the token if the details say so,
+	// otherwise the token is kept
+	if code == "INVALID_ARGUMENT" && invalidToken {
+		return "BAD_REGISTRATION", description
+	}
+	if legacyCode, ok := fcmErrorCodes[code]; ok {
+		return legacyCode, description
+	}
+	if statusCode >= 500 {
+		return "SERVICE_UNAVAILABLE", description
+	}
+	return code, description
+}
+
+// Close waits for the messages being sent and closes idle connections
+func (c *FCMClient) Close() error {
+	c.inflight.Wait()
+	c.transport.CloseIdleConnections()
+	return nil
+}
+
+// NewFCMMessage translates a legacy XMPP message to a FCM HTTP v1 message
+func NewFCMMessage(msg gcm.XMPPMessage) *FCMMessage {
+	m := &FCMMessage{}
+	if IsGCMTopic(msg.To) {
+		m.Topic = strings.TrimPrefix(msg.To, GCMTopicPrefix)
+	} else {
+		m.Token = msg.To
+	}
+
+	if len(msg.Data) > 0 {
+		m.Data = map[string]string{}
+		for k, v := range msg.Data {
+			if s, ok := v.(string); ok {
+				m.Data[k] = s
+			} else if b, err := json.Marshal(v); err == nil {
+				m.Data[k] = string(b)
+			}
+		}
+	}
+
+	android := &FCMAndroidConfig{
+		CollapseKey: msg.CollapseKey,
+		Priority:    strings.ToUpper(msg.Priority),
+	}
+	if msg.TimeToLive != nil {
+		android.TTL = fmt.Sprintf("%ds", *msg.TimeToLive)
+	}
+	if n := msg.Notification; n != nil {
+		if n.Title != "" || n.Body != "" {
+			m.Notification = &FCMNotification{Title: n.Title, Body: n.Body}
+		}
+		an := FCMAndroidNotification{
+			Icon:         n.Icon,
+			Color:        n.Color,
+			Sound:        n.Sound,
+			Tag:          n.Tag,
+			ClickAction:  n.ClickAction,
+			BodyLocKey:   n.BodyLocKey,
+			BodyLocArgs:  fcmLocArgs(n.BodyLocArgs),
+			TitleLocKey:  n.TitleLocKey,
+			TitleLocArgs: fcmLocArgs(n.TitleLocArgs),
+		}
+		b, _ := json.Marshal(an)
+		if string(b) != "{}" {
+			android.Notification = &an
+		}
+	}
+	if android.CollapseKey != "" || android.Priority != "" || android.TTL != "" || android.Notification != nil {
+		m.Android = android
+	}
+	if msg.ContentAvailable {
+		m.APNS = &FCMAPNSConfig{
+			Payload: map[string]interface{}{
+				"aps": map[string]interface{}{"content-available": 1},
+			},
+		}
+	}
+	return m
+}
+
+// fcmLocArgs parses the JSON array of localization arguments used by the legacy API
+func fcmLocArgs(args string) []string {
+	if args == "" {
+		return nil
+	}
+	var parsed []string
+	if err := json.Unmarshal([]byte(args), &parsed); err != nil {
+		return []string{args}
+	}
+	return parsed
+}
@@ -0,0 +1,370 @@
+/*
+ * Copyright (c) 2026 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package extensions
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/spf13/viper"
+	gcm "github.com/topfreegames/go-gcm"
+)
+
+var _ = Describe("FCM Client", func() {
+	var client *FCMClient
+	var config *viper.Viper
+	var credentialsPath string
+	var dir string
+	var key *rsa.PrivateKey
+	var server *httptest.Server
+	var mutex sync.Mutex
+	var tokensMinted int
+	var requests []*http.Request
+	var bodies [][]byte
+	var assertions []string
+	var sendStatus int
+	var sendBody string
+	var responses chan gcm.CCSMessage
+	logger, _ := test.NewNullLogger()
+
+	writeServiceAccount := func(account map[string]string) {
+		b, err := json.Marshal(account)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ioutil.WriteFile(credentialsPath, b, 0600)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		var err error
+		key, err = rsa.GenerateKey(rand.Reader, 1024)
+		Expect(err).NotTo(HaveOccurred())
+		tokensMinted = 0
+		requests = []*http.Request{}
+		bodies = [][]byte{}
+		assertions = []string{}
+		sendStatus = http.StatusOK
+		sendBody = `{"name":"projects/project-id/messages/1"}`
+		responses = make(chan gcm.CCSMessage, 10)
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			if r.URL.Path == "/token" {
+				r.ParseForm()
+				assertions = append(assertions, r.PostForm.Get("assertion"))
+				tokensMinted++
+				w.Write([]byte(`{"access_token":"access-token","expires_in":3600,"token_type":"Bearer"}`))
+				return
+			}
+			b, _ := ioutil.ReadAll(r.Body)
+			requests = append(requests, r)
+			bodies = append(bodies, b)
+			w.WriteHeader(sendStatus)
+			w.Write([]byte(sendBody))
+		}))
+
+		dir, err = ioutil.TempDir("", "fcm")
+		Expect(err).NotTo(HaveOccurred())
+		credentialsPath = filepath.Join(dir, "service-account.json")
+		writeServiceAccount(map[string]string{
+			"type":         "service_account",
+			"project_id":   "project-id",
+			"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})),
+			"client_email": "pusher@project-id.iam.gserviceaccount.com",
+			"token_uri":    server.URL + "/token",
+		})
+
+		config = viper.New()
+		config.Set("gcm.fcm.host", server.URL)
+		client, err = NewFCMClient(credentialsPath, "", config, logger, func(cm gcm.CCSMessage) error {
+			responses <- cm
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(dir)
+	})
+
+	Describe("[Unit]", func() {
+		Describe("Creating new client", func() {
+			It("should use project id of the service account", func() {
+				Expect(client.projectID).To(Equal("project-id"))
+				Expect(client.host).To(Equal(server.URL))
+			})
+
+			It("should use given project id", func() {
+				c, err := NewFCMClient(credentialsPath, "other-project", config, logger, nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(c.projectID).To(Equal("other-project"))
+			})
+
+			It("should fail if service account does not exist", func() {
+				_, err := NewFCMClient(filepath.Join(dir, "missing.json"), "", config, logger, nil)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("error reading fcm service account"))
+			})
+
+			It("should fail if service account is incomplete", func() {
+				writeServiceAccount(map[string]string{"project_id": "project-id"})
+				_, err := NewFCMClient(credentialsPath, "", config, logger, nil)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("missing client_email, private_key or token_uri"))
+			})
+		})
+
+		Describe("Sending messages", func() {
+			It("should send v1 messages and report acks", func() {
+				messageID, bytes, err := client.SendXMPP(gcm.XMPPMessage{
+					To:   "token",
+					Data: gcm.Data{"title": "hello"},
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(messageID).NotTo(BeEmpty())
+				Expect(bytes).To(BeNumerically(">", 0))
+
+				var res gcm.CCSMessage
+				Eventually(responses).Should(Receive(&res))
+				Expect(res.MessageType).To(Equal("ack"))
+				Expect(res.MessageID).To(Equal(messageID))
+				Expect(res.From).To(Equal("token"))
+
+				mutex.Lock()
+				defer mutex.Unlock()
+				Expect(requests).To(HaveLen(1))
+				Expect(requests[0].URL.Path).To(Equal("/v1/projects/project-id/messages:send"))
+				Expect(requests[0].Header.Get("Authorization")).To(Equal("Bearer access-token"))
+				Expect(string(bodies[0])).To(MatchJSON(`{"message":{"token":"token","data":{"title":"hello"}}}`))
+			})
+
+			It("should mint access tokens signed with the service account key", func() {
+				client.SendXMPP(gcm.XMPPMessage{To: "token", MessageID: "id1"})
+				client.SendXMPP(gcm.XMPPMessage{To: "token", MessageID: "id2"})
+				Eventually(responses).Should(Receive())
+				Eventually(responses).Should(Receive())
+
+				mutex.Lock()
+				defer mutex.Unlock()
+				Expect(tokensMinted).To(Equal(1))
+				claims := jwt.MapClaims{}
+				_, err := jwt.ParseWithClaims(assertions[0], claims, func(t *jwt.Token) (interface{}, error) {
+					return &key.PublicKey, nil
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(claims["iss"]).To(Equal("pusher@project-id.iam.gserviceaccount.com"))
+				Expect(claims["scope"]).To(Equal(FCMScope))
+				Expect(claims["aud"]).To(Equal(server.URL + "/token"))
+			})
+
+			It("should map v1 errors to legacy errors", func() {
+				sendStatus = http.StatusNotFound
+				sendBody = `{"error":{"code":404,"message":"Requested entity was not found.","status":"NOT_FOUND","details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"UNREGISTERED"}]}}`
+				client.SendXMPP(gcm.XMPPMessage{To: "token", MessageID: "id"})
+
+				var res gcm.CCSMessage
+				Eventually(responses).Should(Receive(&res))
+				Expect(res.MessageType).To(Equal("nack"))
+				Expect(res.MessageID).To(Equal("id"))
+				Expect(res.Error).To(Equal("DEVICE_UNREGISTERED"))
+				Expect(res.ErrorDescription).To(Equal("UNREGISTERED: Requested entity was not found."))
+			})
+
+			It("should report invalid tokens as bad registrations", func() {
+				sendStatus = http.StatusBadRequest
+				sendBody = `{"error":{"code":400,"message":"The registration token is not a valid FCM registration token","status":"INVALID_ARGUMENT","details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"INVALID_ARGUMENT"},{"@type":"type.googleapis.com/google.rpc.BadRequest","fieldViolations":[{"field":"message.token","description":"Invalid registration token"}]}]}}`
+				client.SendXMPP(gcm.XMPPMessage{To: "token"})
+
+				var res gcm.CCSMessage
+				Eventually(responses).Should(Receive(&res))
+				Expect(res.Error).To(Equal("BAD_REGISTRATION"))
+			})
+
+			It("should report other invalid arguments as invalid json", func() {
+				sendStatus = http.StatusBadRequest
+				sendBody = `{"error":{"code":400,"message":"Invalid value at 'message.android.ttl'","status":"INVALID_ARGUMENT","details":[{"@type":"type.googleapis.com/google.rpc.BadRequest","fieldViolations":[{"field":"message.android.ttl"}]}]}}`
+				client.SendXMPP(gcm.XMPPMessage{To: "token"})
+
+				var res gcm.CCSMessage
+				Eventually(responses).Should(Receive(&res))
+				Expect(res.Error).To(Equal("INVALID_JSON"))
+			})
+
+			It("should mint a single access token for concurrent messages", func() {
+				for i := 0; i < 5; i++ {
+					client.SendXMPP(gcm.XMPPMessage{To: "token"})
+				}
+				for i := 0; i < 5; i++ {
+					Eventually(responses).Should(Receive())
+				}
+				mutex.Lock()
+				defer mutex.Unlock()
+				Expect(tokensMinted).To(Equal(1))
+			})
+
+			It("should limit the messages being sent", func() {
+				config.Set("gcm.fcm.maxConcurrentRequests", 1)
+				var err error
+				client, err = NewFCMClient(credentialsPath, "", config, logger, func(cm gcm.CCSMessage) error {
+					responses <- cm
+					return nil
+				})
+				Expect(err).NotTo(HaveOccurred())
+				mutex.Lock()
+				sent := make(chan bool)
+				go func() {
+					client.SendXMPP(gcm.XMPPMessage{To: "token"})
+					client.SendXMPP(gcm.XMPPMessage{To: "token"})
+					close(sent)
+				}()
+				Consistently(sent).ShouldNot(BeClosed())
+				mutex.Unlock()
+				Eventually(sent).Should(BeClosed())
+			})
+
+			It("should send messages to conditions", func() {
+				messageID, _, err := client.SendToCondition("'dogs' in topics || 'cats' in topics", gcm.XMPPMessage{
+					To:   "token",
+					Data: gcm.Data{"title": "hello"},
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				var res gcm.CCSMessage
+				Eventually(responses).Should(Receive(&res))
+				Expect(res.MessageType).To(Equal("ack"))
+				Expect(res.MessageID).To(Equal(messageID))
+				Expect(res.From).To(Equal("'dogs' in topics || 'cats' in topics"))
+
+				mutex.Lock()
+				defer mutex.Unlock()
+				Expect(string(bodies[0])).To(MatchJSON(`{"message":{"condition":"'dogs' in topics || 'cats' in topics","data":{"title":"hello"}}}`))
+			})
+
+			It("should report quota errors of topic messages as topic rate errors", func() {
+				sendStatus = http.StatusTooManyRequests
+				sendBody = `{"error":{"code":429,"status":"RESOURCE_EXHAUSTED","details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"QUOTA_EXCEEDED"}]}}`
+				client.SendXMPP(gcm.XMPPMessage{To: "/topics/news"})
+				client.SendXMPP(gcm.XMPPMessage{To: "token"})
+
+				errs := []string{}
+				for i := 0; i < 2; i++ {
+					var res gcm.CCSMessage
+					Eventually(responses).Should(Receive(&res))
+					errs = append(errs, res.From+" "+res.Error)
+				}
+				Expect(errs).To(ConsistOf("/topics/news TOPICS_MESSAGE_RATE_EXCEEDED", "token DEVICE_MESSAGE_RATE_EXCEEDED"))
+			})
+
+			It("should report server errors without body as service unavailable", func() {
+				sendStatus = http.StatusBadGateway
+				sendBody = ""
+				client.SendXMPP(gcm.XMPPMessage{To: "token"})
+
+				var res gcm.CCSMessage
+				Eventually(responses).Should(Receive(&res))
+				Expect(res.Error).To(Equal("SERVICE_UNAVAILABLE"))
+				Expect(res.ErrorDescription).To(Equal("HTTP_502"))
+			})
+
+			It("should mint a new access token after an unauthenticated response", func() {
+				sendStatus = http.StatusUnauthorized
+				sendBody = `{"error":{"code":401,"message":"Request had invalid authentication credentials.","status":"UNAUTHENTICATED"}}`
+				client.SendXMPP(gcm.XMPPMessage{To: "token"})
+				var res gcm.CCSMessage
+				Eventually(responses).Should(Receive(&res))
+				Expect(res.Error).To(Equal("UNAUTHENTICATED"))
+
+				client.SendXMPP(gcm.XMPPMessage{To: "token"})
+				Eventually(responses).Should(Receive())
+				mutex.Lock()
+				defer mutex.Unlock()
+				Expect(tokensMinted).To(Equal(2))
+			})
+
+			It("should wait for messages being sent when closed", func() {
+				client.SendXMPP(gcm.XMPPMessage{To: "token"})
+				Expect(client.Close()).To(Succeed())
+				Expect(responses).To(HaveLen(1))
+			})
+		})
+
+		Describe("Translating messages", func() {
+			It("should translate legacy messages", func() {
+				ttl := uint(3600)
+				msg := NewFCMMessage(gcm.XMPPMessage{
+					To:               "token",
+					CollapseKey:      "collapse",
+					Priority:         "high",
+					TimeToLive:       &ttl,
+					ContentAvailable: true,
+					Data: gcm.Data{
+						"text":   "hello",
+						"nested": map[string]interface{}{"id": 1},
+						"count":  2,
+					},
+					Notification: &gcm.Notification{
+						Title:       "title",
+						Body:        "body",
+						Sound:       "default",
+						BodyLocKey:  "key",
+						BodyLocArgs: `["a","b"]`,
+					},
+				})
+				b, err := json.Marshal(msg)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(b)).To(MatchJSON(`{
+					"token": "token",
+					"data": {"text": "hello", "nested": "{\"id\":1}", "count": "2"},
+					"notification": {"title": "title", "body": "body"},
+					"android": {
+						"collapse_key": "collapse",
+						"priority": "HIGH",
+						"ttl": "3600s",
+						"notification": {"sound": "default", "body_loc_key": "key", "body_loc_args": ["a", "b"]}
+					},
+					"apns": {"payload": {"aps": {"content-available": 1}}}
+				}`))
+			})
+
+			It("should translate topic messages", func() {
+				msg := NewFCMMessage(gcm.XMPPMessage{To: "/topics/news"})
+				Expect(msg.Topic).To(Equal("news"))
+				Expect(msg.Token).To(BeEmpty())
+				Expect(msg.Android).To(BeNil())
+			})
+		})
+	})
+})
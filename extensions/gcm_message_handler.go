@@ -39,11 +39,107 @@ import (
 
 var gcmResMutex sync.Mutex
 
+// APIs that can be used to send pushes of a GCM app
+const (
+	GCMAPIXMPP = "xmpp"
+	GCMAPIFCM  = "fcm"
+)
+
 // KafkaGCMMessage is a enriched XMPPMessage with a Metadata field
 type KafkaGCMMessage struct {
 	gcm.XMPPMessage
 	Metadata   map[string]interface{} `json:"metadata,omitempty"`
 	PushExpiry int64                  `json:"push_expiry,omitempty"`
+	// SendAt is when the message is scheduled to be sent, in milliseconds
+	SendAt int64 `json:"send_at,omitempty"`
+	// Condition sends the message to a combination of topics instead of the
+	// `to` field
+	Condition string `json:"condition,omitempty"`
+	// NotificationKeyName sends the message to the device group with the name
+	// instead of the `to` field
+	NotificationKeyName string `json:"notification_key_name,omitempty"`
+}
+
+// target returns the token, topic, condition or device group the message is
+// sent to
+func (km *KafkaGCMMessage) target() string {
+	if km.Condition != "" {
+		return km.Condition
+	}
+	if km.NotificationKeyName != "" {
+		return km.NotificationKeyName
+	}
+	return km.To
+}
+
+// isTopic returns if the message is sent to a topic or condition
+func (km *KafkaGCMMessage) isTopic() bool {
+	return km.Condition != "" || IsGCMTopic(km.To)
+}
+
+// Metadata targets and stats tags of the messages not sent to a single token
+const (
+	gcmTopicTarget       = "topic"
+	gcmDeviceGroupTarget = "device_group"
+)
+
+// targetType returns the metadata target of the message, empty for tokens
+func (km *KafkaGCMMessage) targetType() string {
+	if km.NotificationKeyName != "" {
+		return gcmDeviceGroupTarget
+	}
+	if km.isTopic() {
+		return gcmTopicTarget
+	}
+	return ""
+}
+
+func gcmTargetTags(target string) []string {
+	if target != "" {
+		return []string{fmt.Sprintf("target:%s", target)}
+	}
+	return nil
+}
+
+// gcmReasonTag returns the tag of the error that made a message be retried
+func gcmReasonTag(err string) string {
+	return fmt.Sprintf("reason:%s", strings.ToLower(err))
+}
+
+// gcmConditionClient is implemented by the clients able to send messages to
+// a combination of topics
+type gcmConditionClient interface {
+	SendToCondition(condition string, msg gcm.XMPPMessage) (string, int, error)
+}
+
+func sendGCMMessage(client interfaces.GCMClient, message gcm.XMPPMessage, condition string) (string, int, error) {
+	if condition == "" {
+		return client.SendXMPP(message)
+	}
+	conditionClient, ok := client.(gcmConditionClient)
+	if !ok {
+		return "", 0, fmt.Errorf("conditions are only supported by the %s api", GCMAPIFCM)
+	}
+	return conditionClient.SendToCondition(condition, message)
+}
+
+// Error keys of messages that failed before reaching GCM
+const (
+	GCMErrorInvalidRequest = "invalid-request"
+	GCMErrorSendError      = "send-error"
+)
+
+// GCMErrorDeviceGroupFailed is the error of messages that no device of their
+// device group received
+const GCMErrorDeviceGroupFailed = "DEVICE_GROUP_FAILED"
+
+// inflightGCMMessage keeps a sent message, so it can be resent if it fails
+// with a transient error
+type inflightGCMMessage struct {
+	message   gcm.XMPPMessage
+	condition string
+	client    interfaces.GCMClient
+	attempts  int
 }
 
 // CCSMessageWithMetadata is a enriched CCSMessage with a metadata field
@@ -51,16 +147,27 @@ type CCSMessageWithMetadata struct {
 	gcm.CCSMessage
 	Timestamp int64                  `json:"timestamp"`
 	Metadata  map[string]interface{} `json:"metadata,omitempty"`
+	// partial results of messages sent to device groups
+	Success               *int     `json:"success,omitempty"`
+	Failure               *int     `json:"failure,omitempty"`
+	FailedRegistrationIDs []string `json:"failed_registration_ids,omitempty"`
 }
 
 // GCMMessageHandler implements the messagehandler interface
 type GCMMessageHandler struct {
 	apiKey                       string
+	appName                      string
 	Config                       *viper.Viper
 	failuresReceived             int64
 	feedbackReporters            []interfaces.FeedbackReporter
+	upstreamReporters            []interfaces.UpstreamReporter
 	GCMClient                    interfaces.GCMClient
+	clientLock                   sync.RWMutex
+	newClient                    func() (interfaces.GCMClient, error)
 	InflightMessagesMetadata     map[string]interface{}
+	deliveryReceiptsMetadata     map[string]map[string]interface{}
+	deviceGroups                 *DeviceGroups
+	inflightMessages             map[string]*inflightGCMMessage
 	IsProduction                 bool
 	Logger                       *log.Logger
 	LogStatsInterval             time.Duration
@@ -71,6 +178,11 @@ type GCMMessageHandler struct {
 	PingInterval                 int
 	PingTimeout                  int
 	responsesReceived            int64
+	retriedMessages              int64
+	retryPolicy                  *RetryPolicy
+	retryTimers                  retryTimers
+	stopChannel                  chan struct{}
+	drainingTimeout              time.Duration
 	run                          bool
 	senderID                     string
 	sentMessages                 int64
@@ -82,13 +194,14 @@ type GCMMessageHandler struct {
 
 // NewGCMMessageHandler returns a new instance of a GCMMessageHandler
 func NewGCMMessageHandler(
-	senderID, apiKey string,
+	senderID, apiKey, appName string,
 	isProduction bool,
 	config *viper.Viper,
 	logger *log.Logger,
 	pendingMessagesWG *sync.WaitGroup,
 	statsReporters []interfaces.StatsReporter,
 	feedbackReporters []interfaces.FeedbackReporter,
+	upstreamReporters []interfaces.UpstreamReporter,
 	client interfaces.GCMClient,
 ) (*GCMMessageHandler, error) {
 	l := logger.WithFields(log.Fields{
@@ -100,10 +213,14 @@ func NewGCMMessageHandler(
 
 	g := &GCMMessageHandler{
 		apiKey:                       apiKey,
+		appName:                      appName,
 		Config:                       config,
 		failuresReceived:             0,
 		feedbackReporters:            feedbackReporters,
+		upstreamReporters:            upstreamReporters,
 		InflightMessagesMetadata:     map[string]interface{}{},
+		deliveryReceiptsMetadata:     map[string]map[string]interface{}{},
+		inflightMessages:             map[string]*inflightGCMMessage{},
 		IsProduction:                 isProduction,
 		Logger:                       logger,
 		pendingMessagesWG:            pendingMessagesWG,
@@ -115,6 +232,7 @@ func NewGCMMessageHandler(
 		StatsReporters:               statsReporters,
 		successesReceived:            0,
 		requestsHeap:                 NewTimeoutHeap(config),
+		stopChannel:                  make(chan struct{}),
 	}
 	err := g.configure(client)
 	if err != nil {
@@ -130,6 +248,18 @@ func (g *GCMMessageHandler) configure(client interfaces.GCMClient) error {
 	interval := g.Config.GetInt("gcm.logStatsInterval")
 	g.LogStatsInterval = time.Duration(interval) * time.Millisecond
 	g.CacheCleaningInterval = g.Config.GetInt("feedback.cache.cleaningInterval")
+	g.retryPolicy = NewRetryPolicy(g.Config, "gcm.retry")
+	g.drainingTimeout = time.Duration(g.Config.GetInt("gcm.drainingTimeout")) * time.Millisecond
+	if g.Config.GetBool("gcm.deviceGroups.enabled") {
+		deviceGroups, err := NewDeviceGroups(g.appName, g.Config, g.Logger)
+		if err != nil {
+			return err
+		}
+		g.deviceGroups = deviceGroups
+	}
+	if g.newClient == nil {
+		g.newClient = g.createGCMClient
+	}
 	var err error
 	if client != nil {
 		err = nil
@@ -149,12 +279,33 @@ func (g *GCMMessageHandler) loadConfigurationDefaults() {
 	g.Config.SetDefault("gcm.maxPendingMessages", 100)
 	g.Config.SetDefault("gcm.logStatsInterval", 5000)
 	g.Config.SetDefault("feedback.cache.cleaningInterval", 300000)
+	g.Config.SetDefault("gcm.retry.maxAttempts", 3)
+	g.Config.SetDefault("gcm.retry.baseDelay", 1000)
+	g.Config.SetDefault("gcm.retry.maxDelay", 30000)
+	g.Config.SetDefault("gcm.drainingTimeout", 30000)
+	g.Config.SetDefault("gcm.deviceGroups.enabled", false)
 }
 
 func (g *GCMMessageHandler) configureGCMClient() error {
+	cl, err := g.newClient()
+	if err != nil {
+		return err
+	}
+	g.GCMClient = cl
+	return nil
+}
+
+func (g *GCMMessageHandler) createGCMClient() (interfaces.GCMClient, error) {
 	l := g.Logger.WithFields(log.Fields{
-		"method": "configureGCMClient",
+		"method": "createGCMClient",
 	})
+	switch api := g.Config.GetString("gcm.certs." + g.appName + ".api"); api {
+	case "", GCMAPIXMPP:
+	case GCMAPIFCM:
+		return g.createFCMClient()
+	default:
+		return nil, fmt.Errorf("invalid gcm api for app %s: %s", g.appName, api)
+	}
 	g.PingInterval = g.Config.GetInt("gcm.pingInterval")
 	g.PingTimeout = g.Config.GetInt("gcm.pingTimeout")
 	gcmConfig := &gcm.Config{
@@ -166,29 +317,58 @@ func (g *GCMMessageHandler) configureGCMClient() error {
 		PingInterval:      g.PingInterval,
 		PingTimeout:       g.PingTimeout,
 	}
-	var err error
 	cl, err := gcm.NewClient(gcmConfig, g.handleGCMResponse)
 	if err != nil {
 		l.Error("Failed to create gcm client.")
-		return err
+		return nil, err
 	}
-	g.GCMClient = cl
-	return nil
+	return cl, nil
+}
+
+func (g *GCMMessageHandler) createFCMClient() (interfaces.GCMClient, error) {
+	l := g.Logger.WithFields(log.Fields{
+		"method": "createFCMClient",
+	})
+	cl, err := NewFCMClient(
+		g.Config.GetString("gcm.certs."+g.appName+".serviceAccountPath"),
+		g.Config.GetString("gcm.certs."+g.appName+".projectID"),
+		g.Config,
+		g.Logger,
+		g.handleGCMResponse,
+	)
+	if err != nil {
+		l.Error("Failed to create fcm client.")
+		return nil, err
+	}
+	return cl, nil
+}
+
+func (g *GCMMessageHandler) client() interfaces.GCMClient {
+	g.clientLock.RLock()
+	defer g.clientLock.RUnlock()
+	return g.GCMClient
 }
 
 // WARNING: Be careful, code here needs to be thread safe!
 func (g *GCMMessageHandler) handleGCMResponse(cm gcm.CCSMessage) error {
-	defer func() {
-		if g.pendingMessagesWG != nil {
-			g.pendingMessagesWG.Done()
-		}
-	}()
-
 	l := g.Logger.WithFields(log.Fields{
 		"method":     "handleGCMResponse",
 		"ccsMessage": cm,
 	})
 	l.Debug("Got response from gcm.")
+	if cm.MessageType == "control" {
+		if cm.ControlType == "CONNECTION_DRAINING" {
+			g.reconnect(g.client())
+		}
+		return nil
+	}
+	if cm.MessageType == "receipt" {
+		return g.handleDeliveryReceipt(cm)
+	}
+	// upstream messages have no type and always have the app package as category
+	if cm.MessageType == "" && cm.Category != "" {
+		return g.handleUpstreamMessage(cm)
+	}
 	gcmResMutex.Lock()
 
 	select {
@@ -201,6 +381,16 @@ func (g *GCMMessageHandler) handleGCMResponse(cm gcm.CCSMessage) error {
 	g.responsesReceived++
 	gcmResMutex.Unlock()
 
+	if g.isRetryable(cm.Error) && g.retryMessage(cm) {
+		l.WithField(log.ErrorKey, cm.Error).Debug("retrying message")
+		return nil
+	}
+	defer func() {
+		if g.pendingMessagesWG != nil {
+			g.pendingMessagesWG.Done()
+		}
+	}()
+
 	var err error
 	ccsMessageWithMetadata := &CCSMessageWithMetadata{
 		CCSMessage: cm,
@@ -209,20 +399,45 @@ func (g *GCMMessageHandler) handleGCMResponse(cm gcm.CCSMessage) error {
 	g.inflightMessagesMetadataLock.Lock()
 	if val, ok := g.InflightMessagesMetadata[cm.MessageID]; ok {
 		ccsMessageWithMetadata.Metadata = val.(map[string]interface{})
+		if inflight, ok := g.inflightMessages[cm.MessageID]; ok && cm.Error == "" && inflight.message.DeliveryReceiptRequested {
+			receiptMetadata := map[string]interface{}{}
+			for k, v := range ccsMessageWithMetadata.Metadata {
+				receiptMetadata[k] = v
+			}
+			g.deliveryReceiptsMetadata[cm.MessageID] = receiptMetadata
+		}
 		ccsMessageWithMetadata.Timestamp = ccsMessageWithMetadata.Metadata["timestamp"].(int64)
 		parsedTopic.Game = ccsMessageWithMetadata.Metadata["game"].(string)
 		parsedTopic.Platform = ccsMessageWithMetadata.Metadata["platform"].(string)
 		delete(ccsMessageWithMetadata.Metadata, "timestamp")
 		delete(g.InflightMessagesMetadata, cm.MessageID)
 	}
+	delete(g.inflightMessages, cm.MessageID)
 	g.inflightMessagesMetadataLock.Unlock()
+	target, _ := ccsMessageWithMetadata.Metadata["target"].(string)
+	if target == "" && IsGCMTopic(cm.From) {
+		target = gcmTopicTarget
+	}
+	isTopic := target == gcmTopicTarget
+	tags := gcmTargetTags(target)
+
+	// the token was replaced by a canonical registration ID, pushes should be
+	// sent to the new one from now on
+	if cm.Error == "" && cm.RegistrationID != "" && cm.RegistrationID != cm.From {
+		l.WithField("registrationID", cm.RegistrationID).Debug("received canonical registration id")
+		if ccsMessageWithMetadata.Metadata == nil {
+			ccsMessageWithMetadata.Metadata = map[string]interface{}{}
+		}
+		ccsMessageWithMetadata.Metadata["tokenUpdate"] = cm.RegistrationID
+		statsReporterReportMetricCount(g.StatsReporters, "token_update", 1, parsedTopic.Game, "gcm")
+	}
 
 	if cm.Error != "" {
 		gcmResMutex.Lock()
 		g.failuresReceived++
 		gcmResMutex.Unlock()
 		pErr := errors.NewPushError(strings.ToLower(cm.Error), cm.ErrorDescription)
-		statsReporterHandleNotificationFailure(g.StatsReporters, parsedTopic.Game, "gcm", pErr)
+		statsReporterHandleNotificationFailure(g.StatsReporters, parsedTopic.Game, "gcm", pErr, tags...)
 
 		err = pErr
 		switch cm.Error {
@@ -232,7 +447,7 @@ func (g *GCMMessageHandler) handleGCMResponse(cm gcm.CCSMessage) error {
 				"category":   "TokenError",
 				log.ErrorKey: fmt.Errorf("%s (Description: %s)", cm.Error, cm.ErrorDescription),
 			}).Debug("received an error")
-			if ccsMessageWithMetadata.Metadata != nil {
+			if ccsMessageWithMetadata.Metadata != nil && !isTopic {
 				ccsMessageWithMetadata.Metadata["deleteToken"] = true
 			}
 		case "INVALID_JSON":
@@ -276,11 +491,72 @@ func (g *GCMMessageHandler) handleGCMResponse(cm gcm.CCSMessage) error {
 	gcmResMutex.Lock()
 	g.successesReceived++
 	gcmResMutex.Unlock()
-	statsReporterHandleNotificationSuccess(g.StatsReporters, parsedTopic.Game, "gcm")
+	statsReporterHandleNotificationSuccess(g.StatsReporters, parsedTopic.Game, "gcm", tags...)
 
 	return nil
 }
 
+// handleDeliveryReceipt reports that a message was delivered to the device
+// with the metadata of the original message, receipts arriving after the
+// feedback cache timeout are reported without it
+func (g *GCMMessageHandler) handleDeliveryReceipt(cm gcm.CCSMessage) error {
+	l := g.Logger.WithField("method", "handleDeliveryReceipt")
+
+	messageID, _ := cm.Data["original_message_id"].(string)
+	if messageID == "" {
+		messageID = strings.TrimPrefix(cm.MessageID, "dr2:")
+	}
+	token, _ := cm.Data["device_registration_id"].(string)
+
+	feedback := &CCSMessageWithMetadata{
+		CCSMessage: gcm.CCSMessage{
+			From:        token,
+			MessageID:   messageID,
+			MessageType: "delivered",
+			Category:    cm.Category,
+			Data:        cm.Data,
+		},
+		Timestamp: time.Now().Unix(),
+	}
+	parsedTopic := ParsedTopic{Game: g.appName, Platform: "gcm"}
+	g.inflightMessagesMetadataLock.Lock()
+	if metadata, ok := g.deliveryReceiptsMetadata[messageID]; ok {
+		feedback.Metadata = metadata
+		feedback.Timestamp = metadata["timestamp"].(int64)
+		parsedTopic.Game = metadata["game"].(string)
+		delete(feedback.Metadata, "timestamp")
+		delete(g.deliveryReceiptsMetadata, messageID)
+	}
+	g.inflightMessagesMetadataLock.Unlock()
+
+	statsReporterReportMetricCount(g.StatsReporters, "delivered", 1, parsedTopic.Game, "gcm")
+	err := sendToFeedbackReporters(g.feedbackReporters, feedback, parsedTopic)
+	if err != nil {
+		l.WithError(err).Error("error sending feedback to reporter")
+	}
+	return err
+}
+
+// handleUpstreamMessage publishes a message sent by a device, the gcm client
+// already acks it to GCM
+func (g *GCMMessageHandler) handleUpstreamMessage(cm gcm.CCSMessage) error {
+	l := g.Logger.WithField("method", "handleUpstreamMessage")
+	upstreamMessage := &CCSMessageWithMetadata{
+		CCSMessage: cm,
+		Timestamp:  time.Now().Unix(),
+		Metadata: map[string]interface{}{
+			"game":     g.appName,
+			"platform": "gcm",
+		},
+	}
+	statsReporterReportMetricCount(g.StatsReporters, "upstream", 1, g.appName, "gcm")
+	err := sendToUpstreamReporters(g.upstreamReporters, upstreamMessage, ParsedTopic{Game: g.appName, Platform: "gcm"})
+	if err != nil {
+		l.WithError(err).Error("error sending upstream message to reporter")
+	}
+	return err
+}
+
 func (g *GCMMessageHandler) sendMessage(message interfaces.KafkaMessage) error {
 	l := g.Logger.WithField("method", "sendMessage")
 	//ttl := uint(0)
@@ -288,26 +564,40 @@ func (g *GCMMessageHandler) sendMessage(message interfaces.KafkaMessage) error {
 	err := json.Unmarshal(message.Value, &km)
 	if err != nil {
 		l.WithError(err).Error("Error unmarshaling message.")
+		g.handleLocalFailure(&km, message.Game, GCMErrorInvalidRequest, err)
 		return err
 	}
 	if km.PushExpiry > 0 && km.PushExpiry < makeTimestamp() {
 		l.Warnf("ignoring push message because it has expired: %s", km.Data)
+		gcmResMutex.Lock()
 		g.ignoredMessages++
+		gcmResMutex.Unlock()
 		if g.pendingMessagesWG != nil {
 			g.pendingMessagesWG.Done()
 		}
 		return nil
 	}
+	err = g.validateTarget(&km)
+	if err != nil {
+		l.WithError(err).Error("Invalid message target.")
+		g.handleLocalFailure(&km, message.Game, GCMErrorInvalidRequest, err)
+		return err
+	}
+	if km.NotificationKeyName != "" {
+		return g.sendToDeviceGroup(&km, message.Game)
+	}
 	l.WithField("message", km).Debug("sending message to gcm")
 	var messageID string
 	var bytes int
 
 	g.pendingMessages <- true
-	messageID, bytes, err = g.GCMClient.SendXMPP(km.XMPPMessage)
+	client := g.client()
+	messageID, bytes, err = sendGCMMessage(client, km.XMPPMessage, km.Condition)
 
 	if err != nil {
 		<-g.pendingMessages
 		l.WithError(err).Error("Error sending message.")
+		g.handleLocalFailure(&km, message.Game, GCMErrorSendError, err)
 		return err
 	}
 
@@ -326,15 +616,27 @@ func (g *GCMMessageHandler) sendMessage(message interfaces.KafkaMessage) error {
 
 		km.Metadata["game"] = message.Game
 		km.Metadata["platform"] = "gcm"
+		if target := km.targetType(); target != "" {
+			km.Metadata["target"] = target
+		}
 
+		km.XMPPMessage.MessageID = messageID
 		g.inflightMessagesMetadataLock.Lock()
 		g.InflightMessagesMetadata[messageID] = km.Metadata
+		g.inflightMessages[messageID] = &inflightGCMMessage{
+			message:   km.XMPPMessage,
+			condition: km.Condition,
+			client:    client,
+		}
 		g.requestsHeap.AddRequest(messageID)
 		g.inflightMessagesMetadataLock.Unlock()
 	}
 
-	statsReporterHandleNotificationSent(g.StatsReporters, message.Game, "gcm")
+	statsReporterHandleNotificationSent(g.StatsReporters, message.Game, "gcm", gcmTargetTags(km.targetType())...)
+	statsReporterReportSendAtLag(g.StatsReporters, km.SendAt, message.Game, "gcm")
+	gcmResMutex.Lock()
 	g.sentMessages++
+	gcmResMutex.Unlock()
 	l.WithFields(log.Fields{
 		"messageID": messageID,
 		"bytes":     bytes,
@@ -342,6 +644,260 @@ func (g *GCMMessageHandler) sendMessage(message interfaces.KafkaMessage) error {
 	return nil
 }
 
+// validateTarget checks the topic or condition the message is sent to
+func (g *GCMMessageHandler) validateTarget(km *KafkaGCMMessage) error {
+	switch {
+	case km.Condition != "" && km.To != "":
+		return fmt.Errorf("message has both to and condition")
+	case km.Condition != "":
+		if _, ok := g.client().(gcmConditionClient); !ok {
+			return fmt.Errorf("conditions are only supported by the %s api", GCMAPIFCM)
+		}
+		return ValidateGCMCondition(km.Condition)
+	case km.NotificationKeyName != "":
+		if km.To != "" {
+			return fmt.Errorf("message has both to and notification_key_name")
+		}
+		if g.deviceGroups == nil {
+			return fmt.Errorf("device groups are not enabled")
+		}
+	case IsGCMTopic(km.To):
+		return ValidateGCMTopic(km.To)
+	}
+	return nil
+}
+
+// sendToDeviceGroup sends the message to the device group and reports how
+// many of its devices received it, the message is a failure only if none did
+func (g *GCMMessageHandler) sendToDeviceGroup(km *KafkaGCMMessage, game string) error {
+	l := g.Logger.WithFields(log.Fields{
+		"method":    "sendToDeviceGroup",
+		"groupName": km.NotificationKeyName,
+	})
+	group, err := g.deviceGroups.Get(km.NotificationKeyName)
+	if err == nil && group == nil {
+		err = fmt.Errorf("device group %s does not exist", km.NotificationKeyName)
+		l.WithError(err).Error("Invalid message target.")
+		g.handleLocalFailure(km, game, GCMErrorInvalidRequest, err)
+		return err
+	}
+	var res *DeviceGroupSendResult
+	if err == nil {
+		res, err = g.deviceGroups.Client.Send(group.NotificationKey, km.XMPPMessage)
+	}
+	if err != nil {
+		l.WithError(err).Error("Error sending message.")
+		g.handleLocalFailure(km, game, GCMErrorSendError, err)
+		return err
+	}
+	defer func() {
+		if g.pendingMessagesWG != nil {
+			g.pendingMessagesWG.Done()
+		}
+	}()
+	tags := gcmTargetTags(gcmDeviceGroupTarget)
+	statsReporterHandleNotificationSent(g.StatsReporters, game, "gcm", tags...)
+	statsReporterReportSendAtLag(g.StatsReporters, km.SendAt, game, "gcm")
+	gcmResMutex.Lock()
+	g.sentMessages++
+	g.responsesReceived++
+	gcmResMutex.Unlock()
+
+	metadata := km.Metadata
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+	}
+	metadata["game"] = game
+	metadata["platform"] = "gcm"
+	metadata["target"] = gcmDeviceGroupTarget
+	feedback := &CCSMessageWithMetadata{
+		CCSMessage: gcm.CCSMessage{
+			From:        group.NotificationKeyName,
+			MessageID:   km.MessageID,
+			MessageType: "ack",
+		},
+		Timestamp:             time.Now().Unix(),
+		Metadata:              metadata,
+		Success:               &res.Success,
+		Failure:               &res.Failure,
+		FailedRegistrationIDs: res.FailedRegistrationIDs,
+	}
+	if res.Failure > 0 {
+		statsReporterReportMetricCount(g.StatsReporters, "device_group_failed_tokens", int64(res.Failure), game, "gcm")
+	}
+	if res.Success == 0 && res.Failure > 0 {
+		feedback.MessageType = "nack"
+		feedback.Error = GCMErrorDeviceGroupFailed
+		gcmResMutex.Lock()
+		g.failuresReceived++
+		gcmResMutex.Unlock()
+		pErr := errors.NewPushError(strings.ToLower(GCMErrorDeviceGroupFailed), "no device of the group received the message")
+		statsReporterHandleNotificationFailure(g.StatsReporters, game, "gcm", pErr, tags...)
+	} else {
+		gcmResMutex.Lock()
+		g.successesReceived++
+		gcmResMutex.Unlock()
+		statsReporterHandleNotificationSuccess(g.StatsReporters, game, "gcm", tags...)
+	}
+	sendFeedbackErr := sendToFeedbackReporters(g.feedbackReporters, feedback, ParsedTopic{Game: game, Platform: "gcm"})
+	if sendFeedbackErr != nil {
+		l.WithError(sendFeedbackErr).Error("error sending feedback to reporter")
+	}
+	return nil
+}
+
+// handleLocalFailure reports a message that failed before reaching GCM, as
+// no response will be received for it
+func (g *GCMMessageHandler) handleLocalFailure(km *KafkaGCMMessage, game, errorKey string, err error) {
+	defer func() {
+		if g.pendingMessagesWG != nil {
+			g.pendingMessagesWG.Done()
+		}
+	}()
+	l := g.Logger.WithField("method", "handleLocalFailure")
+
+	gcmResMutex.Lock()
+	g.failuresReceived++
+	gcmResMutex.Unlock()
+	pErr := errors.NewPushError(errorKey, err.Error())
+	statsReporterHandleNotificationFailure(g.StatsReporters, game, "gcm", pErr, gcmTargetTags(km.targetType())...)
+
+	metadata := km.Metadata
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+	}
+	metadata["game"] = game
+	metadata["platform"] = "gcm"
+	if target := km.targetType(); target != "" {
+		metadata["target"] = target
+	}
+	feedback := &CCSMessageWithMetadata{
+		CCSMessage: gcm.CCSMessage{
+			From:             km.target(),
+			MessageID:        km.MessageID,
+			MessageType:      "nack",
+			Error:            errorKey,
+			ErrorDescription: err.Error(),
+		},
+		Timestamp: time.Now().Unix(),
+		Metadata:  metadata,
+	}
+	sendFeedbackErr := sendToFeedbackReporters(g.feedbackReporters, feedback, ParsedTopic{Game: game, Platform: "gcm"})
+	if sendFeedbackErr != nil {
+		l.WithError(sendFeedbackErr).Error("error sending feedback to reporter")
+	}
+}
+
+func (g *GCMMessageHandler) isRetryable(err string) bool {
+	switch err {
+	case "CONNECTION_DRAINING", "SERVICE_UNAVAILABLE", "INTERNAL_SERVER_ERROR":
+		return true
+	default:
+		return false
+	}
+}
+
+// retryMessage resends an inflight message after a backoff delay. It returns
+// false if the message is unknown or ran out of attempts, in which case the
+// failure is final and must be reported as usual
+func (g *GCMMessageHandler) retryMessage(cm gcm.CCSMessage) bool {
+	g.inflightMessagesMetadataLock.Lock()
+	inflight, ok := g.inflightMessages[cm.MessageID]
+	if !ok {
+		g.inflightMessagesMetadataLock.Unlock()
+		return false
+	}
+	var target string
+	if metadata, ok := g.InflightMessagesMetadata[cm.MessageID].(map[string]interface{}); ok {
+		target, _ = metadata["target"].(string)
+	}
+	tags := append(gcmTargetTags(target), gcmReasonTag(cm.Error))
+	if !g.retryPolicy.CanRetry(inflight.attempts) {
+		g.inflightMessagesMetadataLock.Unlock()
+		statsReporterReportMetricCount(g.StatsReporters, "retry_exhausted", 1, g.appName, "gcm", tags...)
+		return false
+	}
+	inflight.attempts++
+	attempt := inflight.attempts
+	message := inflight.message
+	client := inflight.client
+	g.inflightMessagesMetadataLock.Unlock()
+
+	if cm.Error == "CONNECTION_DRAINING" {
+		g.reconnect(client)
+	}
+
+	gcmResMutex.Lock()
+	g.retriedMessages++
+	gcmResMutex.Unlock()
+	statsReporterReportMetricCount(g.StatsReporters, "retry", 1, g.appName, "gcm", tags...)
+	g.retryTimers.afterFunc(g.retryPolicy.Backoff(attempt), func() {
+		g.resendMessage(message)
+	})
+	return true
+}
+
+// resendMessage sends an inflight message again using the current client. If
+// sending fails, a response with the error is handled so the message is retried
+// or reported as failed
+func (g *GCMMessageHandler) resendMessage(message gcm.XMPPMessage) {
+	l := g.Logger.WithFields(log.Fields{
+		"method":    "resendMessage",
+		"messageID": message.MessageID,
+	})
+	g.pendingMessages <- true
+	client := g.client()
+	var condition string
+	g.inflightMessagesMetadataLock.Lock()
+	if inflight, ok := g.inflightMessages[message.MessageID]; ok {
+		inflight.client = client
+		condition = inflight.condition
+	}
+	g.inflightMessagesMetadataLock.Unlock()
+
+	_, _, err := sendGCMMessage(client, message, condition)
+	if err != nil {
+		l.WithError(err).Error("error resending message")
+		from := message.To
+		if condition != "" {
+			from = condition
+		}
+		g.handleGCMResponse(gcm.CCSMessage{
+			From:             from,
+			MessageID:        message.MessageID,
+			MessageType:      "nack",
+			Error:            "SERVICE_UNAVAILABLE",
+			ErrorDescription: err.Error(),
+		})
+	}
+}
+
+// reconnect replaces a draining client with a new one. The draining client is
+// closed after a timeout, so responses of messages sent through it can still
+// be received
+func (g *GCMMessageHandler) reconnect(draining interfaces.GCMClient) {
+	l := g.Logger.WithField("method", "reconnect")
+	g.clientLock.Lock()
+	if draining == nil || g.GCMClient != draining {
+		g.clientLock.Unlock()
+		return
+	}
+	cl, err := g.newClient()
+	if err != nil {
+		g.clientLock.Unlock()
+		l.WithError(err).Error("error reconnecting to gcm, keeping the draining connection")
+		return
+	}
+	g.GCMClient = cl
+	g.clientLock.Unlock()
+
+	l.Info("gcm connection draining, reconnected")
+	statsReporterReportMetricCount(g.StatsReporters, "reconnect", 1, g.appName, "gcm")
+	time.AfterFunc(g.drainingTimeout, func() {
+		draining.Close()
+	})
+}
+
 // HandleResponses from gcm
 func (g *GCMMessageHandler) HandleResponses() {
 }
@@ -354,12 +910,18 @@ func (g *GCMMessageHandler) CleanMetadataCache() {
 		g.inflightMessagesMetadataLock.Lock()
 		for deviceToken, hasIndeed = g.requestsHeap.HasExpiredRequest(); hasIndeed; {
 			delete(g.InflightMessagesMetadata, deviceToken)
+			delete(g.inflightMessages, deviceToken)
+			delete(g.deliveryReceiptsMetadata, deviceToken)
 			deviceToken, hasIndeed = g.requestsHeap.HasExpiredRequest()
 		}
 		g.inflightMessagesMetadataLock.Unlock()
 
 		duration := time.Duration(g.CacheCleaningInterval)
-		time.Sleep(duration * time.Millisecond)
+		select {
+		case <-g.stopChannel:
+			return
+		case <-time.After(duration * time.Millisecond):
+		}
 	}
 }
 
@@ -376,31 +938,48 @@ func (g *GCMMessageHandler) LogStats() {
 	})
 
 	ticker := time.NewTicker(g.LogStatsInterval)
-	for range ticker.C {
-		apnsResMutex.Lock()
-		if g.sentMessages > 0 || g.responsesReceived > 0 || g.ignoredMessages > 0 || g.successesReceived > 0 || g.failuresReceived > 0 {
+	defer ticker.Stop()
+	for {
+		select {
+		case <-g.stopChannel:
+			return
+		case <-ticker.C:
+		}
+		gcmResMutex.Lock()
+		if g.sentMessages > 0 || g.responsesReceived > 0 || g.ignoredMessages > 0 || g.successesReceived > 0 || g.failuresReceived > 0 || g.retriedMessages > 0 {
 			l.WithFields(log.Fields{
 				"sentMessages":      g.sentMessages,
 				"responsesReceived": g.responsesReceived,
 				"ignoredMessages":   g.ignoredMessages,
 				"successesReceived": g.successesReceived,
 				"failuresReceived":  g.failuresReceived,
+				"retriedMessages":   g.retriedMessages,
 			}).Info("flushing stats")
 			g.sentMessages = 0
 			g.responsesReceived = 0
 			g.successesReceived = 0
 			g.ignoredMessages = 0
 			g.failuresReceived = 0
+			g.retriedMessages = 0
 		}
-		apnsResMutex.Unlock()
+		gcmResMutex.Unlock()
 	}
 }
 
 //Cleanup closes connections to GCM
 func (g *GCMMessageHandler) Cleanup() error {
-	err := g.GCMClient.Close()
+	g.retryTimers.stop()
+	select {
+	case <-g.stopChannel:
+	default:
+		close(g.stopChannel)
+	}
+	err := g.client().Close()
 	if err != nil {
 		return err
 	}
+	if g.deviceGroups != nil {
+		return g.deviceGroups.Cleanup()
+	}
 	return nil
 }
@@ -24,7 +24,9 @@ package extensions
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
+	"sync"
 	"time"
 
 	. "github.com/onsi/ginkgo"
@@ -39,6 +41,16 @@ import (
 	"github.com/topfreegames/pusher/util"
 )
 
+type conditionClientMock struct {
+	*mocks.GCMClientMock
+	conditions []string
+}
+
+func (m *conditionClientMock) SendToCondition(condition string, msg gcm.XMPPMessage) (string, int, error) {
+	m.conditions = append(m.conditions, condition)
+	return m.SendXMPP(msg)
+}
+
 var _ = Describe("GCM Message Handler", func() {
 	var feedbackClients []interfaces.FeedbackReporter
 	var handler *GCMMessageHandler
@@ -74,12 +86,14 @@ var _ = Describe("GCM Message Handler", func() {
 			handler, err = NewGCMMessageHandler(
 				senderID,
 				apiKey,
+				"game",
 				isProduction,
 				config,
 				logger,
 				nil,
 				statsClients,
 				feedbackClients,
+				nil,
 				mockClient,
 			)
 			Expect(err).NotTo(HaveOccurred())
@@ -102,11 +116,37 @@ var _ = Describe("GCM Message Handler", func() {
 
 		Describe("Configuring Handler", func() {
 			It("should fail if invalid credentials", func() {
+				server, err := NewGCMServer("../tls/self_signed_cert.pem", senderID, apiKey)
+				Expect(err).NotTo(HaveOccurred())
+				defer server.Close()
+				defer server.Route()()
 				handler.apiKey = "badkey"
 				handler.senderID = "badsender"
-				err := handler.configure(nil)
+				err = handler.configure(nil)
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(Equal("error connecting gcm xmpp client: auth failure: not-authorized"))
+				Expect(server.AuthFailures()).To(Equal(1))
+			})
+		})
+
+		Describe("Configuring FCM client", func() {
+			It("should fail if api is invalid", func() {
+				fcmConfig, _ := util.NewViperWithConfigFile(configFile)
+				fcmConfig.Set("gcm.certs.game.api", "http")
+				handler.Config = fcmConfig
+				err := handler.configure(nil)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal("invalid gcm api for app game: http"))
+			})
+
+			It("should fail if service account is invalid", func() {
+				fcmConfig, _ := util.NewViperWithConfigFile(configFile)
+				fcmConfig.Set("gcm.certs.game.api", GCMAPIFCM)
+				fcmConfig.Set("gcm.certs.game.serviceAccountPath", "../tls/missing.json")
+				handler.Config = fcmConfig
+				err := handler.configure(nil)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("error reading fcm service account"))
 			})
 		})
 
@@ -201,15 +241,15 @@ var _ = Describe("GCM Message Handler", func() {
 					"platform":  "gcm",
 				}
 				msg := &KafkaGCMMessage{
-					gcm.XMPPMessage{
+					XMPPMessage: gcm.XMPPMessage{
 						TimeToLive:               &ttl,
 						DeliveryReceiptRequested: false,
 						DryRun:                   true,
 						To:                       uuid.NewV4().String(),
 						Data:                     map[string]interface{}{},
 					},
-					metadata,
-					makeTimestamp() + int64(1000000),
+					Metadata:   metadata,
+					PushExpiry: makeTimestamp() + int64(1000000),
 				}
 				msgBytes, err := json.Marshal(msg)
 				Expect(err).NotTo(HaveOccurred())
@@ -232,15 +272,15 @@ var _ = Describe("GCM Message Handler", func() {
 					"platform":  "gcm",
 				}
 				msg := &KafkaGCMMessage{
-					gcm.XMPPMessage{
+					XMPPMessage: gcm.XMPPMessage{
 						TimeToLive:               &ttl,
 						DeliveryReceiptRequested: false,
 						DryRun:                   true,
 						To:                       uuid.NewV4().String(),
 						Data:                     map[string]interface{}{},
 					},
-					metadata,
-					makeTimestamp() - int64(100),
+					Metadata:   metadata,
+					PushExpiry: makeTimestamp() - int64(100),
 				}
 				msgBytes, err := json.Marshal(msg)
 				Expect(err).NotTo(HaveOccurred())
@@ -270,6 +310,34 @@ var _ = Describe("GCM Message Handler", func() {
 				Expect(len(handler.pendingMessages)).To(Equal(0))
 			})
 
+			It("should mark messages that fail before reaching GCM as done", func() {
+				wg := &sync.WaitGroup{}
+				wg.Add(2)
+				handler.pendingMessagesWG = wg
+				mockClient.SendError = fmt.Errorf("connection closed")
+
+				err := handler.sendMessage(interfaces.KafkaMessage{
+					Topic: "push-game_gcm",
+					Value: []byte("gogogo"),
+				})
+				Expect(err).To(HaveOccurred())
+				err = handler.sendMessage(interfaces.KafkaMessage{
+					Topic: "push-game_gcm",
+					Value: []byte(`{"to": "token"}`),
+				})
+				Expect(err).To(HaveOccurred())
+				Expect(hook.Entries).To(ContainLogMessage("Error sending message."))
+				Expect(handler.failuresReceived).To(Equal(int64(2)))
+				Expect(len(handler.pendingMessages)).To(Equal(0))
+
+				done := make(chan struct{})
+				go func() {
+					wg.Wait()
+					close(done)
+				}()
+				Eventually(done).Should(BeClosed())
+			})
+
 			It("should send xmpp message", func() {
 				ttl := uint(0)
 				msg := &gcm.XMPPMessage{
@@ -302,15 +370,15 @@ var _ = Describe("GCM Message Handler", func() {
 					"platform":  "gcm",
 				}
 				msg := &KafkaGCMMessage{
-					gcm.XMPPMessage{
+					XMPPMessage: gcm.XMPPMessage{
 						TimeToLive:               &ttl,
 						DeliveryReceiptRequested: false,
 						DryRun:                   true,
 						To:                       uuid.NewV4().String(),
 						Data:                     map[string]interface{}{},
 					},
-					metadata,
-					makeTimestamp() + int64(1000000),
+					Metadata:   metadata,
+					PushExpiry: makeTimestamp() + int64(1000000),
 				}
 				msgBytes, err := json.Marshal(msg)
 				Expect(err).NotTo(HaveOccurred())
@@ -444,6 +512,350 @@ var _ = Describe("GCM Message Handler", func() {
 			})
 		})
 
+		Describe("Topic messages", func() {
+			It("should tag stats and metadata of topic messages", func() {
+				err := handler.sendMessage(interfaces.KafkaMessage{
+					Game:  "game",
+					Topic: "push-game_gcm",
+					Value: []byte(`{"to": "/topics/news"}`),
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(mockClient.MessagesSent).To(HaveLen(1))
+				Expect(mockStatsDClient.Tags["sent"]).To(ContainElement("target:topic"))
+				for _, metadata := range handler.InflightMessagesMetadata {
+					Expect(metadata.(map[string]interface{})["target"]).To(Equal("topic"))
+				}
+			})
+
+			It("should not tag stats of token messages", func() {
+				err := handler.sendMessage(interfaces.KafkaMessage{
+					Game:  "game",
+					Topic: "push-game_gcm",
+					Value: []byte(`{"to": "token"}`),
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(mockStatsDClient.Tags["sent"]).NotTo(ContainElement("target:topic"))
+			})
+
+			It("should not send messages to invalid topics", func() {
+				err := handler.sendMessage(interfaces.KafkaMessage{
+					Game:  "game",
+					Topic: "push-game_gcm",
+					Value: []byte(`{"to": "/topics/news br"}`),
+				})
+				Expect(err).To(HaveOccurred())
+				Expect(mockClient.MessagesSent).To(HaveLen(0))
+				Expect(mockStatsDClient.Counts["failed"]).To(Equal(int64(1)))
+				Expect(mockStatsDClient.Tags["failed"]).To(ContainElement("target:topic"))
+				Expect(hook.Entries).To(ContainLogMessage("Invalid message target."))
+			})
+
+			It("should not send conditions with clients that do not support them", func() {
+				err := handler.sendMessage(interfaces.KafkaMessage{
+					Game:  "game",
+					Topic: "push-game_gcm",
+					Value: []byte(`{"condition": "'dogs' in topics"}`),
+				})
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal("conditions are only supported by the fcm api"))
+				Expect(mockClient.MessagesSent).To(HaveLen(0))
+			})
+
+			It("should send conditions with clients that support them", func() {
+				client := &conditionClientMock{GCMClientMock: mockClient}
+				handler.GCMClient = client
+				err := handler.sendMessage(interfaces.KafkaMessage{
+					Game:  "game",
+					Topic: "push-game_gcm",
+					Value: []byte(`{"condition": "'dogs' in topics || 'cats' in topics"}`),
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(client.conditions).To(Equal([]string{"'dogs' in topics || 'cats' in topics"}))
+				Expect(mockStatsDClient.Tags["sent"]).To(ContainElement("target:topic"))
+
+				err = handler.sendMessage(interfaces.KafkaMessage{
+					Game:  "game",
+					Topic: "push-game_gcm",
+					Value: []byte(`{"condition": "'dogs' in topics ||"}`),
+				})
+				Expect(err).To(HaveOccurred())
+				Expect(client.conditions).To(HaveLen(1))
+			})
+
+			It("should not delete token if topic message fails", func() {
+				mockKafkaProducerClient = mocks.NewKafkaProducerClientMock()
+				kc, err := NewKafkaProducer(config, logger, mockKafkaProducerClient)
+				Expect(err).NotTo(HaveOccurred())
+				handler.feedbackReporters = []interfaces.FeedbackReporter{kc}
+
+				for _, e := range []string{"TOPICS_MESSAGE_RATE_EXCEEDED", "BAD_REGISTRATION"} {
+					handler.InflightMessagesMetadata["idTest1"] = map[string]interface{}{
+						"timestamp": time.Now().Unix(),
+						"game":      "game",
+						"platform":  "gcm",
+						"target":    "topic",
+					}
+					go handler.handleGCMResponse(gcm.CCSMessage{
+						From:        "/topics/news",
+						MessageID:   "idTest1",
+						MessageType: "nack",
+						Error:       e,
+					})
+
+					fromKafka := &CCSMessageWithMetadata{}
+					msg := <-mockKafkaProducerClient.ProduceChannel()
+					json.Unmarshal(msg.Value, fromKafka)
+					Expect(fromKafka.Error).To(Equal(e))
+					Expect(fromKafka.Metadata["target"]).To(Equal("topic"))
+					Expect(fromKafka.Metadata["deleteToken"]).To(BeNil())
+				}
+				Expect(mockStatsDClient.Tags["failed"]).To(ContainElement("target:topic"))
+			})
+		})
+
+		Describe("Device group messages", func() {
+			var server *DeviceGroupServer
+			var stored *DeviceGroup
+
+			BeforeEach(func() {
+				server = NewDeviceGroupServer()
+				config.Set("gcm.deviceGroups.host", server.URL)
+				stored = nil
+				db := mocks.NewPGMock(0, 1)
+				db.QueryModel = func(model interface{}) {
+					if stored != nil {
+						groups := model.(*[]*DeviceGroup)
+						*groups = append(*groups, stored)
+					}
+				}
+				deviceGroups, err := NewDeviceGroups("game", config, logger, db)
+				Expect(err).NotTo(HaveOccurred())
+				handler.deviceGroups = deviceGroups
+
+				mockKafkaProducerClient = mocks.NewKafkaProducerClientMock()
+				kc, err := NewKafkaProducer(config, logger, mockKafkaProducerClient)
+				Expect(err).NotTo(HaveOccurred())
+				handler.feedbackReporters = []interfaces.FeedbackReporter{kc}
+			})
+
+			AfterEach(func() {
+				server.Close()
+			})
+
+			createGroup := func(tokens ...string) {
+				group, err := handler.deviceGroups.Create("user1", tokens)
+				Expect(err).NotTo(HaveOccurred())
+				stored = group
+			}
+
+			It("should report partial results of device group messages", func() {
+				createGroup("token1", "token2", "token3")
+				server.SetFailingToken("token3")
+				go handler.sendMessage(interfaces.KafkaMessage{
+					Game:  "game",
+					Topic: "push-game_gcm",
+					Value: []byte(`{"notification_key_name": "user1", "data": {"title": "hello"}, "metadata": {"some": "metadata"}}`),
+				})
+
+				fromKafka := &CCSMessageWithMetadata{}
+				msg := <-mockKafkaProducerClient.ProduceChannel()
+				json.Unmarshal(msg.Value, fromKafka)
+				Expect(fromKafka.MessageType).To(Equal("ack"))
+				Expect(fromKafka.From).To(Equal("user1"))
+				Expect(*fromKafka.Success).To(Equal(2))
+				Expect(*fromKafka.Failure).To(Equal(1))
+				Expect(fromKafka.FailedRegistrationIDs).To(Equal([]string{"token3"}))
+				Expect(fromKafka.Metadata["target"]).To(Equal("device_group"))
+				Expect(fromKafka.Metadata["some"]).To(Equal("metadata"))
+				Expect(fromKafka.Metadata["deleteToken"]).To(BeNil())
+
+				Expect(mockClient.MessagesSent).To(BeEmpty())
+				Expect(server.Messages()[0]["to"]).To(Equal(stored.NotificationKey))
+				Expect(mockStatsDClient.Counts["sent"]).To(Equal(int64(1)))
+				Expect(mockStatsDClient.Counts["ack"]).To(Equal(int64(1)))
+				Expect(mockStatsDClient.Counts["device_group_failed_tokens"]).To(Equal(int64(1)))
+				Expect(mockStatsDClient.Tags["ack"]).To(ContainElement("target:device_group"))
+			})
+
+			It("should report a failure if no device received the message", func() {
+				createGroup("token1")
+				server.SetFailingToken("token1")
+				go handler.sendMessage(interfaces.KafkaMessage{
+					Game:  "game",
+					Topic: "push-game_gcm",
+					Value: []byte(`{"notification_key_name": "user1"}`),
+				})
+
+				fromKafka := &CCSMessageWithMetadata{}
+				msg := <-mockKafkaProducerClient.ProduceChannel()
+				json.Unmarshal(msg.Value, fromKafka)
+				Expect(fromKafka.MessageType).To(Equal("nack"))
+				Expect(fromKafka.Error).To(Equal(GCMErrorDeviceGroupFailed))
+				Expect(*fromKafka.Success).To(Equal(0))
+				Expect(mockStatsDClient.Counts["failed"]).To(Equal(int64(1)))
+			})
+
+			It("should fail if the device group does not exist", func() {
+				errChan := make(chan error, 1)
+				go func() {
+					errChan <- handler.sendMessage(interfaces.KafkaMessage{
+						Game:  "game",
+						Topic: "push-game_gcm",
+						Value: []byte(`{"notification_key_name": "user1"}`),
+					})
+				}()
+
+				fromKafka := &CCSMessageWithMetadata{}
+				msg := <-mockKafkaProducerClient.ProduceChannel()
+				json.Unmarshal(msg.Value, fromKafka)
+				Expect(fromKafka.Error).To(Equal(GCMErrorInvalidRequest))
+				Expect(fromKafka.Metadata["target"]).To(Equal("device_group"))
+
+				err := <-errChan
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal("device group user1 does not exist"))
+				Expect(server.Messages()).To(BeEmpty())
+			})
+
+			It("should fail if device groups are not enabled", func() {
+				handler.deviceGroups = nil
+				mockKafkaProducerClient.StartConsumingMessagesInProduceChannel()
+				err := handler.sendMessage(interfaces.KafkaMessage{
+					Game:  "game",
+					Topic: "push-game_gcm",
+					Value: []byte(`{"notification_key_name": "user1"}`),
+				})
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal("device groups are not enabled"))
+				Expect(mockStatsDClient.Counts["failed"]).To(Equal(int64(1)))
+			})
+		})
+
+		Describe("Retrying messages", func() {
+			var messageID string
+
+			BeforeEach(func() {
+				err := handler.sendMessage(interfaces.KafkaMessage{
+					Game:  "game",
+					Topic: "push-game_gcm",
+					Value: []byte(`{"to":"token","data":{"title":"hello"}}`),
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(handler.inflightMessages).To(HaveLen(1))
+				for id := range handler.inflightMessages {
+					messageID = id
+				}
+			})
+
+			nack := func(err string) gcm.CCSMessage {
+				return gcm.CCSMessage{
+					From:        "token",
+					MessageID:   messageID,
+					MessageType: "nack",
+					Error:       err,
+				}
+			}
+
+			It("should resend message with same id if response has transient error", func() {
+				for _, e := range []string{"SERVICE_UNAVAILABLE", "INTERNAL_SERVER_ERROR"} {
+					handler.handleGCMResponse(nack(e))
+				}
+				Eventually(func() int { return len(mockClient.MessagesSent) }).Should(Equal(3))
+				Expect(mockClient.MessagesSent[1].MessageID).To(Equal(messageID))
+				Expect(mockClient.MessagesSent[1].To).To(Equal("token"))
+				Expect(mockClient.MessagesSent[1].Data).To(Equal(mockClient.MessagesSent[0].Data))
+				Expect(handler.failuresReceived).To(Equal(int64(0)))
+				Expect(handler.retriedMessages).To(Equal(int64(2)))
+				Expect(mockStatsDClient.Counts["retry"]).To(Equal(int64(2)))
+				Expect(mockStatsDClient.Tags["retry"]).To(ContainElement("reason:internal_server_error"))
+				Expect(mockStatsDClient.Counts["failed"]).To(Equal(int64(0)))
+				Expect(mockKafkaProducerClient.SentMessages).To(Equal(0))
+			})
+
+			It("should report failure when attempts are exhausted", func() {
+				for i := 0; i < 3; i++ {
+					handler.handleGCMResponse(nack("SERVICE_UNAVAILABLE"))
+				}
+				Eventually(func() int { return len(mockClient.MessagesSent) }).Should(Equal(3))
+				Expect(handler.failuresReceived).To(Equal(int64(1)))
+				Expect(handler.inflightMessages).To(BeEmpty())
+				Expect(mockStatsDClient.Counts["retry"]).To(Equal(int64(2)))
+				Expect(mockStatsDClient.Counts["retry_exhausted"]).To(Equal(int64(1)))
+				Expect(mockStatsDClient.Tags["retry_exhausted"]).To(ContainElement("reason:service_unavailable"))
+				Expect(mockStatsDClient.Counts["failed"]).To(Equal(int64(1)))
+			})
+
+			It("should not resend message if response has other error", func() {
+				handler.handleGCMResponse(nack("BAD_REGISTRATION"))
+				Consistently(func() int { return len(mockClient.MessagesSent) }).Should(Equal(1))
+				Expect(handler.failuresReceived).To(Equal(int64(1)))
+				Expect(handler.inflightMessages).To(BeEmpty())
+			})
+
+			It("should resend message with a new client if connection is draining", func() {
+				newClient := mocks.NewGCMClientMock()
+				clients := 0
+				handler.newClient = func() (interfaces.GCMClient, error) {
+					clients++
+					return newClient, nil
+				}
+				handler.handleGCMResponse(nack("CONNECTION_DRAINING"))
+
+				Eventually(func() int { return len(newClient.MessagesSent) }).Should(Equal(1))
+				Expect(newClient.MessagesSent[0].MessageID).To(Equal(messageID))
+				Expect(handler.GCMClient).To(Equal(newClient))
+				Expect(mockClient.MessagesSent).To(HaveLen(1))
+				Expect(mockStatsDClient.Tags["retry"]).To(ContainElement("reason:connection_draining"))
+				Eventually(func() bool { return mockClient.Closed }).Should(BeTrue())
+				Expect(clients).To(Equal(1))
+				Expect(mockStatsDClient.Counts["reconnect"]).To(Equal(int64(1)))
+			})
+
+			It("should reconnect once if many messages of the draining connection fail", func() {
+				err := handler.sendMessage(interfaces.KafkaMessage{
+					Game:  "game",
+					Topic: "push-game_gcm",
+					Value: []byte(`{"to":"token2","data":{"title":"hello"}}`),
+				})
+				Expect(err).NotTo(HaveOccurred())
+				clients := 0
+				handler.newClient = func() (interfaces.GCMClient, error) {
+					clients++
+					return mocks.NewGCMClientMock(), nil
+				}
+				for id := range handler.inflightMessages {
+					handler.handleGCMResponse(gcm.CCSMessage{
+						MessageID:   id,
+						MessageType: "nack",
+						Error:       "CONNECTION_DRAINING",
+					})
+				}
+				Expect(clients).To(Equal(1))
+			})
+
+			It("should reconnect if a draining control message is received", func() {
+				newClient := mocks.NewGCMClientMock()
+				handler.newClient = func() (interfaces.GCMClient, error) {
+					return newClient, nil
+				}
+				handler.handleGCMResponse(gcm.CCSMessage{
+					MessageType: "control",
+					ControlType: "CONNECTION_DRAINING",
+				})
+				Expect(handler.GCMClient).To(Equal(newClient))
+				Expect(handler.responsesReceived).To(Equal(int64(0)))
+			})
+
+			It("should keep draining client if reconnecting fails", func() {
+				handler.newClient = func() (interfaces.GCMClient, error) {
+					return nil, fmt.Errorf("connection refused")
+				}
+				handler.handleGCMResponse(nack("CONNECTION_DRAINING"))
+				Expect(handler.GCMClient).To(Equal(mockClient))
+				Eventually(func() int { return len(mockClient.MessagesSent) }).Should(Equal(2))
+			})
+		})
+
 		Describe("Stats Reporter sent message", func() {
 			It("should call HandleNotificationSent upon message sent to queue", func() {
 				ttl := uint(0)
@@ -469,6 +881,27 @@ var _ = Describe("GCM Message Handler", func() {
 				Expect(mockStatsDClient.Counts["sent"]).To(Equal(int64(2)))
 			})
 
+			It("should report how late scheduled messages are sent", func() {
+				sendAt := time.Now().Add(-time.Minute).UnixNano() / int64(time.Millisecond)
+				err := handler.sendMessage(interfaces.KafkaMessage{
+					Game:  "game",
+					Topic: "push-game_gcm",
+					Value: []byte(fmt.Sprintf(`{"to": "token", "send_at": %d}`, sendAt)),
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(mockStatsDClient.Timings["send_at_lag"]).To(BeNumerically(">=", time.Minute))
+			})
+
+			It("should not report the lag of messages without send_at", func() {
+				err := handler.sendMessage(interfaces.KafkaMessage{
+					Game:  "game",
+					Topic: "push-game_gcm",
+					Value: []byte(`{"to": "token"}`),
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(mockStatsDClient.Timings).NotTo(HaveKey("send_at_lag"))
+			})
+
 			It("should call HandleNotificationSuccess upon message response received", func() {
 				res := gcm.CCSMessage{}
 				handler.handleGCMResponse(res)
@@ -485,6 +918,25 @@ var _ = Describe("GCM Message Handler", func() {
 
 				Expect(mockStatsDClient.Counts["failed"]).To(Equal(int64(2)))
 			})
+
+			It("should call HandleNotificationFailure if the message could not be sent", func() {
+				mockClient.SendError = fmt.Errorf("connection closed")
+				err := handler.sendMessage(interfaces.KafkaMessage{
+					Game:  "game",
+					Topic: "push-game_gcm",
+					Value: []byte(`{"to": "token"}`),
+				})
+				Expect(err).To(HaveOccurred())
+
+				err = handler.sendMessage(interfaces.KafkaMessage{
+					Game:  "game",
+					Topic: "push-game_gcm",
+					Value: []byte("gogogo"),
+				})
+				Expect(err).To(HaveOccurred())
+
+				Expect(mockStatsDClient.Counts["failed"]).To(Equal(int64(2)))
+			})
 		})
 
 		Describe("Feedback Reporter sent message", func() {
@@ -501,12 +953,14 @@ var _ = Describe("GCM Message Handler", func() {
 				handler, err = NewGCMMessageHandler(
 					senderID,
 					apiKey,
+					"game",
 					isProduction,
 					config,
 					logger,
 					nil,
 					statsClients,
 					feedbackClients,
+					nil,
 					mockClient,
 				)
 				Expect(err).NotTo(HaveOccurred())
@@ -643,6 +1097,61 @@ var _ = Describe("GCM Message Handler", func() {
 				Expect(fromKafka.Metadata["deleteToken"]).To(BeNil())
 			})
 
+			It("should send feedback with token update if response has canonical registration id", func() {
+				metadata := map[string]interface{}{
+					"some":      "metadata",
+					"timestamp": time.Now().Unix(),
+					"game":      "game",
+					"platform":  "gcm",
+				}
+				handler.InflightMessagesMetadata["idTest1"] = metadata
+				res := gcm.CCSMessage{
+					From:           "testToken1",
+					MessageID:      "idTest1",
+					MessageType:    "ack",
+					RegistrationID: "newToken1",
+				}
+				go handler.handleGCMResponse(res)
+
+				fromKafka := &CCSMessageWithMetadata{}
+				msg := <-mockKafkaProducerClient.ProduceChannel()
+				json.Unmarshal(msg.Value, fromKafka)
+				Expect(fromKafka.From).To(Equal(res.From))
+				Expect(fromKafka.Metadata["some"]).To(Equal(metadata["some"]))
+				Expect(fromKafka.Metadata["tokenUpdate"]).To(Equal("newToken1"))
+				Eventually(func() int64 { return mockStatsDClient.Counts["token_update"] }).Should(Equal(int64(1)))
+			})
+
+			It("should send feedback with token update if response has canonical registration id and metadata is not present", func() {
+				res := gcm.CCSMessage{
+					From:           "testToken1",
+					MessageID:      "idTest1",
+					MessageType:    "ack",
+					RegistrationID: "newToken1",
+				}
+				go handler.handleGCMResponse(res)
+
+				fromKafka := &CCSMessageWithMetadata{}
+				msg := <-mockKafkaProducerClient.ProduceChannel()
+				json.Unmarshal(msg.Value, fromKafka)
+				Expect(fromKafka.Metadata["tokenUpdate"]).To(Equal("newToken1"))
+			})
+
+			It("should not send token update if registration id is the same token", func() {
+				res := gcm.CCSMessage{
+					From:           "testToken1",
+					MessageID:      "idTest1",
+					MessageType:    "ack",
+					RegistrationID: "testToken1",
+				}
+				go handler.handleGCMResponse(res)
+
+				fromKafka := &CCSMessageWithMetadata{}
+				msg := <-mockKafkaProducerClient.ProduceChannel()
+				json.Unmarshal(msg.Value, fromKafka)
+				Expect(fromKafka.Metadata).To(BeNil())
+			})
+
 			It("should send feedback if error and metadata is not present", func() {
 				res := gcm.CCSMessage{
 					From:        "testToken1",
@@ -663,6 +1172,175 @@ var _ = Describe("GCM Message Handler", func() {
 				Expect(fromKafka.Error).To(Equal(res.Error))
 				Expect(fromKafka.Metadata).To(BeNil())
 			})
+
+			It("should send invalid-request feedback if message is not valid json", func() {
+				go handler.sendMessage(interfaces.KafkaMessage{
+					Game:  "game",
+					Topic: "push-game_gcm",
+					Value: []byte("gogogo"),
+				})
+
+				fromKafka := &CCSMessageWithMetadata{}
+				msg := <-mockKafkaProducerClient.ProduceChannel()
+				json.Unmarshal(msg.Value, fromKafka)
+				Expect(fromKafka.MessageType).To(Equal("nack"))
+				Expect(fromKafka.Error).To(Equal(GCMErrorInvalidRequest))
+				Expect(fromKafka.ErrorDescription).NotTo(BeEmpty())
+				Expect(fromKafka.Metadata["game"]).To(Equal("game"))
+				Expect(fromKafka.Metadata["platform"]).To(Equal("gcm"))
+			})
+
+			It("should send send-error feedback if the message could not be sent", func() {
+				mockClient.SendError = fmt.Errorf("connection closed")
+				go handler.sendMessage(interfaces.KafkaMessage{
+					Game:  "game",
+					Topic: "push-game_gcm",
+					Value: []byte(`{"to": "token", "message_id": "idTest1", "metadata": {"some": "metadata"}}`),
+				})
+
+				fromKafka := &CCSMessageWithMetadata{}
+				msg := <-mockKafkaProducerClient.ProduceChannel()
+				json.Unmarshal(msg.Value, fromKafka)
+				Expect(fromKafka.From).To(Equal("token"))
+				Expect(fromKafka.MessageID).To(Equal("idTest1"))
+				Expect(fromKafka.MessageType).To(Equal("nack"))
+				Expect(fromKafka.Error).To(Equal(GCMErrorSendError))
+				Expect(fromKafka.ErrorDescription).To(Equal("connection closed"))
+				Expect(fromKafka.Metadata["some"]).To(Equal("metadata"))
+				Expect(fromKafka.Metadata["game"]).To(Equal("game"))
+			})
+		})
+
+		Describe("Delivery receipts and upstream messages", func() {
+			BeforeEach(func() {
+				var err error
+				mockKafkaProducerClient = mocks.NewKafkaProducerClientMock()
+				kc, err := NewKafkaProducer(config, logger, mockKafkaProducerClient)
+				Expect(err).NotTo(HaveOccurred())
+				feedbackClients = []interfaces.FeedbackReporter{kc}
+				handler.feedbackReporters = feedbackClients
+			})
+
+			receipt := func(messageID string) gcm.CCSMessage {
+				return gcm.CCSMessage{
+					From:        "gcm.googleapis.com",
+					MessageID:   "dr2:" + messageID,
+					MessageType: "receipt",
+					Category:    "com.example.game",
+					Data: gcm.Data{
+						"message_status":         "MESSAGE_SENT_TO_DEVICE",
+						"original_message_id":    messageID,
+						"device_registration_id": "token",
+						"message_sent_timestamp": "1486995974239",
+					},
+				}
+			}
+
+			It("should send delivered feedback with the original metadata", func() {
+				err := handler.sendMessage(interfaces.KafkaMessage{
+					Game:  "game",
+					Topic: "push-game_gcm",
+					Value: []byte(`{"to": "token", "delivery_receipt_requested": true, "metadata": {"some": "metadata"}}`),
+				})
+				Expect(err).NotTo(HaveOccurred())
+				var messageID string
+				for id := range handler.inflightMessages {
+					messageID = id
+				}
+
+				go handler.handleGCMResponse(gcm.CCSMessage{
+					From:        "token",
+					MessageID:   messageID,
+					MessageType: "ack",
+				})
+				msg := <-mockKafkaProducerClient.ProduceChannel()
+				fromKafka := &CCSMessageWithMetadata{}
+				json.Unmarshal(msg.Value, fromKafka)
+				Expect(fromKafka.MessageType).To(Equal("ack"))
+
+				go handler.handleGCMResponse(receipt(messageID))
+				msg = <-mockKafkaProducerClient.ProduceChannel()
+				fromKafka = &CCSMessageWithMetadata{}
+				json.Unmarshal(msg.Value, fromKafka)
+				Expect(*msg.TopicPartition.Topic).To(Equal("push-game-gcm-feedbacks"))
+				Expect(fromKafka.MessageType).To(Equal("delivered"))
+				Expect(fromKafka.MessageID).To(Equal(messageID))
+				Expect(fromKafka.From).To(Equal("token"))
+				Expect(fromKafka.Metadata["some"]).To(Equal("metadata"))
+				Expect(fromKafka.Metadata["timestamp"]).To(BeNil())
+				Expect(fromKafka.Timestamp).NotTo(BeZero())
+				Expect(handler.deliveryReceiptsMetadata).To(BeEmpty())
+				Expect(mockStatsDClient.Counts["delivered"]).To(Equal(int64(1)))
+			})
+
+			It("should not keep metadata of messages without delivery receipts", func() {
+				err := handler.sendMessage(interfaces.KafkaMessage{
+					Game:  "game",
+					Topic: "push-game_gcm",
+					Value: []byte(`{"to": "token", "metadata": {"some": "metadata"}}`),
+				})
+				Expect(err).NotTo(HaveOccurred())
+				var messageID string
+				for id := range handler.inflightMessages {
+					messageID = id
+				}
+
+				go handler.handleGCMResponse(gcm.CCSMessage{
+					From:        "token",
+					MessageID:   messageID,
+					MessageType: "ack",
+				})
+				<-mockKafkaProducerClient.ProduceChannel()
+				Expect(handler.deliveryReceiptsMetadata).To(BeEmpty())
+			})
+
+			It("should send delivered feedback without metadata if receipt is unknown", func() {
+				go handler.handleGCMResponse(receipt("idTest1"))
+				msg := <-mockKafkaProducerClient.ProduceChannel()
+				fromKafka := &CCSMessageWithMetadata{}
+				json.Unmarshal(msg.Value, fromKafka)
+				Expect(*msg.TopicPartition.Topic).To(Equal("push-game-gcm-feedbacks"))
+				Expect(fromKafka.MessageType).To(Equal("delivered"))
+				Expect(fromKafka.MessageID).To(Equal("idTest1"))
+				Expect(fromKafka.Metadata).To(BeNil())
+			})
+
+			It("should not count receipts and upstream messages as responses", func() {
+				mockKafkaProducerClient.StartConsumingMessagesInProduceChannel()
+				handler.handleGCMResponse(receipt("idTest1"))
+				handler.handleGCMResponse(gcm.CCSMessage{
+					From:      "token",
+					MessageID: "upstream1",
+					Category:  "com.example.game",
+					Data:      gcm.Data{"score": "10"},
+				})
+				Expect(handler.responsesReceived).To(BeZero())
+				Expect(handler.successesReceived).To(BeZero())
+				Expect(mockStatsDClient.Counts["ack"]).To(BeZero())
+			})
+
+			It("should publish upstream messages to the game topic", func() {
+				upstreamProducer := mocks.NewKafkaProducerClientMock()
+				reporter, err := NewKafkaUpstreamReporter(config, logger, upstreamProducer)
+				Expect(err).NotTo(HaveOccurred())
+				handler.upstreamReporters = []interfaces.UpstreamReporter{reporter}
+
+				go handler.handleGCMResponse(gcm.CCSMessage{
+					From:      "token",
+					MessageID: "upstream1",
+					Category:  "com.example.game",
+					Data:      gcm.Data{"score": "10"},
+				})
+				msg := <-upstreamProducer.ProduceChannel()
+				fromKafka := &CCSMessageWithMetadata{}
+				json.Unmarshal(msg.Value, fromKafka)
+				Expect(*msg.TopicPartition.Topic).To(Equal("push-game_gcm-upstream"))
+				Expect(fromKafka.From).To(Equal("token"))
+				Expect(fromKafka.MessageID).To(Equal("upstream1"))
+				Expect(fromKafka.Data["score"]).To(Equal("10"))
+				Expect(fromKafka.Metadata["game"]).To(Equal("game"))
+				Eventually(func() int64 { return mockStatsDClient.Counts["upstream"] }).Should(Equal(int64(1)))
+			})
 		})
 
 		Describe("Cleanup", func() {
@@ -671,6 +1349,22 @@ var _ = Describe("GCM Message Handler", func() {
 				Expect(err).NotTo(HaveOccurred())
 				Expect(handler.GCMClient.(*mocks.GCMClientMock).Closed).To(BeTrue())
 			})
+
+			It("should stop logging stats and cleaning the cache", func() {
+				done := make(chan bool, 2)
+				go func() {
+					handler.LogStats()
+					done <- true
+				}()
+				go func() {
+					handler.CleanMetadataCache()
+					done <- true
+				}()
+				Expect(handler.Cleanup()).To(Succeed())
+				Eventually(done).Should(Receive())
+				Eventually(done).Should(Receive())
+				Expect(handler.Cleanup()).To(Succeed())
+			})
 		})
 	})
 
@@ -689,6 +1383,7 @@ var _ = Describe("GCM Message Handler", func() {
 			handler, err = NewGCMMessageHandler(
 				senderID,
 				apiKey,
+				"game",
 				isProduction,
 				config,
 				logger,
@@ -696,6 +1391,7 @@ var _ = Describe("GCM Message Handler", func() {
 				statsClients,
 				feedbackClients,
 				nil,
+				nil,
 			)
 			Expect(err).NotTo(HaveOccurred())
 
@@ -708,6 +1404,7 @@ var _ = Describe("GCM Message Handler", func() {
 				handler, err = NewGCMMessageHandler(
 					senderID,
 					apiKey,
+					"game",
 					isProduction,
 					config,
 					logger,
@@ -715,6 +1412,7 @@ var _ = Describe("GCM Message Handler", func() {
 					statsClients,
 					feedbackClients,
 					nil,
+					nil,
 				)
 				Expect(handler).To(BeNil())
 				Expect(err).To(HaveOccurred())
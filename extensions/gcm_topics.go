@@ -0,0 +1,145 @@
+/*
+ * Copyright (c) 2026 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package extensions
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// GCMTopicPrefix is the prefix of the to field of messages sent to a topic
+const GCMTopicPrefix = "/topics/"
+
+// GCMMaxConditionTopics is the max number of topics in a condition
+const GCMMaxConditionTopics = 5
+
+var gcmTopicName = regexp.MustCompile(`^[a-zA-Z0-9-_.~%]+$`)
+
+// IsGCMTopic returns if the to field of a message is a topic
+func IsGCMTopic(to string) bool {
+	return strings.HasPrefix(to, GCMTopicPrefix)
+}
+
+// ValidateGCMTopic returns an error if the topic, with or without the
+// /topics/ prefix, is not a valid topic name
+func ValidateGCMTopic(topic string) error {
+	name := strings.TrimPrefix(topic, GCMTopicPrefix)
+	if !gcmTopicName.MatchString(name) {
+		return fmt.Errorf("invalid topic name %q", name)
+	}
+	return nil
+}
+
+// ValidateGCMCondition returns an error if the condition is not a valid
+// combination of topics, e.g. "'dogs' in topics && !('cats' in topics)"
+func ValidateGCMCondition(condition string) error {
+	tokens, err := tokenizeGCMCondition(condition)
+	if err != nil {
+		return err
+	}
+	topics := 0
+	for _, token := range tokens {
+		if token == "topic" {
+			topics++
+		}
+	}
+	if topics > GCMMaxConditionTopics {
+		return fmt.Errorf("condition %q has more than %d topics", condition, GCMMaxConditionTopics)
+	}
+	p := &gcmConditionParser{tokens: tokens}
+	if !p.expression() || p.pos != len(tokens) {
+		return fmt.Errorf("invalid condition %q", condition)
+	}
+	return nil
+}
+
+var gcmConditionToken = regexp.MustCompile(`^\s*(?:'([^']*)'\s+in\s+topics\b|(&&|\|\||!|\(|\)))`)
+
+// tokenizeGCMCondition splits a condition in operators and "topic" tokens
+func tokenizeGCMCondition(condition string) ([]string, error) {
+	tokens := []string{}
+	rest := condition
+	for strings.TrimSpace(rest) != "" {
+		m := gcmConditionToken.FindStringSubmatchIndex(rest)
+		if m == nil {
+			return nil, fmt.Errorf("invalid condition %q", condition)
+		}
+		if m[2] >= 0 {
+			if err := ValidateGCMTopic(rest[m[2]:m[3]]); err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, "topic")
+		} else {
+			tokens = append(tokens, rest[m[4]:m[5]])
+		}
+		rest = rest[m[1]:]
+	}
+	return tokens, nil
+}
+
+type gcmConditionParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *gcmConditionParser) next() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+// expression := term (("&&" | "||") term)*
+func (p *gcmConditionParser) expression() bool {
+	if !p.term() {
+		return false
+	}
+	for p.next() == "&&" || p.next() == "||" {
+		p.pos++
+		if !p.term() {
+			return false
+		}
+	}
+	return true
+}
+
+// term := "!" term | "(" expression ")" | topic
+func (p *gcmConditionParser) term() bool {
+	switch p.next() {
+	case "!":
+		p.pos++
+		return p.term()
+	case "(":
+		p.pos++
+		if !p.expression() || p.next() != ")" {
+			return false
+		}
+		p.pos++
+		return true
+	case "topic":
+		p.pos++
+		return true
+	}
+	return false
+}
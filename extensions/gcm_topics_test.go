@@ -0,0 +1,79 @@
+/*
+ * Copyright (c) 2026 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package extensions
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("GCM Topics", func() {
+	Describe("[Unit]", func() {
+		Describe("Validating topics", func() {
+			It("should accept valid topic names", func() {
+				Expect(ValidateGCMTopic("/topics/news")).To(Succeed())
+				Expect(ValidateGCMTopic("news-br_2.0~%20")).To(Succeed())
+			})
+
+			It("should reject invalid topic names", func() {
+				Expect(ValidateGCMTopic("/topics/")).NotTo(Succeed())
+				Expect(ValidateGCMTopic("/topics/news br")).NotTo(Succeed())
+				Expect(ValidateGCMTopic("/topics/news/br")).NotTo(Succeed())
+			})
+		})
+
+		Describe("Validating conditions", func() {
+			It("should accept valid conditions", func() {
+				for _, condition := range []string{
+					"'dogs' in topics",
+					"'dogs' in topics && 'cats' in topics",
+					"'dogs' in topics && ('cats' in topics || 'birds' in topics)",
+					"!('dogs' in topics)&&!'cats' in topics",
+				} {
+					Expect(ValidateGCMCondition(condition)).To(Succeed(), condition)
+				}
+			})
+
+			It("should reject invalid conditions", func() {
+				for _, condition := range []string{
+					"",
+					"dogs",
+					"'dogs' in topics &&",
+					"'dogs' in topics 'cats' in topics",
+					"('dogs' in topics",
+					"'dogs' in topics)",
+					"'do gs' in topics",
+					"'dogs' in topicss",
+				} {
+					Expect(ValidateGCMCondition(condition)).NotTo(Succeed(), condition)
+				}
+			})
+
+			It("should reject conditions with more than 5 topics", func() {
+				condition := "'a' in topics && 'b' in topics && 'c' in topics && 'd' in topics && 'e' in topics"
+				Expect(ValidateGCMCondition(condition)).To(Succeed())
+				Expect(ValidateGCMCondition(condition + " && 'f' in topics")).NotTo(Succeed())
+			})
+		})
+	})
+})
@@ -0,0 +1,230 @@
+/*
+ * Copyright (c) 2026 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package extensions
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// InstanceIDBatchSize is the max number of tokens of an Instance ID request
+const InstanceIDBatchSize = 1000
+
+// TopicManagementResult is the result of subscribing or unsubscribing a
+// token, Error is empty if it succeeded
+type TopicManagementResult struct {
+	Token string `json:"token"`
+	Error string `json:"error,omitempty"`
+}
+
+type instanceIDRequest struct {
+	To                 string   `json:"to"`
+	RegistrationTokens []string `json:"registration_tokens"`
+}
+
+type instanceIDResponse struct {
+	Results []struct {
+		Error string `json:"error"`
+	} `json:"results"`
+}
+
+// InstanceIDClient subscribes and unsubscribes tokens to topics with the
+// Instance ID API, using the credentials of a gcm app
+type InstanceIDClient struct {
+	appName    string
+	authorize  func(*http.Request) error
+	host       string
+	httpClient *http.Client
+	Config     *viper.Viper
+	Logger     *log.Logger
+}
+
+// NewInstanceIDClient returns a new InstanceIDClient for the gcm app. Apps
+// using the fcm api are authenticated with their service account and the
+// other ones with their api key
+func NewInstanceIDClient(appName string, config *viper.Viper, logger *log.Logger) (*InstanceIDClient, error) {
+	c := &InstanceIDClient{
+		appName: appName,
+		Config:  config,
+		Logger:  logger,
+	}
+	err := c.configure()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *InstanceIDClient) loadConfigurationDefaults() {
+	c.Config.SetDefault("gcm.iid.host", "https://iid.googleapis.com")
+	c.Config.SetDefault("gcm.iid.timeout", 10000)
+}
+
+func (c *InstanceIDClient) configure() error {
+	c.loadConfigurationDefaults()
+	c.host = strings.TrimSuffix(c.Config.GetString("gcm.iid.host"), "/")
+	c.httpClient = &http.Client{
+		Timeout: time.Duration(c.Config.GetInt("gcm.iid.timeout")) * time.Millisecond,
+	}
+
+	authorize, err := gcmAppAuthorizer(c.appName, c.Config, c.Logger)
+	if err != nil {
+		return err
+	}
+	c.authorize = authorize
+	return nil
+}
+
+// gcmAppAuthorizer returns a function authorizing requests to the google apis
+// with the credentials of the gcm app. Apps using the fcm api are
+// authenticated with their service account and the other ones with their api
+// key
+func gcmAppAuthorizer(appName string, config *viper.Viper, logger *log.Logger) (func(*http.Request) error, error) {
+	prefix := "gcm.certs." + appName
+	switch api := config.GetString(prefix + ".api"); api {
+	case "", GCMAPIXMPP:
+		apiKey := config.GetString(prefix + ".apiKey")
+		if apiKey == "" {
+			return nil, fmt.Errorf("no api key for gcm app %s", appName)
+		}
+		return func(req *http.Request) error {
+			req.Header.Set("Authorization", "key="+apiKey)
+			return nil
+		}, nil
+	case GCMAPIFCM:
+		fcm, err := NewFCMClient(
+			config.GetString(prefix+".serviceAccountPath"),
+			config.GetString(prefix+".projectID"),
+			config,
+			logger,
+			nil,
+		)
+		if err != nil {
+			return nil, err
+		}
+		return func(req *http.Request) error {
+			accessToken, err := fcm.token()
+			if err != nil {
+				return err
+			}
+			req.Header.Set("Authorization", "Bearer "+accessToken)
+			req.Header.Set("access_token_auth", "true")
+			return nil
+		}, nil
+	default:
+		return nil, fmt.Errorf("invalid gcm api for app %s: %s", appName, api)
+	}
+}
+
+// Subscribe subscribes the tokens to the topic
+func (c *InstanceIDClient) Subscribe(topic string, tokens []string) ([]*TopicManagementResult, error) {
+	return c.manage("batchAdd", topic, tokens)
+}
+
+// Unsubscribe unsubscribes the tokens from the topic
+func (c *InstanceIDClient) Unsubscribe(topic string, tokens []string) ([]*TopicManagementResult, error) {
+	return c.manage("batchRemove", topic, tokens)
+}
+
+// manage sends the tokens in batches, returning the results of the batches
+// sent until an error happens
+func (c *InstanceIDClient) manage(action, topic string, tokens []string) ([]*TopicManagementResult, error) {
+	l := c.Logger.WithFields(log.Fields{
+		"method": action,
+		"app":    c.appName,
+		"topic":  topic,
+	})
+	err := ValidateGCMTopic(topic)
+	if err != nil {
+		return nil, err
+	}
+	to := GCMTopicPrefix + strings.TrimPrefix(topic, GCMTopicPrefix)
+
+	results := make([]*TopicManagementResult, 0, len(tokens))
+	for start := 0; start < len(tokens); start += InstanceIDBatchSize {
+		end := start + InstanceIDBatchSize
+		if end > len(tokens) {
+			end = len(tokens)
+		}
+		batchResults, err := c.sendBatch(action, to, tokens[start:end])
+		if err != nil {
+			l.WithError(err).Error("error managing topic subscriptions")
+			return results, err
+		}
+		results = append(results, batchResults...)
+	}
+	l.WithField("tokens", len(tokens)).Debug("managed topic subscriptions")
+	return results, nil
+}
+
+func (c *InstanceIDClient) sendBatch(action, to string, tokens []string) ([]*TopicManagementResult, error) {
+	body, err := json.Marshal(&instanceIDRequest{
+		To:                 to,
+		RegistrationTokens: tokens,
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/iid/v1:%s", c.host, action), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	err = c.authorize(req)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	resBody, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("instance id %s failed with status %d: %s", action, res.StatusCode, string(resBody))
+	}
+	iidRes := &instanceIDResponse{}
+	err = json.Unmarshal(resBody, iidRes)
+	if err != nil {
+		return nil, err
+	}
+	if len(iidRes.Results) != len(tokens) {
+		return nil, fmt.Errorf("instance id %s returned %d results for %d tokens", action, len(iidRes.Results), len(tokens))
+	}
+	results := make([]*TopicManagementResult, len(tokens))
+	for i, token := range tokens {
+		results[i] = &TopicManagementResult{
+			Token: token,
+			Error: iidRes.Results[i].Error,
+		}
+	}
+	return results, nil
+}
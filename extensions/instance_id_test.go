@@ -0,0 +1,162 @@
+/*
+ * Copyright (c) 2026 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package extensions
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/spf13/viper"
+	. "github.com/topfreegames/pusher/testing"
+	"github.com/topfreegames/pusher/util"
+)
+
+var _ = Describe("Instance ID Client", func() {
+	var config *viper.Viper
+	var server *InstanceIDServer
+	var client *InstanceIDClient
+	logger, _ := test.NewNullLogger()
+
+	BeforeEach(func() {
+		var err error
+		config, err = util.NewViperWithConfigFile("../config/test.yaml")
+		Expect(err).NotTo(HaveOccurred())
+		server = NewInstanceIDServer()
+		config.Set("gcm.iid.host", server.URL)
+		client, err = NewInstanceIDClient("game", config, logger)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Describe("[Unit]", func() {
+		Describe("Creating new client", func() {
+			It("should fail if app has no api key", func() {
+				_, err := NewInstanceIDClient("other", config, logger)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal("no api key for gcm app other"))
+			})
+
+			It("should fail if app api is invalid", func() {
+				config.Set("gcm.certs.game.api", "http")
+				_, err := NewInstanceIDClient("game", config, logger)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal("invalid gcm api for app game: http"))
+			})
+		})
+
+		Describe("Managing subscriptions", func() {
+			It("should subscribe and unsubscribe tokens", func() {
+				results, err := client.Subscribe("news", []string{"token1", "token2"})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(results).To(Equal([]*TopicManagementResult{{Token: "token1"}, {Token: "token2"}}))
+				Expect(server.Subscriptions("news")).To(Equal([]string{"token1", "token2"}))
+
+				results, err = client.Unsubscribe("/topics/news", []string{"token1"})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(results).To(HaveLen(1))
+				Expect(server.Subscriptions("news")).To(Equal([]string{"token2"}))
+				Expect(server.Authorizations()).To(ConsistOf("key=game-api-key", "key=game-api-key"))
+			})
+
+			It("should return errors of each token", func() {
+				server.SetTokenError("token2", "NOT_FOUND")
+				results, err := client.Subscribe("news", []string{"token1", "token2"})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(results).To(Equal([]*TopicManagementResult{{Token: "token1"}, {Token: "token2", Error: "NOT_FOUND"}}))
+				Expect(server.Subscriptions("news")).To(Equal([]string{"token1"}))
+			})
+
+			It("should send tokens in batches", func() {
+				tokens := make([]string, InstanceIDBatchSize+1)
+				for i := range tokens {
+					tokens[i] = fmt.Sprintf("token%d", i)
+				}
+				results, err := client.Subscribe("news", tokens)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(results).To(HaveLen(len(tokens)))
+				Expect(results[InstanceIDBatchSize].Token).To(Equal(tokens[InstanceIDBatchSize]))
+				Expect(server.Subscriptions("news")).To(HaveLen(len(tokens)))
+				Expect(server.Authorizations()).To(HaveLen(2))
+			})
+
+			It("should fail if topic is invalid", func() {
+				_, err := client.Subscribe("news br", []string{"token1"})
+				Expect(err).To(HaveOccurred())
+				Expect(server.Authorizations()).To(BeEmpty())
+			})
+
+			It("should fail if request fails", func() {
+				config.Set("gcm.iid.host", server.URL+"/other")
+				client, err := NewInstanceIDClient("game", config, logger)
+				Expect(err).NotTo(HaveOccurred())
+				_, err = client.Subscribe("news", []string{"token1"})
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("instance id batchAdd failed with status 404"))
+			})
+
+			It("should authenticate fcm apps with their service account", func() {
+				key, err := rsa.GenerateKey(rand.Reader, 1024)
+				Expect(err).NotTo(HaveOccurred())
+				tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Write([]byte(`{"access_token":"access-token","expires_in":3600,"token_type":"Bearer"}`))
+				}))
+				defer tokenServer.Close()
+				dir, err := ioutil.TempDir("", "iid")
+				Expect(err).NotTo(HaveOccurred())
+				defer os.RemoveAll(dir)
+				credentialsPath := filepath.Join(dir, "service-account.json")
+				b, err := json.Marshal(map[string]string{
+					"project_id":   "project-id",
+					"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})),
+					"client_email": "pusher@project-id.iam.gserviceaccount.com",
+					"token_uri":    tokenServer.URL,
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(ioutil.WriteFile(credentialsPath, b, 0600)).To(Succeed())
+				config.Set("gcm.certs.game.api", "fcm")
+				config.Set("gcm.certs.game.serviceAccountPath", credentialsPath)
+
+				client, err := NewInstanceIDClient("game", config, logger)
+				Expect(err).NotTo(HaveOccurred())
+				_, err = client.Subscribe("news", []string{"token1"})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(server.Authorizations()).To(Equal([]string{"Bearer access-token"}))
+			})
+		})
+	})
+})
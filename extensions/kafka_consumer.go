@@ -24,7 +24,10 @@ package extensions
 
 import (
 	"fmt"
+	"regexp"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/kafka"
 	raven "github.com/getsentry/raven-go"
@@ -46,6 +49,7 @@ type KafkaConsumer struct {
 	FetchWaitMaxMs                 int
 	messagesReceived               int64
 	msgChan                        chan interfaces.KafkaMessage
+	lanes                          []*interfaces.Lane
 	OffsetResetStrategy            string
 	run                            bool
 	SessionTimeout                 int
@@ -53,6 +57,9 @@ type KafkaConsumer struct {
 	pendingMessagesWG              *sync.WaitGroup
 	HandleAllMessagesBeforeExiting bool
 	stopChannel                    chan struct{}
+	partitionsLock                 sync.Mutex
+	assignedPartitions             []kafka.TopicPartition
+	pausedGames                    map[ParsedTopic]int
 }
 
 // NewKafkaConsumer for creating a new KafkaConsumer instance
@@ -68,6 +75,7 @@ func NewKafkaConsumer(
 		messagesReceived:  0,
 		pendingMessagesWG: nil,
 		stopChannel:       *stopChannel,
+		pausedGames:       map[ParsedTopic]int{},
 	}
 	var client interfaces.KafkaConsumerClient
 	if len(clientOrNil) == 1 {
@@ -90,6 +98,11 @@ func (q *KafkaConsumer) loadConfigurationDefaults() {
 	q.Config.SetDefault("queue.sessionTimeout", 6000)
 	q.Config.SetDefault("queue.offsetResetStrategy", "latest")
 	q.Config.SetDefault("queue.handleAllMessagesBeforeExiting", true)
+	q.Config.SetDefault("queue.lanes", "single,massive")
+	q.Config.SetDefault("queue.lane.single.weight", 10)
+	q.Config.SetDefault("queue.lane.single.topics", "_(apns|gcm)[_-]single")
+	q.Config.SetDefault("queue.lane.massive.weight", 1)
+	q.Config.SetDefault("queue.lane.massive.topics", "_(apns|gcm)[_-]massive")
 }
 
 func (q *KafkaConsumer) configure(client interfaces.KafkaConsumerClient) error {
@@ -106,18 +119,56 @@ func (q *KafkaConsumer) configure(client interfaces.KafkaConsumerClient) error {
 
 	q.msgChan = make(chan interfaces.KafkaMessage, q.ChannelSize)
 
+	err := q.configureLanes()
+	if err != nil {
+		return err
+	}
+
 	if q.HandleAllMessagesBeforeExiting {
 		var wg sync.WaitGroup
 		q.pendingMessagesWG = &wg
 	}
 
-	err := q.configureConsumer(client)
+	err = q.configureConsumer(client)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// configureLanes reads the lanes in queue.lanes, in order, or a single
+// default lane if it is empty
+func (q *KafkaConsumer) configureLanes() error {
+	names := []string{}
+	for _, name := range strings.Split(q.Config.GetString("queue.lanes"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		names = []string{"default"}
+	}
+	q.lanes = make([]*interfaces.Lane, len(names))
+	for i, name := range names {
+		lane := &interfaces.Lane{
+			Name:   name,
+			Weight: q.Config.GetInt(fmt.Sprintf("queue.lane.%s.weight", name)),
+		}
+		if lane.Weight < 1 {
+			lane.Weight = 1
+		}
+		if topics := q.Config.GetString(fmt.Sprintf("queue.lane.%s.topics", name)); topics != "" {
+			r, err := regexp.Compile(topics)
+			if err != nil {
+				return fmt.Errorf("invalid topics of lane %s: %s", name, err.Error())
+			}
+			lane.Topics = r
+		}
+		q.lanes[i] = lane
+	}
+	return nil
+}
+
 func (q *KafkaConsumer) configureConsumer(client interfaces.KafkaConsumerClient) error {
 	l := q.Logger.WithFields(logrus.Fields{
 		"method":                          "configureConsumer",
@@ -174,11 +225,16 @@ func (q *KafkaConsumer) StopConsuming() {
 	q.run = false
 }
 
-// MessagesChannel returns the channel that will receive all messages got from kafka
+// MessagesChannel returns the channel that receives all messages got from kafka
 func (q *KafkaConsumer) MessagesChannel() *chan interfaces.KafkaMessage {
 	return &q.msgChan
 }
 
+// Lanes returns the lanes of the messages got from kafka
+func (q *KafkaConsumer) Lanes() []*interfaces.Lane {
+	return q.lanes
+}
+
 // ConsumeLoop consume messages from the queue and put in messages to send channel
 func (q *KafkaConsumer) ConsumeLoop() error {
 	q.run = true
@@ -236,11 +292,26 @@ func (q *KafkaConsumer) assignPartitions(partitions []kafka.TopicPartition) erro
 	})
 
 	l.Debug("Assigning partitions...")
+	q.partitionsLock.Lock()
+	defer q.partitionsLock.Unlock()
 	err := q.Consumer.Assign(partitions)
 	if err != nil {
 		l.WithError(err).Error("Failed to assign partitions.")
 		return err
 	}
+	q.assignedPartitions = partitions
+	// assigned partitions are fetched again, so paused games must be paused again
+	for paused := range q.pausedGames {
+		if gamePartitions := q.gamePartitions(paused); len(gamePartitions) > 0 {
+			err = q.Consumer.Pause(gamePartitions)
+			if err != nil {
+				l.WithError(err).WithFields(logrus.Fields{
+					"game":     paused.Game,
+					"platform": paused.Platform,
+				}).Error("Failed to pause game partitions.")
+			}
+		}
+	}
 	l.Info("Partitions assigned.")
 	return nil
 }
@@ -251,15 +322,78 @@ func (q *KafkaConsumer) unassignPartitions() error {
 	})
 
 	l.Debug("Unassigning partitions...")
+	q.partitionsLock.Lock()
+	defer q.partitionsLock.Unlock()
 	err := q.Consumer.Unassign()
 	if err != nil {
 		l.WithError(err).Error("Failed to unassign partitions.")
 		return err
 	}
+	q.assignedPartitions = nil
 	l.Info("Partitions unassigned.")
 	return nil
 }
 
+// gamePartitions returns the assigned partitions of the topics of the game
+// and platform, the caller must hold partitionsLock
+func (q *KafkaConsumer) gamePartitions(game ParsedTopic) []kafka.TopicPartition {
+	partitions := []kafka.TopicPartition{}
+	for _, partition := range q.assignedPartitions {
+		if partition.Topic != nil && getGameAndPlatformFromTopic(*partition.Topic) == game {
+			partitions = append(partitions, partition)
+		}
+	}
+	return partitions
+}
+
+// PauseGame stops fetching messages of the game in the platform until
+// ResumeGame is called as many times as PauseGame, since the workers of the
+// handler queue pause it independently
+func (q *KafkaConsumer) PauseGame(game, platform string) error {
+	q.partitionsLock.Lock()
+	defer q.partitionsLock.Unlock()
+	paused := ParsedTopic{Game: game, Platform: platform}
+	q.pausedGames[paused]++
+	if q.pausedGames[paused] > 1 {
+		return nil
+	}
+	partitions := q.gamePartitions(paused)
+	if len(partitions) == 0 {
+		return nil
+	}
+	q.Logger.WithFields(logrus.Fields{
+		"method":   "PauseGame",
+		"game":     game,
+		"platform": platform,
+	}).Info("pausing game partitions")
+	return q.Consumer.Pause(partitions)
+}
+
+// ResumeGame fetches messages of a paused game in the platform again
+func (q *KafkaConsumer) ResumeGame(game, platform string) error {
+	q.partitionsLock.Lock()
+	defer q.partitionsLock.Unlock()
+	paused := ParsedTopic{Game: game, Platform: platform}
+	if q.pausedGames[paused] == 0 {
+		return nil
+	}
+	q.pausedGames[paused]--
+	if q.pausedGames[paused] > 0 {
+		return nil
+	}
+	delete(q.pausedGames, paused)
+	partitions := q.gamePartitions(paused)
+	if len(partitions) == 0 {
+		return nil
+	}
+	q.Logger.WithFields(logrus.Fields{
+		"method":   "ResumeGame",
+		"game":     game,
+		"platform": platform,
+	}).Info("resuming game partitions")
+	return q.Consumer.Resume(partitions)
+}
+
 func (q *KafkaConsumer) receiveMessage(topicPartition kafka.TopicPartition, value []byte) {
 	l := q.Logger.WithFields(logrus.Fields{
 		"method": "receiveMessage",
@@ -276,10 +410,13 @@ func (q *KafkaConsumer) receiveMessage(topicPartition kafka.TopicPartition, valu
 		q.pendingMessagesWG.Add(1)
 	}
 
+	parsedTopic := getGameAndPlatformFromTopic(*topicPartition.Topic)
 	message := interfaces.KafkaMessage{
-		Game:  getGameAndPlatformFromTopic(*topicPartition.Topic).Game,
-		Topic: *topicPartition.Topic,
-		Value: value,
+		Game:       parsedTopic.Game,
+		Platform:   parsedTopic.Platform,
+		Topic:      *topicPartition.Topic,
+		Value:      value,
+		ReceivedAt: time.Now(),
 	}
 
 	q.msgChan <- message
@@ -199,9 +199,15 @@ var _ = Describe("Kafka Extension", func() {
 				consumer.messagesReceived = 999
 
 				publishEvent(event)
-				Eventually(consumer.msgChan, 5).Should(Receive(&interfaces.KafkaMessage{
-					Topic: topic,
-					Value: val,
+				var message interfaces.KafkaMessage
+				Eventually(consumer.msgChan, 5).Should(Receive(&message))
+				Expect(message.ReceivedAt).To(BeTemporally("~", time.Now(), time.Second))
+				message.ReceivedAt = time.Time{}
+				Expect(message).To(Equal(interfaces.KafkaMessage{
+					Game:     "games",
+					Platform: "apns",
+					Topic:    topic,
+					Value:    val,
 				}))
 				Expect(consumer.messagesReceived).To(BeEquivalentTo(1000))
 			})
@@ -250,6 +256,118 @@ var _ = Describe("Kafka Extension", func() {
 			})
 		})
 
+		Describe("Pausing games", func() {
+			gameTopic := "push-game_gcm"
+			otherTopic := "push-other_gcm"
+			gamePartition := kafka.TopicPartition{Topic: &gameTopic, Partition: 0}
+			otherPartition := kafka.TopicPartition{Topic: &otherTopic, Partition: 0}
+			apnsTopic := "push-game_apns"
+			apnsPartition := kafka.TopicPartition{Topic: &apnsTopic, Partition: 0}
+
+			It("should pause and resume the partitions of the game in the platform", func() {
+				Expect(consumer.assignPartitions([]kafka.TopicPartition{gamePartition, otherPartition, apnsPartition})).To(Succeed())
+
+				Expect(consumer.PauseGame("game", "gcm")).To(Succeed())
+				Expect(kafkaConsumerClientMock.PausedPartitions).To(Equal([]kafka.TopicPartition{gamePartition}))
+
+				Expect(consumer.ResumeGame("game", "gcm")).To(Succeed())
+				Expect(kafkaConsumerClientMock.PausedPartitions).To(BeEmpty())
+			})
+
+			It("should pause partitions of paused games when they are assigned", func() {
+				Expect(consumer.PauseGame("game", "gcm")).To(Succeed())
+				Expect(kafkaConsumerClientMock.PausedPartitions).To(BeEmpty())
+
+				Expect(consumer.assignPartitions([]kafka.TopicPartition{gamePartition, otherPartition})).To(Succeed())
+				Expect(kafkaConsumerClientMock.PausedPartitions).To(Equal([]kafka.TopicPartition{gamePartition}))
+			})
+
+			It("should pause and resume each platform of the game independently", func() {
+				Expect(consumer.assignPartitions([]kafka.TopicPartition{gamePartition, apnsPartition})).To(Succeed())
+				Expect(consumer.PauseGame("game", "gcm")).To(Succeed())
+				Expect(consumer.PauseGame("game", "apns")).To(Succeed())
+
+				Expect(consumer.ResumeGame("game", "apns")).To(Succeed())
+				Expect(kafkaConsumerClientMock.PausedPartitions).To(Equal([]kafka.TopicPartition{gamePartition}))
+				Expect(consumer.ResumeGame("game", "gcm")).To(Succeed())
+				Expect(kafkaConsumerClientMock.PausedPartitions).To(BeEmpty())
+			})
+
+			It("should keep the game paused until every pause is resumed", func() {
+				Expect(consumer.assignPartitions([]kafka.TopicPartition{gamePartition})).To(Succeed())
+				Expect(consumer.PauseGame("game", "gcm")).To(Succeed())
+				Expect(consumer.PauseGame("game", "gcm")).To(Succeed())
+
+				Expect(consumer.ResumeGame("game", "gcm")).To(Succeed())
+				Expect(kafkaConsumerClientMock.PausedPartitions).To(Equal([]kafka.TopicPartition{gamePartition}))
+				Expect(consumer.ResumeGame("game", "gcm")).To(Succeed())
+				Expect(kafkaConsumerClientMock.PausedPartitions).To(BeEmpty())
+			})
+
+			It("should not resume games that are not paused", func() {
+				Expect(consumer.assignPartitions([]kafka.TopicPartition{gamePartition})).To(Succeed())
+				kafkaConsumerClientMock.PausedPartitions = []kafka.TopicPartition{gamePartition}
+				Expect(consumer.ResumeGame("game", "gcm")).To(Succeed())
+				Expect(kafkaConsumerClientMock.PausedPartitions).To(HaveLen(1))
+			})
+		})
+
+		Describe("Lanes", func() {
+			var config *viper.Viper
+
+			BeforeEach(func() {
+				config = viper.New()
+				config.Set("queue.lanes", "single, massive")
+				config.Set("queue.lane.single.weight", 10)
+				config.Set("queue.lane.single.topics", "[_-]single$")
+				config.Set("queue.lane.massive.topics", "[_-]massive$")
+			})
+
+			newConsumer := func() *KafkaConsumer {
+				stopChannel := make(chan struct{})
+				c, err := NewKafkaConsumer(config, logger, &stopChannel, kafkaConsumerClientMock)
+				Expect(err).NotTo(HaveOccurred())
+				return c
+			}
+
+			It("should have the single and massive lanes by default", func() {
+				lanes := consumer.Lanes()
+				Expect(lanes).To(HaveLen(2))
+				Expect(lanes[0].Name).To(Equal("single"))
+				Expect(lanes[0].Weight).To(Equal(10))
+				Expect(lanes[0].Topics.MatchString("push-game_gcm_single")).To(BeTrue())
+				Expect(lanes[1].Name).To(Equal("massive"))
+				Expect(lanes[1].Weight).To(Equal(1))
+				Expect(lanes[1].Topics.MatchString("push-game_apns-massive")).To(BeTrue())
+			})
+
+			It("should have a single default lane if queue.lanes is empty", func() {
+				config.Set("queue.lanes", "")
+				lanes := newConsumer().Lanes()
+				Expect(lanes).To(HaveLen(1))
+				Expect(lanes[0].Name).To(Equal("default"))
+				Expect(lanes[0].Weight).To(Equal(1))
+				Expect(lanes[0].Topics).To(BeNil())
+			})
+
+			It("should configure the lanes in order", func() {
+				lanes := newConsumer().Lanes()
+				Expect(lanes).To(HaveLen(2))
+				Expect(lanes[0].Name).To(Equal("single"))
+				Expect(lanes[0].Weight).To(Equal(10))
+				Expect(lanes[0].Topics.String()).To(Equal("[_-]single$"))
+				Expect(lanes[1].Name).To(Equal("massive"))
+				Expect(lanes[1].Weight).To(Equal(1))
+			})
+
+			It("should return an error for invalid topics", func() {
+				config.Set("queue.lane.massive.topics", "(")
+				stopChannel := make(chan struct{})
+				_, err := NewKafkaConsumer(config, logger, &stopChannel, kafkaConsumerClientMock)
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
 		Describe("Configuration Defaults", func() {
 			It("should configure defaults", func() {
 				cnf := viper.New()
@@ -264,6 +382,7 @@ var _ = Describe("Kafka Extension", func() {
 				Expect(cnf.GetInt("queue.sessionTimeout")).To(Equal(6000))
 				Expect(cnf.GetString("queue.offsetResetStrategy")).To(Equal("latest"))
 				Expect(cnf.GetBool("queue.handleAllMessagesBeforeExiting")).To(BeTrue())
+				Expect(cnf.GetString("queue.lanes")).To(Equal("single,massive"))
 			})
 		})
 
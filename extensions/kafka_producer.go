@@ -40,13 +40,20 @@ type KafkaProducer struct {
 	LingerMs  int
 	Logger    *log.Logger
 	Topic     string
+
+	configPrefix string
 }
 
 // NewKafkaProducer for creating a new KafkaProducer instance
 func NewKafkaProducer(config *viper.Viper, logger *log.Logger, clientOrNil ...interfaces.KafkaProducerClient) (*KafkaProducer, error) {
+	return newKafkaProducer("feedback.kafka", config, logger, clientOrNil...)
+}
+
+func newKafkaProducer(configPrefix string, config *viper.Viper, logger *log.Logger, clientOrNil ...interfaces.KafkaProducerClient) (*KafkaProducer, error) {
 	q := &KafkaProducer{
-		Config: config,
-		Logger: logger,
+		Config:       config,
+		Logger:       logger,
+		configPrefix: configPrefix,
 	}
 	var producer interfaces.KafkaProducerClient
 	if len(clientOrNil) == 1 {
@@ -58,17 +65,17 @@ func NewKafkaProducer(config *viper.Viper, logger *log.Logger, clientOrNil ...in
 
 func (q *KafkaProducer) loadConfigurationDefaults() {
 	q.Config.SetDefault("feedback.kafka.topic", "com.games.test.feedbacks")
-	q.Config.SetDefault("feedback.kafka.brokers", "localhost:9941")
-	q.Config.SetDefault("feedback.kafka.linger.ms", 0)
-	q.Config.SetDefault("feedback.kafka.batch.size", 1048576)
+	q.Config.SetDefault(q.configPrefix+".brokers", "localhost:9941")
+	q.Config.SetDefault(q.configPrefix+".linger.ms", 0)
+	q.Config.SetDefault(q.configPrefix+".batch.size", 1048576)
 }
 
 func (q *KafkaProducer) configure(producer interfaces.KafkaProducerClient) error {
 	q.loadConfigurationDefaults()
-	q.Brokers = q.Config.GetString("feedback.kafka.brokers")
-	q.Topic = q.Config.GetString("feedback.kafka.topics")
-	q.BatchSize = q.Config.GetInt("feedback.kafka.batch.size")
-	q.LingerMs = q.Config.GetInt("feedback.kafka.linger.ms")
+	q.Brokers = q.Config.GetString(q.configPrefix + ".brokers")
+	q.Topic = q.Config.GetString(q.configPrefix + ".topics")
+	q.BatchSize = q.Config.GetInt(q.configPrefix + ".batch.size")
+	q.LingerMs = q.Config.GetInt(q.configPrefix + ".linger.ms")
 	c := &kafka.ConfigMap{
 		"queue.buffering.max.kbytes": q.BatchSize,
 		"linger.ms":                  q.LingerMs,
@@ -0,0 +1,73 @@
+/*
+ * Copyright (c) 2026 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package extensions
+
+import (
+	"fmt"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/pusher/interfaces"
+)
+
+// KafkaUpstreamReporter for producing the upstream messages sent by devices to
+// a kafka topic per game
+type KafkaUpstreamReporter struct {
+	*KafkaProducer
+	TopicTemplate string
+}
+
+// NewKafkaUpstreamReporter for creating a new KafkaUpstreamReporter instance
+func NewKafkaUpstreamReporter(config *viper.Viper, logger *log.Logger, clientOrNil ...interfaces.KafkaProducerClient) (*KafkaUpstreamReporter, error) {
+	config.SetDefault("upstream.kafka.topic", "push-%s_%s-upstream")
+	producer, err := newKafkaProducer("upstream.kafka", config, logger, clientOrNil...)
+	if err != nil {
+		return nil, err
+	}
+	return &KafkaUpstreamReporter{
+		KafkaProducer: producer,
+		TopicTemplate: config.GetString("upstream.kafka.topic"),
+	}, nil
+}
+
+// GetTopic returns the topic of the upstream messages of a game
+func (r *KafkaUpstreamReporter) GetTopic(game string, platform string) string {
+	if topic := r.Config.GetString(fmt.Sprintf("upstream.kafka.gameTopics.%s", game)); topic != "" {
+		return topic
+	}
+	return fmt.Sprintf(r.TopicTemplate, game, platform)
+}
+
+// SendUpstreamMessage sends the upstream message to the kafka topic of the game
+func (r *KafkaUpstreamReporter) SendUpstreamMessage(game string, platform string, message []byte) {
+	topic := r.GetTopic(game, platform)
+	m := &kafka.Message{
+		TopicPartition: kafka.TopicPartition{
+			Topic:     &topic,
+			Partition: kafka.PartitionAny,
+		},
+		Value: message,
+	}
+	r.Producer.ProduceChannel() <- m
+}
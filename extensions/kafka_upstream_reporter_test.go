@@ -0,0 +1,69 @@
+/*
+ * Copyright (c) 2026 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package extensions
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/pusher/mocks"
+	"github.com/topfreegames/pusher/util"
+)
+
+var _ = Describe("KafkaUpstreamReporter Extension", func() {
+	var config *viper.Viper
+	var mockProducer *mocks.KafkaProducerClientMock
+	logger, _ := test.NewNullLogger()
+	logger.Level = logrus.DebugLevel
+
+	BeforeEach(func() {
+		var err error
+		config, err = util.NewViperWithConfigFile("../config/test.yaml")
+		Expect(err).NotTo(HaveOccurred())
+		mockProducer = mocks.NewKafkaProducerClientMock()
+	})
+
+	Describe("[Unit]", func() {
+		Describe("Sending upstream message", func() {
+			It("should send message to the game topic", func() {
+				reporter, err := NewKafkaUpstreamReporter(config, logger, mockProducer)
+				Expect(err).NotTo(HaveOccurred())
+				go reporter.SendUpstreamMessage("testgame", "gcm", []byte("test message"))
+
+				msg := <-mockProducer.ProduceChannel()
+				Expect(*msg.TopicPartition.Topic).To(Equal("push-testgame_gcm-upstream"))
+				Expect(msg.Value).To(Equal([]byte("test message")))
+			})
+
+			It("should use the topic configured for the game", func() {
+				config.Set("upstream.kafka.gameTopics.testgame", "testgame-upstream")
+				reporter, err := NewKafkaUpstreamReporter(config, logger, mockProducer)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(reporter.GetTopic("testgame", "gcm")).To(Equal("testgame-upstream"))
+				Expect(reporter.GetTopic("othergame", "gcm")).To(Equal("push-othergame_gcm-upstream"))
+			})
+		})
+	})
+})
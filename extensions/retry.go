@@ -0,0 +1,116 @@
+/*
+ * Copyright (c) 2026 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package extensions
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// RetryPolicy computes exponential backoff delays with jitter for pushes that
+// failed with transient errors
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// NewRetryPolicy returns a RetryPolicy configured from the keys under prefix
+func NewRetryPolicy(config *viper.Viper, prefix string) *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts: config.GetInt(prefix + ".maxAttempts"),
+		BaseDelay:   time.Duration(config.GetInt(prefix+".baseDelay")) * time.Millisecond,
+		MaxDelay:    time.Duration(config.GetInt(prefix+".maxDelay")) * time.Millisecond,
+	}
+}
+
+// CanRetry returns true if a message that was already retried attempts times
+// can be retried again
+func (r *RetryPolicy) CanRetry(attempts int) bool {
+	return attempts < r.MaxAttempts
+}
+
+// Backoff returns how long to wait before the given retry attempt (starting at 1).
+// The delay doubles at each attempt up to MaxDelay and half of it is randomized
+func (r *RetryPolicy) Backoff(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	delay := r.MaxDelay
+	if attempt <= 32 {
+		if d := r.BaseDelay << uint(attempt-1); d > 0 && d < r.MaxDelay {
+			delay = d
+		}
+	}
+	half := int64(delay / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// retryTimers keeps the timers of the pending retries of a handler, so they
+// can be stopped when the handler is cleaned up
+type retryTimers struct {
+	mutex   sync.Mutex
+	timers  map[*time.Timer]struct{}
+	stopped bool
+}
+
+// afterFunc calls f after delay, unless the timers are stopped before it
+func (r *retryTimers) afterFunc(delay time.Duration, f func()) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.stopped {
+		return
+	}
+	if r.timers == nil {
+		r.timers = map[*time.Timer]struct{}{}
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(delay, func() {
+		r.mutex.Lock()
+		delete(r.timers, timer)
+		r.mutex.Unlock()
+		f()
+	})
+	r.timers[timer] = struct{}{}
+}
+
+// pending returns how many retries are waiting for their timers
+func (r *retryTimers) pending() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.timers)
+}
+
+// stop cancels the pending retries and the ones scheduled afterwards
+func (r *retryTimers) stop() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.stopped = true
+	for timer := range r.timers {
+		timer.Stop()
+	}
+	r.timers = nil
+}
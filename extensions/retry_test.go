@@ -0,0 +1,106 @@
+/*
+ * Copyright (c) 2026 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package extensions
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/topfreegames/pusher/util"
+)
+
+var _ = Describe("Retry Policy", func() {
+	configFile := "../config/test.yaml"
+
+	Describe("[Unit]", func() {
+		var policy *RetryPolicy
+
+		BeforeEach(func() {
+			policy = &RetryPolicy{
+				MaxAttempts: 3,
+				BaseDelay:   100 * time.Millisecond,
+				MaxDelay:    1 * time.Second,
+			}
+		})
+
+		Describe("Creating new policy", func() {
+			It("should read values from config", func() {
+				config, err := util.NewViperWithConfigFile(configFile)
+				Expect(err).NotTo(HaveOccurred())
+				config.Set("apns.retry.maxAttempts", 5)
+				config.Set("apns.retry.baseDelay", 200)
+				config.Set("apns.retry.maxDelay", 3000)
+				p := NewRetryPolicy(config, "apns.retry")
+				Expect(p.MaxAttempts).To(Equal(5))
+				Expect(p.BaseDelay).To(Equal(200 * time.Millisecond))
+				Expect(p.MaxDelay).To(Equal(3 * time.Second))
+			})
+		})
+
+		Describe("CanRetry", func() {
+			It("should allow retries until max attempts", func() {
+				Expect(policy.CanRetry(0)).To(BeTrue())
+				Expect(policy.CanRetry(2)).To(BeTrue())
+				Expect(policy.CanRetry(3)).To(BeFalse())
+			})
+		})
+
+		Describe("Backoff", func() {
+			It("should grow exponentially with jitter", func() {
+				for i := 0; i < 100; i++ {
+					Expect(policy.Backoff(1)).To(BeNumerically(">=", 50*time.Millisecond))
+					Expect(policy.Backoff(1)).To(BeNumerically("<=", 100*time.Millisecond))
+					Expect(policy.Backoff(3)).To(BeNumerically(">=", 200*time.Millisecond))
+					Expect(policy.Backoff(3)).To(BeNumerically("<=", 400*time.Millisecond))
+				}
+			})
+
+			It("should not exceed max delay", func() {
+				Expect(policy.Backoff(10)).To(BeNumerically("<=", policy.MaxDelay))
+				Expect(policy.Backoff(100)).To(BeNumerically(">=", policy.MaxDelay/2))
+			})
+		})
+
+		Describe("Retry timers", func() {
+			It("should call the function after the delay", func() {
+				timers := &retryTimers{}
+				called := make(chan bool, 1)
+				timers.afterFunc(10*time.Millisecond, func() { called <- true })
+				Expect(timers.pending()).To(Equal(1))
+				Eventually(called).Should(Receive())
+				Eventually(timers.pending).Should(Equal(0))
+			})
+
+			It("should not call the functions once stopped", func() {
+				timers := &retryTimers{}
+				called := make(chan bool, 2)
+				timers.afterFunc(10*time.Millisecond, func() { called <- true })
+				timers.stop()
+				timers.afterFunc(10*time.Millisecond, func() { called <- true })
+				Expect(timers.pending()).To(Equal(0))
+				Consistently(called, 50*time.Millisecond).ShouldNot(Receive())
+			})
+		})
+	})
+})
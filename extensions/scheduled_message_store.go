@@ -0,0 +1,121 @@
+/*
+ * Copyright (c) 2026 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package extensions
+
+import (
+	"fmt"
+
+	"github.com/spf13/viper"
+	"github.com/topfreegames/pusher/interfaces"
+	pg "gopkg.in/pg.v5"
+)
+
+// ScheduledMessage is a message consumed before its send_at, kept until it
+// is due. SendAt is in milliseconds, as push_expiry
+type ScheduledMessage struct {
+	ID       string
+	Game     string
+	Platform string
+	Topic    string
+	Value    string
+	SendAt   int64
+}
+
+// ScheduledMessageStore keeps the scheduled messages of all games in the
+// table in scheduler.table
+type ScheduledMessageStore struct {
+	Client *PGClient
+	Config *viper.Viper
+	table  string
+}
+
+// NewScheduledMessageStore returns a new ScheduledMessageStore
+func NewScheduledMessageStore(config *viper.Viper, dbOrNil ...interfaces.DB) (*ScheduledMessageStore, error) {
+	s := &ScheduledMessageStore{
+		Config: config,
+	}
+	s.loadConfigurationDefaults()
+	s.table = config.GetString("scheduler.table")
+	var db interfaces.DB
+	if len(dbOrNil) == 1 {
+		db = dbOrNil[0]
+	}
+	client, err := NewPGClient("scheduler.pg", config, db)
+	if err != nil {
+		return nil, err
+	}
+	s.Client = client
+	return s, nil
+}
+
+func (s *ScheduledMessageStore) loadConfigurationDefaults() {
+	s.Config.SetDefault("scheduler.table", "scheduled_messages")
+}
+
+// ClaimDue deletes and returns up to limit messages of the games of the
+// platform that are due at now. Rows locked by another pusher are skipped, so
+// each message is claimed by a single pusher
+func (s *ScheduledMessageStore) ClaimDue(platform string, games []string, now int64, limit int) ([]*ScheduledMessage, error) {
+	var messages []*ScheduledMessage
+	if len(games) == 0 {
+		return messages, nil
+	}
+	_, err := s.Client.DB.Query(&messages, fmt.Sprintf(
+		`DELETE FROM %s WHERE id IN (
+			SELECT id FROM %s WHERE platform = ?0 AND game = ANY(?1) AND send_at <= ?2
+			ORDER BY send_at LIMIT ?3 FOR UPDATE SKIP LOCKED
+		) RETURNING id, game, platform, topic, value, send_at`,
+		s.table, s.table,
+	), platform, pg.Array(games), now, limit)
+	if err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// Save stores the scheduled message
+func (s *ScheduledMessageStore) Save(m *ScheduledMessage) error {
+	_, err := s.Client.DB.Exec(fmt.Sprintf(
+		"INSERT INTO %s (id, game, platform, topic, value, send_at) VALUES (?0, ?1, ?2, ?3, ?4, ?5)",
+		s.table,
+	), m.ID, m.Game, m.Platform, m.Topic, m.Value, m.SendAt)
+	return err
+}
+
+// Claim deletes the scheduled message with the id and returns whether it was
+// still stored, so it wasn't claimed by another pusher
+func (s *ScheduledMessageStore) Claim(id string) (bool, error) {
+	res, err := s.Client.DB.Exec(fmt.Sprintf(
+		"DELETE FROM %s WHERE id = ?0",
+		s.table,
+	), id)
+	if err != nil {
+		return false, err
+	}
+	return res.RowsAffected() > 0, nil
+}
+
+// Cleanup closes the connection to PG
+func (s *ScheduledMessageStore) Cleanup() error {
+	return s.Client.Cleanup()
+}
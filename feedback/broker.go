@@ -26,7 +26,7 @@ import (
 	"encoding/json"
 	"sync"
 
-	gcm "github.com/topfreegames/go-gcm"
+	"github.com/topfreegames/pusher/extensions"
 	"github.com/topfreegames/pusher/interfaces"
 	"github.com/topfreegames/pusher/structs"
 
@@ -59,6 +59,8 @@ type Broker struct {
 	pendingMessagesWG   *sync.WaitGroup
 	InvalidTokenEnabled bool
 	InvalidTokenOutChan chan *InvalidToken
+	TokenUpdateEnabled  bool
+	TokenUpdateOutChan  chan *TokenUpdate
 
 	run         bool
 	stopChannel chan struct{}
@@ -87,6 +89,8 @@ func NewBroker(
 func (b *Broker) loadConfigurationDefaults() {
 	b.Config.SetDefault("feedbackListeners.broker.invalidTokenChan.size", 1000)
 	b.Config.SetDefault("feedbackListeners.broker.invalidTokenEnabled", true)
+	b.Config.SetDefault("feedbackListeners.broker.tokenUpdateChan.size", 1000)
+	b.Config.SetDefault("feedbackListeners.broker.tokenUpdateEnabled", true)
 }
 
 func (b *Broker) configure() {
@@ -94,6 +98,8 @@ func (b *Broker) configure() {
 
 	b.InvalidTokenEnabled = b.Config.GetBool("feedbackListeners.broker.invalidTokenEnabled")
 	b.InvalidTokenOutChan = make(chan *InvalidToken, b.Config.GetInt("feedbackListeners.broker.invalidTokenChan.size"))
+	b.TokenUpdateEnabled = b.Config.GetBool("feedbackListeners.broker.tokenUpdateEnabled")
+	b.TokenUpdateOutChan = make(chan *TokenUpdate, b.Config.GetInt("feedbackListeners.broker.tokenUpdateChan.size"))
 }
 
 // Start starts a routine to process the Broker in channel
@@ -112,6 +118,7 @@ func (b *Broker) Stop() {
 	b.run = false
 	close(b.stopChannel)
 	close(b.InvalidTokenOutChan)
+	close(b.TokenUpdateOutChan)
 }
 
 func (b *Broker) processMessages() {
@@ -133,7 +140,7 @@ func (b *Broker) processMessages() {
 					b.routeAPNSMessage(&res, msg.GetGame())
 
 				case GCMPlatform:
-					var res gcm.CCSMessage
+					var res extensions.CCSMessageWithMetadata
 					err := json.Unmarshal(msg.GetValue(), &res)
 					if err != nil {
 						l.WithError(err).Error(ErrGCMUnmarshal.Error())
@@ -168,7 +175,22 @@ func (b *Broker) routeAPNSMessage(msg *structs.ResponseWithMetadata, game string
 	}
 }
 
-func (b *Broker) routeGCMMessage(msg *gcm.CCSMessage, game string) {
+func (b *Broker) routeGCMMessage(msg *extensions.CCSMessageWithMetadata, game string) {
+	// feedbacks of topic, condition and device group messages are not about a
+	// single token
+	if msg.Metadata["target"] == "topic" || msg.Metadata["target"] == "device_group" || extensions.IsGCMTopic(msg.From) {
+		return
+	}
+
+	if newToken, ok := msg.Metadata["tokenUpdate"].(string); ok && newToken != "" && b.TokenUpdateEnabled {
+		b.TokenUpdateOutChan <- &TokenUpdate{
+			OldToken: msg.From,
+			NewToken: newToken,
+			Game:     game,
+			Platform: GCMPlatform,
+		}
+	}
+
 	switch msg.Error {
 	case "DEVICE_UNREGISTERED", "BAD_REGISTRATION":
 		if b.InvalidTokenEnabled {
@@ -31,6 +31,7 @@ import (
 	"github.com/sideshow/apns2"
 	"github.com/spf13/viper"
 	gcm "github.com/topfreegames/go-gcm"
+	"github.com/topfreegames/pusher/extensions"
 	"github.com/topfreegames/pusher/structs"
 	"github.com/topfreegames/pusher/testing"
 
@@ -199,6 +200,137 @@ var _ = Describe("Broker", func() {
 
 					broker.Stop()
 				})
+
+				It("Should not route feedbacks of device group messages", func() {
+					value, err = json.Marshal(&extensions.CCSMessageWithMetadata{
+						CCSMessage: gcm.CCSMessage{
+							From:  "user1",
+							Error: "BAD_REGISTRATION",
+						},
+						Metadata: map[string]interface{}{"target": "device_group"},
+					})
+					Expect(err).NotTo(HaveOccurred())
+					kafkaMsg = &KafkaMessage{
+						Game:     game,
+						Platform: platform,
+						Value:    value,
+					}
+
+					broker, err := NewBroker(logger, config, nil, inChan, nil)
+					Expect(err).NotTo(HaveOccurred())
+
+					broker.Start()
+					inChan <- kafkaMsg
+
+					Eventually(func() int {
+						return len(broker.InChan)
+					}).Should(Equal(0))
+
+					Consistently(func() int {
+						return len(broker.InvalidTokenOutChan)
+					}).Should(Equal(0))
+
+					broker.Stop()
+				})
+
+				It("Should not route feedbacks of topic messages", func() {
+					value, err = json.Marshal(&extensions.CCSMessageWithMetadata{
+						CCSMessage: gcm.CCSMessage{
+							From:  "'dogs' in topics",
+							Error: "BAD_REGISTRATION",
+						},
+						Metadata: map[string]interface{}{"target": "topic"},
+					})
+					Expect(err).NotTo(HaveOccurred())
+					kafkaMsg = &KafkaMessage{
+						Game:     game,
+						Platform: platform,
+						Value:    value,
+					}
+
+					broker, err := NewBroker(logger, config, nil, inChan, nil)
+					Expect(err).NotTo(HaveOccurred())
+
+					broker.Start()
+					inChan <- kafkaMsg
+
+					Eventually(func() int {
+						return len(broker.InChan)
+					}).Should(Equal(0))
+
+					Consistently(func() int {
+						return len(broker.InvalidTokenOutChan)
+					}).Should(Equal(0))
+
+					broker.Stop()
+				})
+			})
+
+			Describe("Token Update", func() {
+				game := "boomforce"
+				platform := "gcm"
+				var kafkaMsg QueueMessage
+
+				BeforeEach(func() {
+					value, err := json.Marshal(&extensions.CCSMessageWithMetadata{
+						CCSMessage: gcm.CCSMessage{
+							From:           "oldToken",
+							MessageType:    "ack",
+							RegistrationID: "newToken",
+						},
+						Metadata: map[string]interface{}{
+							"tokenUpdate": "newToken",
+						},
+					})
+					Expect(err).NotTo(HaveOccurred())
+
+					kafkaMsg = &KafkaMessage{
+						Game:     game,
+						Platform: platform,
+						Value:    value,
+					}
+				})
+
+				It("Should route a token update feedback from GCM", func() {
+					broker, err := NewBroker(logger, config, nil, inChan, nil)
+					Expect(err).NotTo(HaveOccurred())
+
+					broker.Start()
+
+					inChan <- kafkaMsg
+					tk := <-broker.TokenUpdateOutChan
+
+					expTk := &TokenUpdate{
+						OldToken: "oldToken",
+						NewToken: "newToken",
+						Game:     game,
+						Platform: platform,
+					}
+					Expect(tk).To(Equal(expTk))
+					Expect(len(broker.InvalidTokenOutChan)).To(Equal(0))
+
+					broker.Stop()
+				})
+
+				It("Should not route if token update is disabled", func() {
+					config.Set("feedbackListeners.broker.tokenUpdateEnabled", false)
+
+					broker, err := NewBroker(logger, config, nil, inChan, nil)
+					Expect(err).NotTo(HaveOccurred())
+
+					broker.Start()
+					inChan <- kafkaMsg
+
+					Eventually(func() int {
+						return len(broker.InChan)
+					}).Should(Equal(0))
+
+					Consistently(func() int {
+						return len(broker.TokenUpdateOutChan)
+					}).Should(Equal(0))
+
+					broker.Stop()
+				})
 			})
 		})
 	})
@@ -46,6 +46,7 @@ type Listener struct {
 	Queue                   Queue
 	Broker                  *Broker
 	InvalidTokenHandler     *InvalidTokenHandler
+	TokenUpdateHandler      *TokenUpdateHandler
 	GracefulShutdownTimeout int
 
 	run         bool
@@ -105,6 +106,12 @@ func (l *Listener) configure(statsdClientrOrNil interfaces.StatsDClient) error {
 	}
 	l.InvalidTokenHandler = handler
 
+	tokenUpdateHandler, err := NewTokenUpdateHandler(l.Logger, l.Config, l.StatsReporters, l.Broker.TokenUpdateOutChan)
+	if err != nil {
+		return fmt.Errorf("error creating new token update handler: %s", err.Error())
+	}
+	l.TokenUpdateHandler = tokenUpdateHandler
+
 	return nil
 }
 
@@ -130,6 +137,7 @@ func (l *Listener) Start() {
 	go l.Queue.ConsumeLoop()
 	l.Broker.Start()
 	l.InvalidTokenHandler.Start()
+	l.TokenUpdateHandler.Start()
 
 	statsReporterReportMetricCount(l.StatsReporters,
 		"feedback_listener_restart", 1, "", "")
@@ -165,6 +173,10 @@ func (l *Listener) flushStats() {
 		"broker_invalid_token_channel", float64(len(l.Broker.InvalidTokenOutChan)), "", "")
 	statsReporterReportMetricGauge(l.StatsReporters,
 		"invalid_token_handler_buffer", float64(len(l.InvalidTokenHandler.Buffer)), "", "")
+	statsReporterReportMetricGauge(l.StatsReporters,
+		"broker_token_update_channel", float64(len(l.Broker.TokenUpdateOutChan)), "", "")
+	statsReporterReportMetricGauge(l.StatsReporters,
+		"token_update_handler_buffer", float64(len(l.TokenUpdateHandler.Buffer)), "", "")
 }
 
 // Cleanup ends the Listener execution
@@ -175,6 +187,7 @@ func (l *Listener) Cleanup() {
 	l.Queue.Cleanup()
 	l.Broker.Stop()
 	l.InvalidTokenHandler.Stop()
+	l.TokenUpdateHandler.Stop()
 	l.gracefulShutdown(l.Queue.PendingMessagesWaitGroup(), time.Duration(l.GracefulShutdownTimeout)*time.Second)
 }
 
@@ -0,0 +1,280 @@
+/*
+ * Copyright (c) 2019 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package feedback
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	raven "github.com/getsentry/raven-go"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/pusher/extensions"
+	"github.com/topfreegames/pusher/interfaces"
+	"github.com/topfreegames/pusher/util"
+)
+
+// Metrics name sent by the TokenUpdateHandler
+const (
+	MetricsTokensUpdateSuccess     = "tokens_update_success"
+	MetricsTokensUpdateError       = "tokens_update_error"
+	MetricsTokensUpdateNonexistent = "tokens_update_nonexistent"
+)
+
+// TokenUpdate represents a token that was replaced by a new one, e.g. a GCM
+// canonical registration ID
+type TokenUpdate struct {
+	OldToken string
+	NewToken string
+	Game     string
+	Platform string
+}
+
+// TokenUpdateHandler takes the TokenUpdates from the InChannel and put them in a buffer.
+// When the buffer is full or after a timeout, it is flushed, replacing the old
+// tokens by the new ones in the database
+type TokenUpdateHandler struct {
+	Logger        *log.Logger
+	Config        *viper.Viper
+	StatsReporter []interfaces.StatsReporter
+	Client        *extensions.PGClient
+
+	flushTime time.Duration
+
+	InChan     chan *TokenUpdate
+	Buffer     []*TokenUpdate
+	bufferSize int
+
+	run      bool
+	stopChan chan bool
+}
+
+// NewTokenUpdateHandler returns a new TokenUpdateHandler instance
+func NewTokenUpdateHandler(
+	logger *log.Logger, cfg *viper.Viper, statsReporter []interfaces.StatsReporter,
+	inChan chan *TokenUpdate,
+	dbOrNil ...interfaces.DB,
+) (*TokenUpdateHandler, error) {
+	h := &TokenUpdateHandler{
+		Logger:        logger,
+		Config:        cfg,
+		StatsReporter: statsReporter,
+		InChan:        inChan,
+		stopChan:      make(chan bool),
+	}
+
+	var db interfaces.DB
+	if len(dbOrNil) == 1 {
+		db = dbOrNil[0]
+	}
+
+	err := h.configure(db)
+	if err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+func (t *TokenUpdateHandler) loadConfigurationDefaults() {
+	t.Config.SetDefault("feedbackListeners.tokenUpdate.flush.time.ms", 5000)
+	t.Config.SetDefault("feedbackListeners.tokenUpdate.buffer.size", 1000)
+}
+
+func (t *TokenUpdateHandler) configure(db interfaces.DB) error {
+	l := t.Logger.WithField("method", "configure")
+	t.loadConfigurationDefaults()
+
+	t.flushTime = time.Duration(t.Config.GetInt("feedbackListeners.tokenUpdate.flush.time.ms")) * time.Millisecond
+	t.bufferSize = t.Config.GetInt("feedbackListeners.tokenUpdate.buffer.size")
+
+	t.Buffer = make([]*TokenUpdate, 0, t.bufferSize)
+
+	var err error
+	t.Client, err = extensions.NewPGClient("feedbackListeners.tokenUpdate.pg", t.Config, db)
+	if err != nil {
+		l.WithError(err).Error("failed to configure psql database")
+		return err
+	}
+
+	l.Info("psql database configured")
+	return nil
+}
+
+// Start starts to process the TokenUpdates from the intake channel
+func (t *TokenUpdateHandler) Start() {
+	l := t.Logger.WithField(
+		"method", "start",
+	)
+	l.Info("starting token update handler")
+
+	t.run = true
+	go t.processMessages()
+}
+
+// Stop stops the Handler from consuming messages from the intake channel
+func (t *TokenUpdateHandler) Stop() {
+	t.run = false
+	close(t.stopChan)
+}
+
+func (t *TokenUpdateHandler) processMessages() {
+	l := t.Logger.WithFields(log.Fields{
+		"method": "processMessages",
+	})
+
+	flushTicker := time.NewTicker(t.flushTime)
+	defer flushTicker.Stop()
+
+	for t.run {
+		select {
+		case tk, ok := <-t.InChan:
+			if ok {
+				t.Buffer = append(t.Buffer, tk)
+
+				if len(t.Buffer) >= t.bufferSize {
+					l.Debug("buffer is full")
+					t.updateTokens(t.Buffer)
+					t.Buffer = make([]*TokenUpdate, 0, t.bufferSize)
+				}
+			}
+
+		case <-flushTicker.C:
+			l.Debug("flush ticker")
+			t.updateTokens(t.Buffer)
+			t.Buffer = make([]*TokenUpdate, 0, t.bufferSize)
+
+		case <-t.stopChan:
+			break
+		}
+	}
+
+	l.Info("stop processing Token Update Handler's in channel")
+}
+
+// updateTokens groups updates by game and platform and replaces the old tokens
+// in the database. An UPDATE query is fetched for each pair <game, platform>.
+// If a token is updated more than once, only its last update is applied
+func (t *TokenUpdateHandler) updateTokens(updates []*TokenUpdate) {
+	m := map[string]map[string][]*TokenUpdate{}
+	latest := map[string]*TokenUpdate{}
+	for _, u := range updates {
+		key := u.Platform + ":" + u.Game + ":" + u.OldToken
+		if _, ok := latest[key]; !ok {
+			if m[u.Platform] == nil {
+				m[u.Platform] = map[string][]*TokenUpdate{}
+			}
+			m[u.Platform][u.Game] = append(m[u.Platform][u.Game], u)
+		}
+		latest[key] = u
+	}
+
+	for platform, games := range m {
+		for game, us := range games {
+			for j, u := range us {
+				us[j] = latest[platform+":"+game+":"+u.OldToken]
+			}
+			t.updateTokensFromGame(us, game, platform)
+		}
+	}
+}
+
+func (t *TokenUpdateHandler) updateTokensFromGame(updates []*TokenUpdate, game, platform string) error {
+	l := t.Logger.WithFields(log.Fields{
+		"method":   "updateTokensFromGame",
+		"game":     game,
+		"platform": platform,
+	})
+
+	// the old rows are deleted instead of updated when the new token is already
+	// in the table, so a device is never kept twice. As the statements of the
+	// query see the table as it was before it, only the row of one of the old
+	// tokens replaced by the same new token is updated, and the rows of the
+	// others are deleted. The query returns a row for each token deleted or
+	// updated
+	table := game + "_" + platform
+	var queryBuild strings.Builder
+	params := make([]interface{}, 0, 2*len(updates))
+	queryBuild.WriteString("WITH v(old_token, new_token) AS (VALUES ")
+	for j, u := range updates {
+		queryBuild.WriteString(fmt.Sprintf("(?%d, ?%d)", 2*j, 2*j+1))
+		if j < len(updates)-1 {
+			queryBuild.WriteString(", ")
+		}
+		params = append(params, u.OldToken, u.NewToken)
+	}
+	queryBuild.WriteString(fmt.Sprintf(
+		"), kept AS (SELECT DISTINCT ON (v.new_token) v.old_token, v.new_token FROM v"+
+			" JOIN %[1]s AS t ON t.token = v.old_token ORDER BY v.new_token, v.old_token),"+
+			" replaced AS (DELETE FROM %[1]s AS t USING v WHERE t.token = v.old_token"+
+			" AND (EXISTS (SELECT 1 FROM %[1]s AS e WHERE e.token = v.new_token)"+
+			" OR NOT EXISTS (SELECT 1 FROM kept AS k WHERE k.old_token = v.old_token)) RETURNING t.token),"+
+			" updated AS (UPDATE %[1]s AS t SET token = k.new_token FROM kept AS k WHERE t.token = k.old_token"+
+			" AND NOT EXISTS (SELECT 1 FROM %[1]s AS e WHERE e.token = k.new_token) RETURNING t.token)"+
+			" SELECT token FROM replaced UNION ALL SELECT token FROM updated;",
+		table,
+	))
+	query := queryBuild.String()
+
+	l.Debug("updating tokens")
+	res, err := t.Client.DB.Exec(query, params...)
+	if err != nil && err.Error() != "pg: no rows in result set" {
+		raven.CaptureError(err, map[string]string{
+			"version": util.Version,
+			"handler": "tokenUpdate",
+		})
+
+		l.WithError(err).Error("error updating tokens")
+		statsReporterReportMetricCount(t.StatsReporter,
+			MetricsTokensUpdateError, int64(len(updates)), game, platform)
+
+		return err
+	}
+
+	if err != nil && err.Error() == "pg: no rows in result set" {
+		statsReporterReportMetricCount(t.StatsReporter,
+			MetricsTokensUpdateNonexistent, int64(len(updates)),
+			game, platform)
+
+		return nil
+	}
+
+	// a token can be in more than one row, so it is only known that some of
+	// them were not found if less rows than updates were affected
+	if res.RowsAffected() < len(updates) {
+		statsReporterReportMetricCount(t.StatsReporter,
+			MetricsTokensUpdateNonexistent, int64(len(updates)-res.RowsAffected()),
+			game, platform)
+
+		statsReporterReportMetricCount(t.StatsReporter,
+			MetricsTokensUpdateSuccess, int64(res.RowsAffected()), game, platform)
+
+		return nil
+	}
+
+	statsReporterReportMetricCount(t.StatsReporter,
+		MetricsTokensUpdateSuccess, int64(len(updates)), game, platform)
+
+	return nil
+}
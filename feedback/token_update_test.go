@@ -0,0 +1,235 @@
+/*
+ * Copyright (c) 2019 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package feedback
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/pusher/extensions"
+	"github.com/topfreegames/pusher/interfaces"
+	"github.com/topfreegames/pusher/mocks"
+	"github.com/topfreegames/pusher/testing"
+	"github.com/topfreegames/pusher/util"
+)
+
+func updateQuery(table, values string) string {
+	return "WITH v(old_token, new_token) AS (VALUES " + values + "), " +
+		"kept AS (SELECT DISTINCT ON (v.new_token) v.old_token, v.new_token FROM v JOIN " + table + " AS t ON t.token = v.old_token ORDER BY v.new_token, v.old_token), " +
+		"replaced AS (DELETE FROM " + table + " AS t USING v WHERE t.token = v.old_token AND (EXISTS (SELECT 1 FROM " + table + " AS e WHERE e.token = v.new_token) OR NOT EXISTS (SELECT 1 FROM kept AS k WHERE k.old_token = v.old_token)) RETURNING t.token), " +
+		"updated AS (UPDATE " + table + " AS t SET token = k.new_token FROM kept AS k WHERE t.token = k.old_token AND NOT EXISTS (SELECT 1 FROM " + table + " AS e WHERE e.token = k.new_token) RETURNING t.token) " +
+		"SELECT token FROM replaced UNION ALL SELECT token FROM updated;"
+}
+
+var _ = Describe("TokenUpdate Handler", func() {
+	var config *viper.Viper
+	var mockStatsDClient *mocks.StatsDClientMock
+	var statsReporters []interfaces.StatsReporter
+	var logger *logrus.Logger
+	var hook *test.Hook
+	var mockClient *mocks.PGMock
+	var inChan chan *TokenUpdate
+	var err error
+
+	configFile := "../config/test.yaml"
+
+	BeforeEach(func() {
+		config, err = util.NewViperWithConfigFile(configFile)
+		Expect(err).NotTo(HaveOccurred())
+
+		logger, hook = test.NewNullLogger()
+		logger.Level = logrus.DebugLevel
+		mockClient = mocks.NewPGMock(0, 1)
+		inChan = make(chan *TokenUpdate, 100)
+
+		mockStatsDClient = mocks.NewStatsDClientMock()
+		c, err := extensions.NewStatsD(config, logger, mockStatsDClient)
+		Expect(err).NotTo(HaveOccurred())
+		statsReporters = []interfaces.StatsReporter{c}
+	})
+
+	Describe("[Unit]", func() {
+		Describe("Creating new TokenUpdateHandler", func() {
+			It("Should return a new handler", func() {
+				handler, err := NewTokenUpdateHandler(logger, config, statsReporters, inChan, mockClient)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(handler).NotTo(BeNil())
+				Expect(handler.bufferSize).To(Equal(99))
+			})
+		})
+
+		Describe("Flush and Buffer", func() {
+			It("Should flush because reached flush timeout", func() {
+				config.Set("feedbackListeners.tokenUpdate.flush.time.ms", 1)
+
+				handler, err := NewTokenUpdateHandler(logger, config, statsReporters, inChan, mockClient)
+				Expect(err).NotTo(HaveOccurred())
+
+				mockClient.RowsAffected = 1
+				mockClient.RowsReturned = 0
+				handler.Start()
+				inChan <- &TokenUpdate{OldToken: "old", NewToken: "new", Game: "boomforce", Platform: "gcm"}
+
+				Eventually(func() []*logrus.Entry { return hook.Entries }).
+					Should(testing.ContainLogMessage("flush ticker"))
+
+				Eventually(func() int64 {
+					return mockStatsDClient.Counts[MetricsTokensUpdateSuccess]
+				}).Should(BeEquivalentTo(1))
+				handler.Stop()
+			})
+		})
+
+		Describe("Updating database", func() {
+			It("Should create correct queries", func() {
+				config.Set("feedbackListeners.tokenUpdate.flush.time.ms", 10000)
+				config.Set("feedbackListeners.tokenUpdate.buffer.size", 4)
+
+				handler, err := NewTokenUpdateHandler(logger, config, statsReporters, inChan, mockClient)
+				Expect(err).NotTo(HaveOccurred())
+
+				handler.Start()
+				updates := []*TokenUpdate{
+					&TokenUpdate{OldToken: "AAAAAAAAAA", NewToken: "aaaaaaaaaa", Game: "boomforce", Platform: "gcm"},
+					&TokenUpdate{OldToken: "BBBBBBBBBB", NewToken: "bbbbbbbbbb", Game: "boomforce", Platform: "gcm"},
+					&TokenUpdate{OldToken: "CCCCCCCCCC", NewToken: "cccccccccc", Game: "sniper", Platform: "gcm"},
+					&TokenUpdate{OldToken: "AAAAAAAAAA", NewToken: "zzzzzzzzzz", Game: "boomforce", Platform: "gcm"},
+				}
+				for _, u := range updates {
+					inChan <- u
+				}
+
+				expResults := []struct {
+					Query  string
+					Params []interface{}
+				}{
+					{
+						Query:  updateQuery("boomforce_gcm", "(?0, ?1), (?2, ?3)"),
+						Params: []interface{}{"AAAAAAAAAA", "zzzzzzzzzz", "BBBBBBBBBB", "bbbbbbbbbb"},
+					},
+					{
+						Query:  updateQuery("sniper_gcm", "(?0, ?1)"),
+						Params: []interface{}{"CCCCCCCCCC", "cccccccccc"},
+					},
+				}
+
+				for _, res := range expResults {
+					Eventually(func() interface{} {
+						for _, exec := range mockClient.Execs[1:] {
+							if exec[0].(string) == res.Query {
+								return exec[1]
+							}
+						}
+						return nil
+					}).Should(Equal(res.Params))
+				}
+				handler.Stop()
+			})
+
+			It("should delete the old token if the new one already exists", func() {
+				config.Set("feedbackListeners.tokenUpdate.buffer.size", 1)
+
+				handler, err := NewTokenUpdateHandler(logger, config, statsReporters, inChan, mockClient)
+				Expect(err).NotTo(HaveOccurred())
+				mockClient.RowsAffected = 1
+
+				handler.Start()
+				inChan <- &TokenUpdate{OldToken: "old", NewToken: "new", Game: "sniper", Platform: "gcm"}
+
+				Eventually(func() int { return len(mockClient.Execs) }).Should(BeNumerically(">", 1))
+				query := mockClient.Execs[len(mockClient.Execs)-1][0].(string)
+				Expect(query).To(ContainSubstring("replaced AS (DELETE FROM sniper_gcm AS t USING v WHERE t.token = v.old_token AND (EXISTS (SELECT 1 FROM sniper_gcm AS e WHERE e.token = v.new_token)"))
+				Expect(query).To(ContainSubstring("AND NOT EXISTS (SELECT 1 FROM sniper_gcm AS e WHERE e.token = k.new_token)"))
+				Eventually(func() int64 {
+					return mockStatsDClient.Counts[MetricsTokensUpdateSuccess]
+				}).Should(BeEquivalentTo(1))
+				handler.Stop()
+			})
+
+			It("should update a single row of the old tokens replaced by the same new token", func() {
+				config.Set("feedbackListeners.tokenUpdate.buffer.size", 2)
+
+				handler, err := NewTokenUpdateHandler(logger, config, statsReporters, inChan, mockClient)
+				Expect(err).NotTo(HaveOccurred())
+				mockClient.RowsAffected = 2
+
+				handler.Start()
+				inChan <- &TokenUpdate{OldToken: "old1", NewToken: "new", Game: "sniper", Platform: "gcm"}
+				inChan <- &TokenUpdate{OldToken: "old2", NewToken: "new", Game: "sniper", Platform: "gcm"}
+
+				Eventually(func() int { return len(mockClient.Execs) }).Should(BeNumerically(">", 1))
+				exec := mockClient.Execs[len(mockClient.Execs)-1]
+				Expect(exec[0]).To(Equal(updateQuery("sniper_gcm", "(?0, ?1), (?2, ?3)")))
+				Expect(exec[1]).To(Equal([]interface{}{"old1", "new", "old2", "new"}))
+				// only one old token per new token is updated, the rows of the others are deleted
+				Expect(exec[0]).To(ContainSubstring("kept AS (SELECT DISTINCT ON (v.new_token)"))
+				Expect(exec[0]).To(ContainSubstring("OR NOT EXISTS (SELECT 1 FROM kept AS k WHERE k.old_token = v.old_token)"))
+				Expect(exec[0]).To(ContainSubstring("SET token = k.new_token FROM kept AS k"))
+				Eventually(func() int64 {
+					return mockStatsDClient.Counts[MetricsTokensUpdateSuccess]
+				}).Should(BeEquivalentTo(2))
+				handler.Stop()
+			})
+
+			It("should report nonexistent tokens", func() {
+				config.Set("feedbackListeners.tokenUpdate.buffer.size", 1)
+
+				handler, err := NewTokenUpdateHandler(logger, config, statsReporters, inChan, mockClient)
+				Expect(err).NotTo(HaveOccurred())
+				mockClient.Error = fmt.Errorf("pg: no rows in result set")
+
+				handler.Start()
+				inChan <- &TokenUpdate{OldToken: "old", NewToken: "new", Game: "sniper", Platform: "gcm"}
+
+				Eventually(func() int64 {
+					return mockStatsDClient.Counts[MetricsTokensUpdateNonexistent]
+				}).Should(BeEquivalentTo(1))
+				Expect(hook.Entries).NotTo(testing.ContainLogMessage("error updating tokens"))
+				handler.Stop()
+			})
+
+			It("should not break if a pg error occurred", func() {
+				config.Set("feedbackListeners.tokenUpdate.buffer.size", 1)
+
+				handler, err := NewTokenUpdateHandler(logger, config, statsReporters, inChan, mockClient)
+				Expect(err).NotTo(HaveOccurred())
+				mockClient.Error = fmt.Errorf("pg: error")
+
+				handler.Start()
+				inChan <- &TokenUpdate{OldToken: "old", NewToken: "new", Game: "sniper", Platform: "gcm"}
+
+				Eventually(func() []*logrus.Entry {
+					return hook.Entries
+				}).Should(testing.ContainLogMessage("error updating tokens"))
+				Eventually(func() int64 {
+					return mockStatsDClient.Counts[MetricsTokensUpdateError]
+				}).Should(BeEquivalentTo(1))
+				handler.Stop()
+			})
+		})
+	})
+})
@@ -31,6 +31,7 @@ import (
 type APNSPushQueue interface {
 	ResponseChannel() chan *structs.ResponseWithMetadata
 	Configure() error
-	Push(*apns2.Notification)
+	Push(*apns2.Notification) error
+	ConnectionStats() []*structs.APNSConnectionStats
 	Close()
 }
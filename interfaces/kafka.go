@@ -36,5 +36,7 @@ type KafkaConsumerClient interface {
 	Events() chan kafka.Event
 	Assign([]kafka.TopicPartition) error
 	Unassign() error
+	Pause([]kafka.TopicPartition) error
+	Resume([]kafka.TopicPartition) error
 	Close() error
 }
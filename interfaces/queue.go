@@ -22,19 +22,37 @@
 
 package interfaces
 
-import "sync"
+import (
+	"regexp"
+	"sync"
+	"time"
+)
 
 // KafkaMessage sent through the Channel
 type KafkaMessage struct {
-	Game  string
-	Topic string
-	Value []byte
+	Game       string
+	Platform   string
+	Topic      string
+	Value      []byte
+	ReceivedAt time.Time
+}
+
+// Lane is a priority class of the messages, named by their metadata.lane or
+// matching their topic. The messages waiting for a handler are taken from
+// the lanes in proportion to their weights
+type Lane struct {
+	Name   string
+	Weight int
+	Topics *regexp.Regexp
 }
 
 // Queue interface for making new queues pluggable easily
 type Queue interface {
 	MessagesChannel() *chan KafkaMessage
+	Lanes() []*Lane
 	ConsumeLoop() error
 	StopConsuming()
 	PendingMessagesWaitGroup() *sync.WaitGroup
+	PauseGame(game, platform string) error
+	ResumeGame(game, platform string) error
 }
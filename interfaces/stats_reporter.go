@@ -22,15 +22,21 @@
 
 package interfaces
 
-import "github.com/topfreegames/pusher/errors"
+import (
+	"time"
 
-// StatsReporter interface for making stats reporters pluggable easily
+	"github.com/topfreegames/pusher/errors"
+)
+
+// StatsReporter interface for making stats reporters pluggable easily.
+// Optional tags are reported along with game and platform, in key:value format
 type StatsReporter interface {
 	InitializeFailure(game string, platform string)
-	HandleNotificationSent(game string, platform string)
-	HandleNotificationSuccess(game string, platform string)
-	HandleNotificationFailure(game string, platform string, err *errors.PushError)
+	HandleNotificationSent(game string, platform string, tags ...string)
+	HandleNotificationSuccess(game string, platform string, tags ...string)
+	HandleNotificationFailure(game string, platform string, err *errors.PushError, tags ...string)
 	ReportGoStats(numGoRoutines int, allocatedAndNotFreed, heapObjects, nextGCBytes, pauseGCNano uint64)
-	ReportMetricGauge(metric string, value float64, game string, platform string)
-	ReportMetricCount(metric string, value int64, game string, platform string)
+	ReportMetricGauge(metric string, value float64, game string, platform string, tags ...string)
+	ReportMetricCount(metric string, value int64, game string, platform string, tags ...string)
+	ReportMetricTiming(metric string, value time.Duration, game string, platform string, tags ...string)
 }
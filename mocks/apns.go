@@ -23,14 +23,20 @@
 package mocks
 
 import (
+	"fmt"
+	"sync"
+
 	"github.com/sideshow/apns2"
 	"github.com/topfreegames/pusher/structs"
 )
 
 //APNSPushQueueMock should be used for tests that need to send pushs to APNS
 type APNSPushQueueMock struct {
-	responseChannel chan *structs.ResponseWithMetadata
-	Closed          bool
+	responseChannel     chan *structs.ResponseWithMetadata
+	pushedNotifications []*apns2.Notification
+	mutex               sync.Mutex
+	Closed              bool
+	Connections         []*structs.APNSConnectionStats
 }
 
 //NewAPNSPushQueueMock creates a new instance
@@ -40,14 +46,33 @@ func NewAPNSPushQueueMock() *APNSPushQueueMock {
 	}
 }
 
-//Push records the sent message in the MessagesSent collection
-func (m *APNSPushQueueMock) Push(*apns2.Notification) {
+//Push records the sent message in the PushedNotifications collection
+func (m *APNSPushQueueMock) Push(n *apns2.Notification) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.Closed {
+		return fmt.Errorf("push queue mock is closed")
+	}
+	m.pushedNotifications = append(m.pushedNotifications, n)
+	return nil
+}
+
+//PushedNotifications returns the notifications pushed so far
+func (m *APNSPushQueueMock) PushedNotifications() []*apns2.Notification {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]*apns2.Notification{}, m.pushedNotifications...)
 }
 
 func (m *APNSPushQueueMock) Configure() error {
 	return nil
 }
 
+//ConnectionStats returns the Connections set by the test
+func (m *APNSPushQueueMock) ConnectionStats() []*structs.APNSConnectionStats {
+	return m.Connections
+}
+
 //ResponseChannel returns responseChannel
 func (m *APNSPushQueueMock) ResponseChannel() chan *structs.ResponseWithMetadata {
 	return m.ResponseChannel()
@@ -55,6 +80,11 @@ func (m *APNSPushQueueMock) ResponseChannel() chan *structs.ResponseWithMetadata
 
 //Close records that it is closed
 func (m *APNSPushQueueMock) Close() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.Closed {
+		return
+	}
 	close(m.responseChannel)
 	m.Closed = true
 }
@@ -31,6 +31,7 @@ import (
 type GCMClientMock struct {
 	MessagesSent []gcm.XMPPMessage
 	Closed       bool
+	SendError    error
 }
 
 //NewGCMClientMock creates a new instance
@@ -41,8 +42,11 @@ func NewGCMClientMock() *GCMClientMock {
 	}
 }
 
-//SendXMPP records the sent message in the MessagesSent collection
+//SendXMPP records the sent message in the MessagesSent collection or fails with SendError
 func (m *GCMClientMock) SendXMPP(msg gcm.XMPPMessage) (string, int, error) {
+	if m.SendError != nil {
+		return "", 0, m.SendError
+	}
 	m.MessagesSent = append(m.MessagesSent, msg)
 	return uuid.NewV4().String(), 0, nil
 }
@@ -76,6 +76,7 @@ type KafkaConsumerClientMock struct {
 	SubscribedTopics   map[string]interface{}
 	EventsChan         chan kafka.Event
 	AssignedPartitions []kafka.TopicPartition
+	PausedPartitions   []kafka.TopicPartition
 	Closed             bool
 	Error              error
 }
@@ -130,6 +131,36 @@ func (k *KafkaConsumerClientMock) Unassign() error {
 	return nil
 }
 
+//Pause mock
+func (k *KafkaConsumerClientMock) Pause(partitions []kafka.TopicPartition) error {
+	if k.Error != nil {
+		return k.Error
+	}
+	k.PausedPartitions = append(k.PausedPartitions, partitions...)
+	return nil
+}
+
+//Resume mock
+func (k *KafkaConsumerClientMock) Resume(partitions []kafka.TopicPartition) error {
+	if k.Error != nil {
+		return k.Error
+	}
+	paused := []kafka.TopicPartition{}
+	for _, p := range k.PausedPartitions {
+		resumed := false
+		for _, r := range partitions {
+			if *p.Topic == *r.Topic && p.Partition == r.Partition {
+				resumed = true
+			}
+		}
+		if !resumed {
+			paused = append(paused, p)
+		}
+	}
+	k.PausedPartitions = paused
+	return nil
+}
+
 //Close mock
 func (k *KafkaConsumerClientMock) Close() error {
 	if k.Error != nil {
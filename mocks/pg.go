@@ -37,6 +37,8 @@ type PGMock struct {
 	RowsAffected int
 	RowsReturned int
 	Error        error
+	// QueryModel, if set, fills the model of the queries
+	QueryModel func(model interface{})
 }
 
 //NewPGMock creates a new instance
@@ -109,6 +111,10 @@ func (m *PGMock) Query(obj interface{}, query interface{}, params ...interface{}
 		return nil, m.Error
 	}
 
+	if m.QueryModel != nil {
+		m.QueryModel(obj)
+	}
+
 	result := m.getResult()
 	return result, nil
 }
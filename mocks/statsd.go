@@ -32,6 +32,7 @@ type StatsDClientMock struct {
 	Counts  map[string]int64
 	Gauges  map[string]interface{}
 	Timings map[string]interface{}
+	Tags    map[string][]string
 	Closed  bool
 }
 
@@ -42,15 +43,17 @@ func NewStatsDClientMock() *StatsDClientMock {
 		Counts:  map[string]int64{},
 		Gauges:  map[string]interface{}{},
 		Timings: map[string]interface{}{},
+		Tags:    map[string][]string{},
 	}
 }
 
-var mutexCount, mutexGauges, mutexTimings sync.Mutex
+var mutexCount, mutexGauges, mutexTimings, mutexTags sync.Mutex
 
 //Incr stores the new count in a map
 func (m *StatsDClientMock) Incr(bucket string, tags []string, rate float64) error {
 	mutexCount.Lock()
 	m.Counts[bucket]++
+	m.setTags(bucket, tags)
 	mutexCount.Unlock()
 	return nil
 }
@@ -59,6 +62,7 @@ func (m *StatsDClientMock) Incr(bucket string, tags []string, rate float64) erro
 func (m *StatsDClientMock) Count(bucket string, value int64, tags []string, rate float64) error {
 	mutexCount.Lock()
 	m.Counts[bucket] += value
+	m.setTags(bucket, tags)
 	mutexCount.Unlock()
 	return nil
 }
@@ -67,6 +71,7 @@ func (m *StatsDClientMock) Count(bucket string, value int64, tags []string, rate
 func (m *StatsDClientMock) Gauge(bucket string, value float64, tags []string, rate float64) error {
 	mutexGauges.Lock()
 	m.Gauges[bucket] = value
+	m.setTags(bucket, tags)
 	mutexGauges.Unlock()
 	return nil
 }
@@ -75,10 +80,17 @@ func (m *StatsDClientMock) Gauge(bucket string, value float64, tags []string, ra
 func (m *StatsDClientMock) Timing(bucket string, value time.Duration, tags []string, rate float64) error {
 	mutexTimings.Lock()
 	m.Timings[bucket] = value
+	m.setTags(bucket, tags)
 	mutexTimings.Unlock()
 	return nil
 }
 
+func (m *StatsDClientMock) setTags(bucket string, tags []string) {
+	mutexTags.Lock()
+	m.Tags[bucket] = tags
+	mutexTags.Unlock()
+}
+
 //Close records that it is closed
 func (m *StatsDClientMock) Close() error {
 	m.Closed = true
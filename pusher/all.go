@@ -0,0 +1,74 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package pusher
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/pusher/interfaces"
+)
+
+// AllPusher struct for a pusher sending to both APNS and GCM, with a single
+// queue and the same reporters
+type AllPusher struct {
+	Pusher
+}
+
+// NewAllPusher for getting a new AllPusher instance
+func NewAllPusher(
+	isProduction bool,
+	config *viper.Viper,
+	logger *logrus.Logger,
+	statsdClientOrNil interfaces.StatsDClient,
+	db interfaces.DB,
+	gcmClientOrNil ...interfaces.GCMClient,
+) (*AllPusher, error) {
+	a := &AllPusher{
+		Pusher: Pusher{
+			Config:       config,
+			IsProduction: isProduction,
+			Logger:       logger,
+			stopChannel:  make(chan struct{}),
+		},
+	}
+	var client interfaces.GCMClient
+	if len(gcmClientOrNil) > 0 {
+		client = gcmClientOrNil[0]
+	}
+	err := a.configure(client, db, statsdClientOrNil)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *AllPusher) configure(client interfaces.GCMClient, db interfaces.DB, statsdClientOrNil interfaces.StatsDClient) error {
+	if err := a.configureCommon(statsdClientOrNil, db); err != nil {
+		return err
+	}
+	if err := a.configureUpstreamReporters(); err != nil {
+		return err
+	}
+	a.gcmClient = client
+	return a.configureRegistry(db, APNSPlatform, GCMPlatform)
+}
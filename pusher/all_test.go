@@ -0,0 +1,309 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package pusher
+
+import (
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/pusher/interfaces"
+	"github.com/topfreegames/pusher/mocks"
+	. "github.com/topfreegames/pusher/testing"
+	"github.com/topfreegames/pusher/util"
+)
+
+var _ = Describe("All Pusher", func() {
+	var config *viper.Viper
+	configFile := "../config/test.yaml"
+	isProduction := false
+	logger, hook := test.NewNullLogger()
+
+	BeforeEach(func() {
+		var err error
+		config, err = util.NewViperWithConfigFile(configFile)
+		Expect(err).NotTo(HaveOccurred())
+		hook.Reset()
+	})
+
+	Describe("[Unit]", func() {
+		var mockDb *mocks.PGMock
+		var mockStatsDClient *mocks.StatsDClientMock
+
+		BeforeEach(func() {
+			mockStatsDClient = mocks.NewStatsDClientMock()
+			mockDb = mocks.NewPGMock(0, 1)
+			hook.Reset()
+		})
+
+		Describe("Creating new all pusher", func() {
+			It("should return configured pusher with handlers of both platforms", func() {
+				pusher, err := NewAllPusher(
+					isProduction,
+					config,
+					logger,
+					mockStatsDClient,
+					mockDb,
+					mocks.NewGCMClientMock(),
+				)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(pusher).NotTo(BeNil())
+				Expect(pusher.IsProduction).To(Equal(isProduction))
+				Expect(pusher.Queue).NotTo(BeNil())
+				Expect(pusher.StatsReporters).To(HaveLen(1))
+				Expect(pusher.MessageHandlers).To(HaveLen(2))
+				Expect(pusher.MessageHandlers[APNSPlatform]).To(HaveKey("game"))
+				Expect(pusher.MessageHandlers[GCMPlatform]).To(HaveKey("game"))
+			})
+
+			It("should start with the apps of a single platform", func() {
+				config.Set("apns.apps", "invalidgame")
+				pusher, err := NewAllPusher(
+					isProduction,
+					config,
+					logger,
+					mockStatsDClient,
+					mockDb,
+					mocks.NewGCMClientMock(),
+				)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(pusher.MessageHandlers[APNSPlatform]).To(BeEmpty())
+				Expect(pusher.MessageHandlers[GCMPlatform]).To(HaveLen(1))
+			})
+
+			It("should require the scheduler to defer messages during quiet hours", func() {
+				config.Set("quietHours.games.game.start", "22:00")
+				config.Set("quietHours.games.game.end", "08:00")
+				config.Set("quietHours.games.game.tz", "-0300")
+				_, err := NewAllPusher(
+					isProduction,
+					config,
+					logger,
+					mockStatsDClient,
+					mockDb,
+					mocks.NewGCMClientMock(),
+				)
+				Expect(err).To(HaveOccurred())
+
+				config.Set("scheduler.enabled", true)
+				_, err = NewAllPusher(
+					isProduction,
+					config,
+					logger,
+					mockStatsDClient,
+					mockDb,
+					mocks.NewGCMClientMock(),
+				)
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
+
+		Describe("Serving games", func() {
+			It("should return the games with a handler by platform", func() {
+				pusher, err := NewAllPusher(
+					isProduction,
+					config,
+					logger,
+					mockStatsDClient,
+					mockDb,
+					mocks.NewGCMClientMock(),
+				)
+				Expect(err).NotTo(HaveOccurred())
+				pusher.MessageHandlers = map[string]map[string]interfaces.MessageHandler{
+					APNSPlatform: {"game": &blockingMessageHandler{}},
+					GCMPlatform:  {"game": &blockingMessageHandler{}, "other": &blockingMessageHandler{}},
+				}
+
+				served := pusher.servedGames()
+				Expect(served).To(HaveLen(2))
+				Expect(served[APNSPlatform]).To(Equal([]string{"game"}))
+				Expect(served[GCMPlatform]).To(ConsistOf("game", "other"))
+			})
+		})
+
+		Describe("Routing messages", func() {
+			var pusher *AllPusher
+			var apnsHandler, gcmHandler *blockingMessageHandler
+			var messages chan interfaces.KafkaMessage
+
+			BeforeEach(func() {
+				var err error
+				pusher, err = NewAllPusher(
+					isProduction,
+					config,
+					logger,
+					mockStatsDClient,
+					mockDb,
+					mocks.NewGCMClientMock(),
+				)
+				Expect(err).NotTo(HaveOccurred())
+				apnsHandler = &blockingMessageHandler{release: make(chan struct{})}
+				gcmHandler = &blockingMessageHandler{release: make(chan struct{})}
+				close(apnsHandler.release)
+				close(gcmHandler.release)
+				pusher.MessageHandlers = map[string]map[string]interfaces.MessageHandler{
+					APNSPlatform: {"game": apnsHandler},
+					GCMPlatform:  {"game": gcmHandler},
+				}
+				pusher.configureHandlerQueues()
+				messages = make(chan interfaces.KafkaMessage)
+			})
+
+			JustBeforeEach(func() {
+				pusher.run = true
+				go pusher.routeMessages(&messages)
+			})
+
+			AfterEach(func() {
+				// unblocks routeMessages with a message it drops, so it stops
+				pusher.run = false
+				pusher.Queue.PendingMessagesWaitGroup().Add(1)
+				messages <- interfaces.KafkaMessage{}
+			})
+
+			It("should send the messages to the handler of their game and platform", func() {
+				messages <- interfaces.KafkaMessage{Game: "game", Platform: APNSPlatform, Topic: "push-game_apns"}
+				messages <- interfaces.KafkaMessage{Game: "game", Platform: GCMPlatform, Topic: "push-game_gcm"}
+				messages <- interfaces.KafkaMessage{Game: "game", Platform: GCMPlatform, Topic: "push-game_gcm"}
+
+				Eventually(apnsHandler.handledCount).Should(Equal(1))
+				Eventually(gcmHandler.handledCount).Should(Equal(2))
+				Expect(apnsHandler.handled[0].Topic).To(Equal("push-game_apns"))
+			})
+
+			It("should drop messages of unknown platforms", func() {
+				pusher.Queue.PendingMessagesWaitGroup().Add(1)
+				messages <- interfaces.KafkaMessage{Game: "game", Platform: "other", Topic: "push-game_other"}
+
+				Eventually(func() []*logrus.Entry { return hook.Entries }).Should(ContainLogMessage("Game not found"))
+				done := make(chan struct{})
+				go func() {
+					pusher.Queue.PendingMessagesWaitGroup().Wait()
+					close(done)
+				}()
+				Eventually(done, time.Second).Should(BeClosed())
+				Expect(apnsHandler.handledCount()).To(Equal(0))
+				Expect(gcmHandler.handledCount()).To(Equal(0))
+			})
+
+			Describe("with the scheduler", func() {
+				BeforeEach(func() {
+					Expect(pusher.configureScheduler(mockDb)).To(Succeed())
+				})
+
+				It("should schedule messages with a future send_at", func() {
+					sendAt := time.Now().Add(time.Hour).UnixNano() / int64(time.Millisecond)
+					pusher.Queue.PendingMessagesWaitGroup().Add(1)
+					messages <- interfaces.KafkaMessage{
+						Game:     "game",
+						Platform: GCMPlatform,
+						Topic:    "push-game_gcm",
+						Value:    []byte(fmt.Sprintf(`{"to": "token", "send_at": %d}`, sendAt)),
+					}
+
+					Eventually(pusher.scheduler.size).Should(Equal(1))
+					Expect(gcmHandler.handledCount()).To(Equal(0))
+				})
+
+				It("should send messages with a past send_at", func() {
+					messages <- interfaces.KafkaMessage{
+						Game:     "game",
+						Platform: GCMPlatform,
+						Topic:    "push-game_gcm",
+						Value:    []byte(`{"to": "token", "send_at": 1}`),
+					}
+
+					Eventually(gcmHandler.handledCount).Should(Equal(1))
+					Expect(pusher.scheduler.size()).To(BeZero())
+				})
+			})
+
+			Describe("with quiet hours", func() {
+				setQuietHours := func() {
+					rule, err := pusher.quietHoursRule("game")
+					Expect(err).NotTo(HaveOccurred())
+					pusher.quietHours.set("game", rule)
+				}
+
+				BeforeEach(func() {
+					// every message of game is in quiet hours
+					config.Set("quietHours.games.game.start", "00:00")
+					config.Set("quietHours.games.game.end", "23:59")
+					config.Set("quietHours.games.game.tz", "UTC")
+					Expect(pusher.configureScheduler(mockDb)).To(Succeed())
+					setQuietHours()
+				})
+
+				It("should defer messages to the end of quiet hours", func() {
+					pusher.Queue.PendingMessagesWaitGroup().Add(1)
+					messages <- interfaces.KafkaMessage{
+						Game:     "game",
+						Platform: APNSPlatform,
+						Topic:    "push-game_apns",
+						Value:    []byte(`{"DeviceToken": "token"}`),
+					}
+
+					Eventually(pusher.scheduler.size).Should(Equal(1))
+					Expect(apnsHandler.handledCount()).To(Equal(0))
+				})
+
+				Describe("dropping messages", func() {
+					BeforeEach(func() {
+						config.Set("quietHours.games.game.action", "drop")
+						setQuietHours()
+					})
+
+					It("should drop messages during quiet hours", func() {
+						pusher.Queue.PendingMessagesWaitGroup().Add(1)
+						messages <- interfaces.KafkaMessage{
+							Game:     "game",
+							Platform: APNSPlatform,
+							Topic:    "push-game_apns",
+							Value:    []byte(`{"DeviceToken": "token"}`),
+						}
+
+						Eventually(func() int64 { return mockStatsDClient.Counts["ignored"] }).Should(Equal(int64(1)))
+						Expect(mockStatsDClient.Tags["ignored"]).To(ContainElement("reason:quiet-hours"))
+						Expect(pusher.scheduler.size()).To(BeZero())
+						Expect(apnsHandler.handledCount()).To(Equal(0))
+					})
+				})
+
+				It("should send critical messages", func() {
+					messages <- interfaces.KafkaMessage{
+						Game:     "game",
+						Platform: APNSPlatform,
+						Topic:    "push-game_apns",
+						Value:    []byte(`{"DeviceToken": "token", "metadata": {"priority": "critical"}}`),
+					}
+
+					Eventually(apnsHandler.handledCount).Should(Equal(1))
+				})
+			})
+		})
+	})
+})
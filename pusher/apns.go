@@ -23,9 +23,6 @@
 package pusher
 
 import (
-	"errors"
-	"strings"
-
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 	"github.com/topfreegames/pusher/extensions"
@@ -66,67 +63,53 @@ func NewAPNSPusher(
 }
 
 func (a *APNSPusher) configure(queue interfaces.APNSPushQueue, db interfaces.DB, statsdClientOrNil interfaces.StatsDClient) error {
-	var err error
-	l := a.Logger.WithFields(logrus.Fields{
-		"method": "configure",
-	})
-	a.loadConfigurationDefaults()
-	a.GracefulShutdownTimeout = a.Config.GetInt("gracefulShutdownTimeout")
-	if err = a.configureStatsReporters(statsdClientOrNil); err != nil {
-		return err
-	}
-	if err = a.configureFeedbackReporters(); err != nil {
+	if err := a.configureCommon(statsdClientOrNil, db); err != nil {
 		return err
 	}
+	return a.configureRegistry(db, APNSPlatform)
+}
 
-	q, err := extensions.NewKafkaConsumer(
-		a.Config,
-		a.Logger,
-		&a.stopChannel,
-	)
-	if err != nil {
-		return err
-	}
-	a.MessageHandler = make(map[string]interfaces.MessageHandler)
-	a.Queue = q
-	l.Info("Configuring messageHandler")
-	for _, k := range strings.Split(a.Config.GetString("apns.apps"), ",") {
-		authKeyPath := a.Config.GetString("apns.certs." + k + ".authKeyPath")
-		keyID := a.Config.GetString("apns.certs." + k + ".keyID")
-		teamID := a.Config.GetString("apns.certs." + k + ".teamID")
-		topic := a.Config.GetString("apns.certs." + k + ".topic")
+// newAPNSHandler creates the message handler of the apns app of the game
+func (p *Pusher) newAPNSHandler(game string, config *viper.Viper) (interfaces.MessageHandler, error) {
+	l := p.Logger.WithFields(logrus.Fields{
+		"method": "newAPNSHandler",
+		"game":   game,
+	})
+	authKeyPath := config.GetString("apns.certs." + game + ".authKeyPath")
+	keyID := config.GetString("apns.certs." + game + ".keyID")
+	teamID := config.GetString("apns.certs." + game + ".teamID")
+	topic := config.GetString("apns.certs." + game + ".topic")
+	if config.GetString("apns.certs."+game+".authType") == extensions.APNSAuthTypeCertificate {
 		l.Infof(
-			"Configuring messageHandler for game %s with key: %s",
-			k, authKeyPath,
+			"Configuring messageHandler for game %s with certificate: %s",
+			game, config.GetString("apns.certs."+game+".certificatePath"),
 		)
-		handler, err := extensions.NewAPNSMessageHandler(
-			authKeyPath,
-			keyID,
-			teamID,
-			topic,
-			k,
-			a.IsProduction,
-			a.Config,
-			a.Logger,
-			a.Queue.PendingMessagesWaitGroup(),
-			a.StatsReporters,
-			a.feedbackReporters,
-			nil,
+	} else {
+		l.Infof(
+			"Configuring messageHandler for game %s with key: %s",
+			game, authKeyPath,
 		)
-		if err == nil {
-			a.MessageHandler[k] = handler
-		} else {
-			for _, statsReporter := range a.StatsReporters {
-				statsReporter.InitializeFailure(k, "apns")
-			}
-			l.WithError(err).WithFields(logrus.Fields{
-				"method": "apns",
-				"game":   k,
-			}).Error("failed to initialize apns handler")
-		}
 	}
-	if len(a.MessageHandler) == 0 {
-		return errors.New("Could not initilize any app")
+	handler, err := extensions.NewAPNSMessageHandler(
+		authKeyPath,
+		keyID,
+		teamID,
+		topic,
+		game,
+		p.IsProduction,
+		config,
+		p.Logger,
+		p.Queue.PendingMessagesWaitGroup(),
+		p.StatsReporters,
+		p.feedbackReporters,
+		nil,
+	)
+	if err != nil {
+		for _, statsReporter := range p.StatsReporters {
+			statsReporter.InitializeFailure(game, APNSPlatform)
+		}
+		l.WithError(err).Error("failed to initialize apns handler")
+		return nil, err
 	}
-	return nil
+	return handler, nil
 }
@@ -29,7 +29,9 @@ import (
 	. "github.com/onsi/gomega"
 	"github.com/sirupsen/logrus/hooks/test"
 	"github.com/spf13/viper"
+	"github.com/topfreegames/pusher/interfaces"
 	"github.com/topfreegames/pusher/mocks"
+	. "github.com/topfreegames/pusher/testing"
 	"github.com/topfreegames/pusher/util"
 )
 
@@ -74,10 +76,10 @@ var _ = Describe("APNS Pusher", func() {
 				Expect(pusher.run).To(BeFalse())
 				Expect(pusher.Queue).NotTo(BeNil())
 				Expect(pusher.Config).NotTo(BeNil())
-				Expect(pusher.MessageHandler).NotTo(BeNil())
+				Expect(pusher.handlers(APNSPlatform)).NotTo(BeNil())
 
 				Expect(pusher.StatsReporters).To(HaveLen(1))
-				Expect(pusher.MessageHandler).To(HaveLen(1))
+				Expect(pusher.handlers(APNSPlatform)).To(HaveLen(1))
 			})
 		})
 
@@ -92,7 +94,7 @@ var _ = Describe("APNS Pusher", func() {
 					mockPushQueue,
 				)
 				Expect(err).NotTo(HaveOccurred())
-				Expect(len(pusher.MessageHandler)).To(Equal(1))
+				Expect(len(pusher.handlers(APNSPlatform))).To(Equal(1))
 				Expect(pusher).NotTo(BeNil())
 				defer func() { pusher.run = false }()
 				go pusher.Start()
@@ -115,9 +117,60 @@ var _ = Describe("APNS Pusher", func() {
 					mockPushQueue,
 				)
 				Expect(err).NotTo(HaveOccurred())
-				Expect(pusher.MessageHandler).To(HaveLen(1))
+				Expect(pusher.handlers(APNSPlatform)).To(HaveLen(1))
 			})
 		})
 
+		Describe("Sending to fake APNS server", func() {
+			var server *APNSServer
+
+			BeforeEach(func() {
+				var err error
+				server, err = NewAPNSServer("../tls/self_signed_cert.pem")
+				Expect(err).NotTo(HaveOccurred())
+				config.Set("apns.host", server.URL)
+				config.Set("apns.insecureSkipVerify", true)
+				config.Set("feedback.reporters", []string{})
+			})
+
+			AfterEach(func() {
+				server.Close()
+			})
+
+			It("should send pushes and handle responses end to end", func() {
+				server.ScriptResponses("bad-token", &APNSResponse{StatusCode: 400, Reason: "BadDeviceToken"})
+				pusher, err := NewAPNSPusher(
+					isProduction,
+					config,
+					logger,
+					mockStatsDClient,
+					mockDb,
+					mockPushQueue,
+				)
+				Expect(err).NotTo(HaveOccurred())
+				handler := pusher.handlers(APNSPlatform)["game"]
+				go handler.HandleResponses()
+				pusher.Queue.PendingMessagesWaitGroup().Add(2)
+
+				handler.HandleMessages(interfaces.KafkaMessage{
+					Game:  "game",
+					Topic: "push-game_apns",
+					Value: []byte(`{"DeviceToken":"token","Payload":{"aps":{"alert":"Hello"}}}`),
+				})
+				handler.HandleMessages(interfaces.KafkaMessage{
+					Game:  "game",
+					Topic: "push-game_apns",
+					Value: []byte(`{"DeviceToken":"bad-token","Payload":{"aps":{"alert":"Hello"}}}`),
+				})
+
+				Eventually(func() int64 { return mockStatsDClient.Counts["ack"] }, 5*time.Second).Should(Equal(int64(1)))
+				Eventually(func() int64 { return mockStatsDClient.Counts["failed"] }, 5*time.Second).Should(Equal(int64(1)))
+				Expect(mockStatsDClient.Counts["sent"]).To(Equal(int64(2)))
+
+				requests := server.Requests()
+				Expect(requests).To(HaveLen(2))
+				Expect(string(requests[0].Payload)).To(Equal(`{"aps":{"alert":"Hello"}}`))
+			})
+		})
 	})
 })
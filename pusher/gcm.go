@@ -23,9 +23,6 @@
 package pusher
 
 import (
-	"errors"
-	"strings"
-
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 	"github.com/topfreegames/pusher/extensions"
@@ -66,60 +63,47 @@ func NewGCMPusher(
 }
 
 func (g *GCMPusher) configure(client interfaces.GCMClient, db interfaces.DB, statsdClientOrNil interfaces.StatsDClient) error {
-	var err error
-	l := g.Logger.WithFields(logrus.Fields{
-		"method": "configure",
-	})
-	g.loadConfigurationDefaults()
-	g.GracefulShutdownTimeout = g.Config.GetInt("gracefulShutdownTimeout")
-	if err = g.configureStatsReporters(statsdClientOrNil); err != nil {
+	if err := g.configureCommon(statsdClientOrNil, db); err != nil {
 		return err
 	}
-	if err = g.configureFeedbackReporters(); err != nil {
+	if err := g.configureUpstreamReporters(); err != nil {
 		return err
 	}
-	q, err := extensions.NewKafkaConsumer(
-		g.Config,
-		g.Logger,
-		&g.stopChannel,
+	g.gcmClient = client
+	return g.configureRegistry(db, GCMPlatform)
+}
+
+// newGCMHandler creates the message handler of the gcm app of the game
+func (p *Pusher) newGCMHandler(game string, config *viper.Viper) (interfaces.MessageHandler, error) {
+	l := p.Logger.WithFields(logrus.Fields{
+		"method": "newGCMHandler",
+		"game":   game,
+	})
+	senderID := config.GetString("gcm.certs." + game + ".senderID")
+	apiKey := config.GetString("gcm.certs." + game + ".apiKey")
+	l.Infof(
+		"Configuring messageHandler for game %s with senderID %s and apiKey %s",
+		game, senderID, apiKey,
+	)
+	handler, err := extensions.NewGCMMessageHandler(
+		senderID,
+		apiKey,
+		game,
+		p.IsProduction,
+		config,
+		p.Logger,
+		p.Queue.PendingMessagesWaitGroup(),
+		p.StatsReporters,
+		p.feedbackReporters,
+		p.upstreamReporters,
+		p.gcmClient,
 	)
 	if err != nil {
-		return err
-	}
-	g.Queue = q
-	g.MessageHandler = make(map[string]interfaces.MessageHandler)
-	for _, k := range strings.Split(g.Config.GetString("gcm.apps"), ",") {
-		senderID := g.Config.GetString("gcm.certs." + k + ".senderID")
-		apiKey := g.Config.GetString("gcm.certs." + k + ".apiKey")
-		l.Infof(
-			"Configuring messageHandler for game %s with senderID %s and apiKey %s",
-			k, senderID, apiKey,
-		)
-		handler, err := extensions.NewGCMMessageHandler(
-			senderID,
-			apiKey,
-			g.IsProduction,
-			g.Config,
-			g.Logger,
-			g.Queue.PendingMessagesWaitGroup(),
-			g.StatsReporters,
-			g.feedbackReporters,
-			client,
-		)
-		if err == nil {
-			g.MessageHandler[k] = handler
-		} else {
-			for _, statsReporter := range g.StatsReporters {
-				statsReporter.InitializeFailure(k, "gcm")
-			}
-			l.WithError(err).WithFields(logrus.Fields{
-				"method": "gcm",
-				"game":   k,
-			}).Error("failed to initialize gcm handler")
+		for _, statsReporter := range p.StatsReporters {
+			statsReporter.InitializeFailure(game, GCMPlatform)
 		}
+		l.WithError(err).Error("failed to initialize gcm handler")
+		return nil, err
 	}
-	if len(g.MessageHandler) == 0 {
-		return errors.New("Could not initilize any app")
-	}
-	return nil
+	return handler, nil
 }
@@ -29,7 +29,10 @@ import (
 	. "github.com/onsi/gomega"
 	"github.com/sirupsen/logrus/hooks/test"
 	"github.com/spf13/viper"
+	"github.com/topfreegames/pusher/extensions"
+	"github.com/topfreegames/pusher/interfaces"
 	"github.com/topfreegames/pusher/mocks"
+	. "github.com/topfreegames/pusher/testing"
 	"github.com/topfreegames/pusher/util"
 )
 
@@ -71,11 +74,11 @@ var _ = Describe("GCM Pusher", func() {
 				Expect(pusher).NotTo(BeNil())
 				Expect(pusher.Config).NotTo(BeNil())
 				Expect(pusher.IsProduction).To(Equal(isProduction))
-				Expect(pusher.MessageHandler).NotTo(BeNil())
+				Expect(pusher.handlers(GCMPlatform)).NotTo(BeNil())
 				Expect(pusher.Queue).NotTo(BeNil())
 				Expect(pusher.run).To(BeFalse())
 				Expect(pusher.StatsReporters).To(HaveLen(1))
-				Expect(pusher.MessageHandler).To(HaveLen(1))
+				Expect(pusher.handlers(GCMPlatform)).To(HaveLen(1))
 			})
 		})
 
@@ -97,5 +100,59 @@ var _ = Describe("GCM Pusher", func() {
 				time.Sleep(50 * time.Millisecond)
 			})
 		})
+
+		Describe("Sending to fake CCS server", func() {
+			var server *GCMServer
+			var unroute func()
+
+			BeforeEach(func() {
+				var err error
+				server, err = NewGCMServer("../tls/self_signed_cert.pem", "1233456789", "game-api-key")
+				Expect(err).NotTo(HaveOccurred())
+				unroute = server.Route()
+				config.Set("feedback.reporters", []string{})
+			})
+
+			AfterEach(func() {
+				unroute()
+				server.Close()
+			})
+
+			It("should send pushes and handle responses end to end", func() {
+				server.ScriptResponses("bad-token", &GCMResponse{Error: "DEVICE_UNREGISTERED"})
+				pusher, err := NewGCMPusher(
+					isProduction,
+					config,
+					logger,
+					mockStatsDClient,
+					mockDb,
+				)
+				Expect(err).NotTo(HaveOccurred())
+				handler := pusher.handlers(GCMPlatform)["game"]
+				defer handler.(*extensions.GCMMessageHandler).GCMClient.Close()
+				go handler.HandleResponses()
+				pusher.Queue.PendingMessagesWaitGroup().Add(2)
+
+				handler.HandleMessages(interfaces.KafkaMessage{
+					Game:  "game",
+					Topic: "push-game_gcm",
+					Value: []byte(`{"to":"token","data":{"title":"Hello"}}`),
+				})
+				handler.HandleMessages(interfaces.KafkaMessage{
+					Game:  "game",
+					Topic: "push-game_gcm",
+					Value: []byte(`{"to":"bad-token","data":{"title":"Hello"}}`),
+				})
+
+				Eventually(func() int64 { return mockStatsDClient.Counts["ack"] }, 5*time.Second).Should(Equal(int64(1)))
+				Eventually(func() int64 { return mockStatsDClient.Counts["failed"] }, 5*time.Second).Should(Equal(int64(1)))
+				Expect(mockStatsDClient.Counts["sent"]).To(Equal(int64(2)))
+
+				messages := server.Messages()
+				Expect(messages).To(HaveLen(2))
+				Expect(messages[0].To).To(Equal("token"))
+				Expect(messages[0].Payload["data"]).To(Equal(map[string]interface{}{"title": "Hello"}))
+			})
+		})
 	})
 })
@@ -0,0 +1,255 @@
+/*
+ * Copyright (c) 2026 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package pusher
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/topfreegames/pusher/interfaces"
+)
+
+// handlerQueue feeds the messages of a game to its message handler through
+// queues by lane read by workers, so a slow handler does not block the other
+// games and a backlog in a lane does not delay the others. When more than
+// size messages are waiting, the consumption of the game is paused until they
+// are handled. Workers wait for the rate limits before handling each message,
+// pausing the consumption meanwhile
+type handlerQueue struct {
+	game           string
+	platform       string
+	handler        interfaces.MessageHandler
+	lanes          *laneQueues
+	capacity       int
+	full           bool
+	stopped        bool
+	mutex          sync.Mutex
+	cond           *sync.Cond
+	workers        sync.WaitGroup
+	queue          interfaces.Queue
+	limiter        *rateLimiter
+	statsReporters []interfaces.StatsReporter
+	logger         *logrus.Logger
+}
+
+func newHandlerQueue(
+	game, platform string,
+	handler interfaces.MessageHandler,
+	size int,
+	lanes []*interfaces.Lane,
+	queue interfaces.Queue,
+	limiter *rateLimiter,
+	statsReporters []interfaces.StatsReporter,
+	logger *logrus.Logger,
+) *handlerQueue {
+	q := &handlerQueue{
+		game:           game,
+		platform:       platform,
+		handler:        handler,
+		lanes:          newLaneQueues(lanes),
+		capacity:       size,
+		queue:          queue,
+		limiter:        limiter,
+		statsReporters: statsReporters,
+		logger:         logger,
+	}
+	q.cond = sync.NewCond(&q.mutex)
+	return q
+}
+
+func (q *handlerQueue) start(workers int) {
+	q.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer q.workers.Done()
+			q.work()
+		}()
+	}
+}
+
+// stop stops the workers once the queued messages are handled, and returns a
+// channel closed when they are done. Nothing may be pushed after it is called
+func (q *handlerQueue) stop() <-chan struct{} {
+	q.mutex.Lock()
+	q.stopped = true
+	q.cond.Broadcast()
+	q.mutex.Unlock()
+	done := make(chan struct{})
+	go func() {
+		q.workers.Wait()
+		close(done)
+	}()
+	return done
+}
+
+// pop returns the next message to handle and its lane. If wait is set, it
+// waits for a message while the queue is not stopped. It returns false if
+// there is no message
+func (q *handlerQueue) pop(wait bool) (*interfaces.Lane, interfaces.KafkaMessage, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for wait && q.lanes.size == 0 && !q.stopped {
+		q.cond.Wait()
+	}
+	lane, message, ok := q.lanes.pop()
+	if ok && q.full && q.lanes.size <= q.capacity {
+		q.full = false
+		q.resume()
+	}
+	return lane, message, ok
+}
+
+func (q *handlerQueue) work() {
+	throttled := false
+	for {
+		lane, message, ok := q.pop(false)
+		if !ok {
+			// the queued messages were handled, so the game can be fetched again
+			if throttled {
+				q.unthrottle()
+				throttled = false
+			}
+			lane, message, ok = q.pop(true)
+		}
+		if !ok {
+			return
+		}
+		if !message.ReceivedAt.IsZero() {
+			for _, statsReporter := range q.statsReporters {
+				statsReporter.ReportMetricTiming(
+					"lane_wait", time.Since(message.ReceivedAt),
+					q.game, q.platform, "lane:"+lane.Name,
+				)
+			}
+		}
+		if q.waitRateLimit(!throttled) {
+			throttled = true
+		} else if throttled {
+			q.unthrottle()
+			throttled = false
+		}
+		q.handler.HandleMessages(message)
+	}
+}
+
+// waitRateLimit waits until the rate limits allow another message of the
+// game, pausing its consumption first if pause is set. It returns whether it
+// had to wait
+func (q *handlerQueue) waitRateLimit(pause bool) bool {
+	if q.limiter == nil {
+		return false
+	}
+	wait, limit := q.limiter.reserve(q.game, q.platform)
+	if wait <= 0 {
+		return false
+	}
+	for _, statsReporter := range q.statsReporters {
+		statsReporter.ReportMetricTiming("rate_limiter_wait", wait, q.game, q.platform, "limit:"+limit)
+	}
+	if pause {
+		q.logger.WithFields(logrus.Fields{
+			"method": "waitRateLimit",
+			"game":   q.game,
+			"limit":  limit,
+		}).Debug("rate limit reached, pausing game consumption")
+		err := q.queue.PauseGame(q.game, q.platform)
+		if err != nil {
+			q.logger.WithError(err).Error("error pausing game consumption")
+		}
+	}
+	time.Sleep(wait)
+	return true
+}
+
+func (q *handlerQueue) unthrottle() {
+	err := q.queue.ResumeGame(q.game, q.platform)
+	if err != nil {
+		q.logger.WithFields(logrus.Fields{
+			"method": "unthrottle",
+			"game":   q.game,
+		}).WithError(err).Error("error resuming game consumption")
+	}
+}
+
+// push adds the message to the queue of its lane without blocking
+func (q *handlerQueue) push(message interfaces.KafkaMessage) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.lanes.push(message)
+	q.cond.Signal()
+	if !q.full && q.lanes.size > q.capacity {
+		q.full = true
+		q.pause()
+	}
+}
+
+func (q *handlerQueue) pause() {
+	l := q.logger.WithFields(logrus.Fields{
+		"method": "pause",
+		"game":   q.game,
+	})
+	l.Warn("handler queue is full, pausing game consumption")
+	for _, statsReporter := range q.statsReporters {
+		statsReporter.ReportMetricCount("handler_queue_full", 1, q.game, q.platform)
+	}
+	err := q.queue.PauseGame(q.game, q.platform)
+	if err != nil {
+		l.WithError(err).Error("error pausing game consumption")
+	}
+}
+
+func (q *handlerQueue) resume() {
+	l := q.logger.WithFields(logrus.Fields{
+		"method": "resume",
+		"game":   q.game,
+	})
+	l.Info("handler queue drained, resuming game consumption")
+	err := q.queue.ResumeGame(q.game, q.platform)
+	if err != nil {
+		l.WithError(err).Error("error resuming game consumption")
+	}
+}
+
+// size returns the number of messages waiting to be handled
+func (q *handlerQueue) size() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.lanes.size
+}
+
+func (q *handlerQueue) reportStats() {
+	q.mutex.Lock()
+	size := float64(q.lanes.size)
+	laneSizes := make([]float64, len(q.lanes.lanes))
+	for i := range q.lanes.lanes {
+		laneSizes[i] = float64(q.lanes.laneSize(i))
+	}
+	q.mutex.Unlock()
+	for _, statsReporter := range q.statsReporters {
+		statsReporter.ReportMetricGauge("handler_queue_size", size, q.game, q.platform)
+		for i, lane := range q.lanes.lanes {
+			statsReporter.ReportMetricGauge("lane_size", laneSizes[i], q.game, q.platform, "lane:"+lane.Name)
+		}
+	}
+}
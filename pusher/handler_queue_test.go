@@ -0,0 +1,250 @@
+/*
+ * Copyright (c) 2026 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package pusher
+
+import (
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/pusher/extensions"
+	"github.com/topfreegames/pusher/interfaces"
+	"github.com/topfreegames/pusher/mocks"
+)
+
+type blockingMessageHandler struct {
+	mutex   sync.Mutex
+	handled []interfaces.KafkaMessage
+	release chan struct{}
+}
+
+func (h *blockingMessageHandler) HandleMessages(msg interfaces.KafkaMessage) {
+	<-h.release
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.handled = append(h.handled, msg)
+}
+
+func (h *blockingMessageHandler) handledCount() int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return len(h.handled)
+}
+
+func (h *blockingMessageHandler) HandleResponses()    {}
+func (h *blockingMessageHandler) LogStats()           {}
+func (h *blockingMessageHandler) CleanMetadataCache() {}
+
+type pausingQueue struct {
+	interfaces.Queue
+	mutex  sync.Mutex
+	paused map[string]bool
+	pauses int
+}
+
+func (q *pausingQueue) PauseGame(game, platform string) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.paused[game+"_"+platform] = true
+	q.pauses++
+	return nil
+}
+
+func (q *pausingQueue) pauseCount() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.pauses
+}
+
+func (q *pausingQueue) ResumeGame(game, platform string) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.paused[game+"_"+platform] = false
+	return nil
+}
+
+func (q *pausingQueue) isPaused(game, platform string) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.paused[game+"_"+platform]
+}
+
+var _ = Describe("Handler Queue", func() {
+	var handler *blockingMessageHandler
+	var queue *pausingQueue
+	var mockStatsDClient *mocks.StatsDClientMock
+	var q *handlerQueue
+
+	BeforeEach(func() {
+		logger, _ := test.NewNullLogger()
+		mockStatsDClient = mocks.NewStatsDClientMock()
+		statsD, err := extensions.NewStatsD(viper.New(), logger, mockStatsDClient)
+		Expect(err).NotTo(HaveOccurred())
+		handler = &blockingMessageHandler{release: make(chan struct{})}
+		queue = &pausingQueue{paused: map[string]bool{}}
+		q = newHandlerQueue("game", "gcm", handler, 2, nil, queue, nil, []interfaces.StatsReporter{statsD}, logger)
+	})
+
+	Describe("[Unit]", func() {
+		It("should send messages to the handler", func() {
+			q.start(1)
+			close(handler.release)
+			q.push(interfaces.KafkaMessage{Game: "game", Value: []byte("1")})
+			q.push(interfaces.KafkaMessage{Game: "game", Value: []byte("2")})
+			Eventually(handler.handledCount).Should(Equal(2))
+			Expect(queue.isPaused("game", "gcm")).To(BeFalse())
+		})
+
+		It("should not block when the queue is full", func() {
+			done := make(chan struct{})
+			go func() {
+				for i := 0; i < 5; i++ {
+					q.push(interfaces.KafkaMessage{Game: "game"})
+				}
+				close(done)
+			}()
+			Eventually(done).Should(BeClosed())
+			Expect(q.size()).To(Equal(5))
+		})
+
+		It("should pause the game and report it when the queue is full", func() {
+			for i := 0; i < 3; i++ {
+				q.push(interfaces.KafkaMessage{Game: "game"})
+			}
+			Expect(queue.isPaused("game", "gcm")).To(BeTrue())
+			Expect(mockStatsDClient.Counts["handler_queue_full"]).To(Equal(int64(1)))
+		})
+
+		It("should resume the game and keep the order when the queue drains", func() {
+			for i := 0; i < 5; i++ {
+				q.push(interfaces.KafkaMessage{Game: "game", Value: []byte{byte(i)}})
+			}
+			q.start(1)
+			close(handler.release)
+			Eventually(handler.handledCount).Should(Equal(5))
+			Eventually(func() bool { return queue.isPaused("game", "gcm") }).Should(BeFalse())
+			for i, msg := range handler.handled {
+				Expect(msg.Value).To(Equal([]byte{byte(i)}))
+			}
+		})
+
+		It("should report the queue size", func() {
+			q.push(interfaces.KafkaMessage{Game: "game"})
+			q.reportStats()
+			Expect(mockStatsDClient.Gauges["handler_queue_size"]).To(BeEquivalentTo(1))
+			Expect(mockStatsDClient.Gauges["lane_size"]).To(BeEquivalentTo(1))
+			Expect(mockStatsDClient.Tags["lane_size"]).To(ContainElement("lane:default"))
+		})
+
+		It("should report how long messages waited in their lane", func() {
+			q.start(1)
+			close(handler.release)
+			q.push(interfaces.KafkaMessage{Game: "game", ReceivedAt: time.Now().Add(-time.Second)})
+
+			Eventually(handler.handledCount).Should(Equal(1))
+			Expect(mockStatsDClient.Timings["lane_wait"]).To(BeNumerically(">=", time.Second))
+			Expect(mockStatsDClient.Tags["lane_wait"]).To(ContainElement("lane:default"))
+		})
+
+		It("should not delay single messages behind a massive backlog", func() {
+			lanes := []*interfaces.Lane{
+				{Name: "single", Weight: 1},
+				{Name: "massive", Weight: 1},
+			}
+			q = newHandlerQueue("game", "gcm", handler, 1000, lanes, queue, nil, q.statsReporters, q.logger)
+			for i := 0; i < 1000; i++ {
+				q.push(interfaces.KafkaMessage{Game: "game", Topic: "push-game_gcm"})
+			}
+			q.push(interfaces.KafkaMessage{
+				Game:  "game",
+				Topic: "push-game_gcm",
+				Value: []byte(`{"metadata": {"lane": "single"}}`),
+			})
+			q.start(1)
+			close(handler.release)
+
+			Eventually(handler.handledCount).Should(BeNumerically(">=", 2))
+			handler.mutex.Lock()
+			first := handler.handled[:2]
+			handler.mutex.Unlock()
+			Expect([]string{string(first[0].Value), string(first[1].Value)}).To(ContainElement(`{"metadata": {"lane": "single"}}`))
+			Eventually(handler.handledCount).Should(Equal(1001))
+		})
+
+		It("should not be blocked by the queue of another game", func() {
+			other := &blockingMessageHandler{release: make(chan struct{})}
+			close(other.release)
+			otherQueue := newHandlerQueue("other", "gcm", other, 2, nil, queue, nil, nil, q.logger)
+			otherQueue.start(1)
+			q.start(1)
+			for i := 0; i < 5; i++ {
+				q.push(interfaces.KafkaMessage{Game: "game"})
+			}
+			otherQueue.push(interfaces.KafkaMessage{Game: "other"})
+			Eventually(other.handledCount, time.Second).Should(Equal(1))
+			Expect(handler.handledCount()).To(Equal(0))
+			Expect(queue.isPaused("other", "gcm")).To(BeFalse())
+			close(handler.release)
+		})
+
+		It("should pause the game while waiting for the rate limit", func() {
+			config := viper.New()
+			config.Set("rateLimit.games.game.rate", 20)
+			config.Set("rateLimit.games.game.burst", 1)
+			q.limiter = newRateLimiter(config, []string{"gcm"}, []string{"game"})
+			close(handler.release)
+			for i := 0; i < 2; i++ {
+				q.push(interfaces.KafkaMessage{Game: "game"})
+			}
+			start := time.Now()
+			q.start(1)
+
+			Eventually(handler.handledCount).Should(Equal(2))
+			Expect(time.Since(start)).To(BeNumerically(">=", 40*time.Millisecond))
+			Expect(queue.pauseCount()).To(Equal(1))
+			Eventually(func() bool { return queue.isPaused("game", "gcm") }).Should(BeFalse())
+			Expect(mockStatsDClient.Timings["rate_limiter_wait"]).To(BeNumerically(">", 0))
+			Expect(mockStatsDClient.Tags["rate_limiter_wait"]).To(ContainElement("limit:game"))
+		})
+
+		It("should keep the game paused while it is throttled", func() {
+			config := viper.New()
+			config.Set("rateLimit.global.rate", 100)
+			config.Set("rateLimit.global.burst", 1)
+			q = newHandlerQueue("game", "gcm", handler, 10, nil, queue, newRateLimiter(config, nil, nil), q.statsReporters, q.logger)
+			close(handler.release)
+			for i := 0; i < 5; i++ {
+				q.push(interfaces.KafkaMessage{Game: "game"})
+			}
+			q.start(1)
+
+			Eventually(handler.handledCount).Should(Equal(5))
+			Expect(queue.pauseCount()).To(Equal(1))
+			Eventually(func() bool { return queue.isPaused("game", "gcm") }).Should(BeFalse())
+			Expect(mockStatsDClient.Tags["rate_limiter_wait"]).To(ContainElement("limit:global"))
+		})
+	})
+})
@@ -0,0 +1,117 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package pusher
+
+import (
+	"encoding/json"
+
+	"github.com/topfreegames/pusher/interfaces"
+)
+
+// laneQueues keeps the messages waiting for a handler by lane and takes them
+// by smooth weighted round robin, so the lanes with messages waiting are
+// handled in proportion to their weights and a backlog in a lane doesn't
+// delay the others. It is not safe for concurrent use
+type laneQueues struct {
+	lanes   []*interfaces.Lane
+	queued  [][]interfaces.KafkaMessage
+	current []int
+	size    int
+}
+
+func newLaneQueues(lanes []*interfaces.Lane) *laneQueues {
+	if len(lanes) == 0 {
+		lanes = []*interfaces.Lane{{Name: "default", Weight: 1}}
+	}
+	return &laneQueues{
+		lanes:   lanes,
+		queued:  make([][]interfaces.KafkaMessage, len(lanes)),
+		current: make([]int, len(lanes)),
+	}
+}
+
+// laneOf returns the index of the lane named by the metadata.lane of the
+// message, or else of the first lane whose topics match its topic, or else of
+// the last lane
+func (l *laneQueues) laneOf(message interfaces.KafkaMessage) int {
+	if len(l.lanes) == 1 {
+		return 0
+	}
+	m := &struct {
+		Metadata struct {
+			Lane string `json:"lane"`
+		} `json:"metadata"`
+	}{}
+	json.Unmarshal(message.Value, m)
+	if m.Metadata.Lane != "" {
+		for i, lane := range l.lanes {
+			if lane.Name == m.Metadata.Lane {
+				return i
+			}
+		}
+	}
+	for i, lane := range l.lanes {
+		if lane.Topics != nil && lane.Topics.MatchString(message.Topic) {
+			return i
+		}
+	}
+	return len(l.lanes) - 1
+}
+
+// push adds the message to the end of its lane
+func (l *laneQueues) push(message interfaces.KafkaMessage) {
+	i := l.laneOf(message)
+	l.queued[i] = append(l.queued[i], message)
+	l.size++
+}
+
+// pop removes and returns the next message and its lane, and false if no
+// message is waiting
+func (l *laneQueues) pop() (*interfaces.Lane, interfaces.KafkaMessage, bool) {
+	total := 0
+	next := -1
+	for i, lane := range l.lanes {
+		if len(l.queued[i]) == 0 {
+			continue
+		}
+		l.current[i] += lane.Weight
+		total += lane.Weight
+		if next == -1 || l.current[i] > l.current[next] {
+			next = i
+		}
+	}
+	if next == -1 {
+		return nil, interfaces.KafkaMessage{}, false
+	}
+	l.current[next] -= total
+	message := l.queued[next][0]
+	l.queued[next][0] = interfaces.KafkaMessage{}
+	l.queued[next] = l.queued[next][1:]
+	l.size--
+	return l.lanes[next], message, true
+}
+
+// laneSize returns the number of messages waiting in the lane with the index
+func (l *laneQueues) laneSize(i int) int {
+	return len(l.queued[i])
+}
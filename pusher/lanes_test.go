@@ -0,0 +1,118 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package pusher
+
+import (
+	"regexp"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/topfreegames/pusher/interfaces"
+)
+
+var _ = Describe("Lane Queues", func() {
+	var single, massive *interfaces.Lane
+	var l *laneQueues
+
+	fill := func(lane *interfaces.Lane, n int) {
+		for i := 0; i < n; i++ {
+			l.push(interfaces.KafkaMessage{Topic: "push-game_gcm_" + lane.Name})
+		}
+	}
+
+	BeforeEach(func() {
+		single = &interfaces.Lane{Name: "single", Weight: 3, Topics: regexp.MustCompile("[_-]single$")}
+		massive = &interfaces.Lane{Name: "massive", Weight: 1, Topics: regexp.MustCompile("[_-]massive$")}
+		l = newLaneQueues([]*interfaces.Lane{single, massive})
+	})
+
+	Describe("[Unit]", func() {
+		It("should take the messages of the lanes in proportion to their weights", func() {
+			fill(single, 30)
+			fill(massive, 30)
+			counts := map[string]int{}
+			for i := 0; i < 40; i++ {
+				lane, message, ok := l.pop()
+				Expect(ok).To(BeTrue())
+				Expect(message.Topic).To(HaveSuffix(lane.Name))
+				counts[lane.Name]++
+			}
+			Expect(counts["single"]).To(Equal(30))
+			Expect(counts["massive"]).To(Equal(10))
+			Expect(l.size).To(Equal(20))
+		})
+
+		It("should interleave the lanes", func() {
+			fill(massive, 3)
+			fill(single, 3)
+			names := []string{}
+			for i := 0; i < 4; i++ {
+				lane, _, _ := l.pop()
+				names = append(names, lane.Name)
+			}
+			Expect(names).To(ContainElement("massive"))
+			Expect(names[0]).To(Equal("single"))
+		})
+
+		It("should take all the messages of a lane when the others are empty", func() {
+			fill(massive, 5)
+			for i := 0; i < 5; i++ {
+				lane, _, ok := l.pop()
+				Expect(ok).To(BeTrue())
+				Expect(lane).To(Equal(massive))
+			}
+			_, _, ok := l.pop()
+			Expect(ok).To(BeFalse())
+			Expect(l.size).To(BeZero())
+		})
+
+		It("should have a single default lane without lanes", func() {
+			l = newLaneQueues(nil)
+			l.push(interfaces.KafkaMessage{Topic: "push-game_gcm_single"})
+			lane, _, ok := l.pop()
+			Expect(ok).To(BeTrue())
+			Expect(lane.Name).To(Equal("default"))
+		})
+
+		It("should put messages in the lane of their topic", func() {
+			Expect(l.laneOf(interfaces.KafkaMessage{Topic: "push-game_apns-single"})).To(Equal(0))
+			Expect(l.laneOf(interfaces.KafkaMessage{Topic: "push-game_gcm_massive"})).To(Equal(1))
+		})
+
+		It("should put messages in the lane of their metadata", func() {
+			message := interfaces.KafkaMessage{Topic: "push-game_apns-massive", Value: []byte(`{"metadata": {"lane": "single"}}`)}
+			Expect(l.laneOf(message)).To(Equal(0))
+			message.Value = []byte(`{"metadata": {"lane": "unknown"}}`)
+			Expect(l.laneOf(message)).To(Equal(1))
+		})
+
+		It("should not use the priority of the messages as their lane", func() {
+			message := interfaces.KafkaMessage{Topic: "push-game_apns", Value: []byte(`{"metadata": {"priority": "single"}}`)}
+			Expect(l.laneOf(message)).To(Equal(1))
+		})
+
+		It("should put other messages in the last lane", func() {
+			Expect(l.laneOf(interfaces.KafkaMessage{Topic: "push-game_apns", Value: []byte(`invalid`)})).To(Equal(1))
+		})
+	})
+})
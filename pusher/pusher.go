@@ -23,34 +23,236 @@
 package pusher
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"os/signal"
 	"runtime"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
+	"github.com/topfreegames/pusher/extensions"
 	"github.com/topfreegames/pusher/interfaces"
 )
 
+const (
+	// APNSPlatform is the platform of the apns topics and message handlers
+	APNSPlatform = "apns"
+	// GCMPlatform is the platform of the gcm topics and message handlers
+	GCMPlatform = "gcm"
+)
+
 // Pusher struct for pusher
 type Pusher struct {
 	Config                  *viper.Viper
 	feedbackReporters       []interfaces.FeedbackReporter
+	gcmClient               interfaces.GCMClient
 	GracefulShutdownTimeout int
+	handlerQueues           map[string]map[string]*handlerQueue
+	handlersMutex           sync.RWMutex
 	IsProduction            bool
+	limiter                 *rateLimiter
 	Logger                  *logrus.Logger
-	MessageHandler          map[string]interfaces.MessageHandler
+	MessageHandlers         map[string]map[string]interfaces.MessageHandler
 	Queue                   interfaces.Queue
+	quietHours              *quietHours
+	registry                *appRegistry
 	run                     bool
+	scheduler               *scheduler
 	StatsReporters          []interfaces.StatsReporter
 	stopChannel             chan struct{}
+	upstreamReporters       []interfaces.UpstreamReporter
 }
 
 func (p *Pusher) loadConfigurationDefaults() {
 	p.Config.SetDefault("gracefulShutdownTimeout", 10)
+	p.Config.SetDefault("handlerQueue.size", 100)
+	p.Config.SetDefault("handlerQueue.workers", 1)
+	p.Config.SetDefault("rateLimit.global.rate", 0)
+	p.Config.SetDefault("rateLimit.global.burst", 0)
+	p.Config.SetDefault("registry.source", "config")
+	p.Config.SetDefault("registry.interval", 60)
+	p.Config.SetDefault("scheduler.enabled", false)
+	p.Config.SetDefault("scheduler.pollInterval", 1000)
+	p.Config.SetDefault("scheduler.batchSize", 100)
 	p.Config.SetDefault("stats.reporters", []string{})
+	p.Config.SetDefault("upstream.reporters", []string{})
+}
+
+// configureCommon configures what is shared by the handlers of all platforms
+func (p *Pusher) configureCommon(statsdClientOrNil interfaces.StatsDClient, db interfaces.DB) error {
+	p.loadConfigurationDefaults()
+	p.GracefulShutdownTimeout = p.Config.GetInt("gracefulShutdownTimeout")
+	if err := p.configureStatsReporters(statsdClientOrNil); err != nil {
+		return err
+	}
+	if err := p.configureFeedbackReporters(); err != nil {
+		return err
+	}
+	q, err := extensions.NewKafkaConsumer(
+		p.Config,
+		p.Logger,
+		&p.stopChannel,
+	)
+	if err != nil {
+		return err
+	}
+	p.Queue = q
+	p.MessageHandlers = map[string]map[string]interfaces.MessageHandler{}
+	p.limiter = newRateLimiter(p.Config, []string{APNSPlatform, GCMPlatform}, nil)
+	p.quietHours = newQuietHours()
+	if p.Config.GetBool("scheduler.enabled") {
+		return p.configureScheduler(db)
+	}
+	return nil
+}
+
+func (p *Pusher) configureScheduler(db interfaces.DB) error {
+	store, err := extensions.NewScheduledMessageStore(p.Config, db)
+	if err != nil {
+		return err
+	}
+	p.scheduler = newScheduler(
+		store,
+		p.servedGames,
+		time.Duration(p.Config.GetInt("scheduler.pollInterval"))*time.Millisecond,
+		p.Config.GetInt("scheduler.batchSize"),
+		p.releaseScheduled,
+		p.StatsReporters,
+		p.Logger,
+	)
+	return nil
+}
+
+// configureRegistry starts the handlers of the apps of the platforms. Without
+// the apps table, at least one app must be initialized
+func (p *Pusher) configureRegistry(db interfaces.DB, platforms ...string) error {
+	var store *extensions.AppStore
+	switch source := p.Config.GetString("registry.source"); source {
+	case "config":
+	case "pg":
+		var err error
+		store, err = extensions.NewAppStore(p.Config, db)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown registry source %s", source)
+	}
+	for _, platform := range platforms {
+		p.MessageHandlers[platform] = map[string]interfaces.MessageHandler{}
+	}
+	interval := time.Duration(p.Config.GetInt("registry.interval")) * time.Second
+	p.registry = newAppRegistry(p, platforms, store, interval)
+	if p.registry.sync() == 0 && store == nil {
+		return errors.New("Could not initilize any app")
+	}
+	return nil
+}
+
+// quietHoursRule returns the quiet hours rule of the game, or nil if it has
+// none. Deferring messages requires the scheduler
+func (p *Pusher) quietHoursRule(game string) (*quietHoursRule, error) {
+	rule, err := newQuietHoursRule(p.Config, game)
+	if err != nil {
+		return nil, err
+	}
+	if rule != nil && !rule.drop && p.scheduler == nil {
+		return nil, fmt.Errorf("deferring messages of game %s during quiet hours requires scheduler.enabled", game)
+	}
+	return rule, nil
+}
+
+// newHandler creates the message handler of the app of the game, configured
+// with the given config
+func (p *Pusher) newHandler(platform, game string, config *viper.Viper) (interfaces.MessageHandler, error) {
+	switch platform {
+	case APNSPlatform:
+		return p.newAPNSHandler(game, config)
+	case GCMPlatform:
+		return p.newGCMHandler(game, config)
+	}
+	return nil, fmt.Errorf("unknown platform %s", platform)
+}
+
+// setHandler sets the message handler of the game, replacing the previous one
+// once the messages already queued to it are handled. Once the pusher is
+// started, the handler gets its own queue and starts right away
+func (p *Pusher) setHandler(platform, game string, handler interfaces.MessageHandler) {
+	p.limiter.addGame(game)
+	p.handlersMutex.Lock()
+	if p.MessageHandlers[platform] == nil {
+		p.MessageHandlers[platform] = map[string]interfaces.MessageHandler{}
+	}
+	previous := p.MessageHandlers[platform][game]
+	p.MessageHandlers[platform][game] = handler
+	var previousQueue *handlerQueue
+	if p.handlerQueues != nil {
+		previousQueue = p.handlerQueues[platform][game]
+		p.addHandlerQueue(platform, game, handler)
+		startHandler(handler)
+	}
+	p.handlersMutex.Unlock()
+	if previous != nil {
+		p.retireHandler(previous, previousQueue)
+	}
+}
+
+// removeHandler removes the message handler of the game. The messages already
+// queued to it are still handled. Once no platform serves the game, its quiet
+// hours and rate limit are removed too
+func (p *Pusher) removeHandler(platform, game string) {
+	p.handlersMutex.Lock()
+	handler, ok := p.MessageHandlers[platform][game]
+	if !ok {
+		p.handlersMutex.Unlock()
+		return
+	}
+	delete(p.MessageHandlers[platform], game)
+	var queue *handlerQueue
+	if p.handlerQueues != nil {
+		queue = p.handlerQueues[platform][game]
+		delete(p.handlerQueues[platform], game)
+	}
+	served := false
+	for _, handlers := range p.MessageHandlers {
+		if _, ok := handlers[game]; ok {
+			served = true
+		}
+	}
+	p.handlersMutex.Unlock()
+	if !served {
+		p.quietHours.set(game, nil)
+		p.limiter.removeGame(game)
+	}
+	p.retireHandler(handler, queue)
+}
+
+// retireHandler cleans the handler up once its queued messages are handled
+// and the ones in flight had the graceful shutdown timeout to get feedback
+func (p *Pusher) retireHandler(handler interfaces.MessageHandler, queue *handlerQueue) {
+	go func() {
+		if queue != nil {
+			<-queue.stop()
+			time.Sleep(time.Duration(p.GracefulShutdownTimeout) * time.Second)
+		}
+		if c, ok := handler.(interface {
+			Cleanup() error
+		}); ok {
+			if err := c.Cleanup(); err != nil {
+				p.Logger.WithField("method", "retireHandler").WithError(err).Error("error cleaning handler up")
+			}
+		}
+	}()
+}
+
+func startHandler(handler interfaces.MessageHandler) {
+	go handler.HandleResponses()
+	go handler.LogStats()
+	go handler.CleanMetadataCache()
 }
 
 func (p *Pusher) configureFeedbackReporters() error {
@@ -62,6 +264,15 @@ func (p *Pusher) configureFeedbackReporters() error {
 	return nil
 }
 
+func (p *Pusher) configureUpstreamReporters() error {
+	reporters, err := configureUpstreamReporters(p.Config, p.Logger)
+	if err != nil {
+		return err
+	}
+	p.upstreamReporters = reporters
+	return nil
+}
+
 func (p *Pusher) configureStatsReporters(clientOrNil interfaces.StatsDClient) error {
 	reporters, err := configureStatsReporters(p.Config, p.Logger, clientOrNil)
 	if err != nil {
@@ -71,20 +282,134 @@ func (p *Pusher) configureStatsReporters(clientOrNil interfaces.StatsDClient) er
 	return nil
 }
 
+func (p *Pusher) configureHandlerQueues() {
+	p.handlersMutex.Lock()
+	defer p.handlersMutex.Unlock()
+	p.handlerQueues = map[string]map[string]*handlerQueue{}
+	for platform, handlers := range p.MessageHandlers {
+		for game, handler := range handlers {
+			p.limiter.addGame(game)
+			p.addHandlerQueue(platform, game, handler)
+		}
+	}
+}
+
+// addHandlerQueue starts the queue of the handler, which must be called with
+// the handlers mutex locked
+func (p *Pusher) addHandlerQueue(platform, game string, handler interfaces.MessageHandler) {
+	size := p.Config.GetInt("handlerQueue.size")
+	workers := p.Config.GetInt("handlerQueue.workers")
+	q := newHandlerQueue(game, platform, handler, size, p.Queue.Lanes(), p.Queue, p.limiter, p.StatsReporters, p.Logger)
+	q.start(workers)
+	if p.handlerQueues[platform] == nil {
+		p.handlerQueues[platform] = map[string]*handlerQueue{}
+	}
+	p.handlerQueues[platform][game] = q
+}
+
 func (p *Pusher) routeMessages(msgChan *chan interfaces.KafkaMessage) {
 	for p.run == true {
 		select {
 		case message := <-*msgChan:
-			if handler, ok := p.MessageHandler[message.Game]; ok {
-				handler.HandleMessages(message)
+			p.dispatch(message)
+		}
+	}
+}
+
+// dispatch holds the message until its send_at or the end of the quiet hours
+// of its game, or drops it if the quiet hours say so, and routes it otherwise
+func (p *Pusher) dispatch(message interfaces.KafkaMessage) {
+	if p.scheduler != nil {
+		if at := sendAt(message); at > nowInMilliseconds() {
+			p.scheduler.schedule(message, at)
+			p.messageDone()
+			return
+		}
+	}
+	if p.quietHours != nil {
+		end, drop, err := p.quietHours.check(message, time.Now())
+		l := p.Logger.WithFields(logrus.Fields{
+			"method": "dispatch",
+			"game":   message.Game,
+		})
+		if err != nil {
+			l.WithError(err).Warn("ignoring invalid timezone of message")
+		}
+		if !end.IsZero() {
+			if drop {
+				l.Debug("dropping message during quiet hours")
+				for _, statsReporter := range p.StatsReporters {
+					statsReporter.ReportMetricCount("ignored", 1, message.Game, message.Platform, "reason:quiet-hours")
+				}
 			} else {
-				p.Logger.WithFields(logrus.Fields{
-					"method": "routeMessages",
-					"game":   message.Game,
-				}).Error("Game not found")
+				l.Debugf("deferring message to the end of quiet hours at %s", end)
+				p.scheduler.schedule(message, end.UnixNano()/int64(time.Millisecond))
 			}
+			p.messageDone()
+			return
 		}
 	}
+	p.route(message)
+}
+
+// route sends the message to the queue of its game and platform
+func (p *Pusher) route(message interfaces.KafkaMessage) {
+	p.handlersMutex.RLock()
+	q, ok := p.handlerQueues[message.Platform][message.Game]
+	if ok {
+		// pushing doesn't block, and a removed queue must not get messages
+		q.push(message)
+	}
+	p.handlersMutex.RUnlock()
+	if ok {
+		return
+	}
+	p.Logger.WithFields(logrus.Fields{
+		"method":   "route",
+		"game":     message.Game,
+		"platform": message.Platform,
+	}).Error("Game not found")
+	// the message won't be handled, so it must not hold the shutdown
+	p.messageDone()
+}
+
+// handlers returns a copy of the message handlers of the platform by game
+func (p *Pusher) handlers(platform string) map[string]interfaces.MessageHandler {
+	p.handlersMutex.RLock()
+	defer p.handlersMutex.RUnlock()
+	handlers := map[string]interfaces.MessageHandler{}
+	for game, handler := range p.MessageHandlers[platform] {
+		handlers[game] = handler
+	}
+	return handlers
+}
+
+// servedGames returns the games with a message handler, by platform
+func (p *Pusher) servedGames() map[string][]string {
+	p.handlersMutex.RLock()
+	defer p.handlersMutex.RUnlock()
+	served := map[string][]string{}
+	for platform, handlers := range p.MessageHandlers {
+		for game := range handlers {
+			served[platform] = append(served[platform], game)
+		}
+	}
+	return served
+}
+
+// releaseScheduled dispatches a due scheduled message, which is pending again
+// until it is handled. It may still be held by quiet hours
+func (p *Pusher) releaseScheduled(message interfaces.KafkaMessage) {
+	if wg := p.Queue.PendingMessagesWaitGroup(); wg != nil {
+		wg.Add(1)
+	}
+	p.dispatch(message)
+}
+
+func (p *Pusher) messageDone() {
+	if wg := p.Queue.PendingMessagesWaitGroup(); wg != nil {
+		wg.Done()
+	}
 }
 
 // Start starts pusher
@@ -94,11 +419,20 @@ func (p *Pusher) Start() {
 		"method": "start",
 	})
 	l.Info("starting pusher...")
+	p.configureHandlerQueues()
+	if p.scheduler != nil {
+		go p.scheduler.run()
+	}
 	go p.routeMessages(p.Queue.MessagesChannel())
-	for _, v := range p.MessageHandler {
-		go v.HandleResponses()
-		go v.LogStats()
-		go v.CleanMetadataCache()
+	p.handlersMutex.RLock()
+	for _, handlers := range p.MessageHandlers {
+		for _, v := range handlers {
+			startHandler(v)
+		}
+	}
+	p.handlersMutex.RUnlock()
+	if p.registry != nil {
+		go p.registry.run()
 	}
 	go p.Queue.ConsumeLoop()
 	go p.reportGoStats()
@@ -117,6 +451,12 @@ func (p *Pusher) Start() {
 		}
 	}
 	p.Queue.StopConsuming()
+	if p.registry != nil {
+		p.registry.stop()
+	}
+	if p.scheduler != nil {
+		p.scheduler.stop()
+	}
 	GracefulShutdown(p.Queue.PendingMessagesWaitGroup(), time.Duration(p.GracefulShutdownTimeout)*time.Second)
 }
 
@@ -133,6 +473,18 @@ func (p *Pusher) reportGoStats() {
 				gcTime,
 			)
 		}
+		p.handlersMutex.RLock()
+		for _, queues := range p.handlerQueues {
+			for _, q := range queues {
+				q.reportStats()
+			}
+		}
+		p.handlersMutex.RUnlock()
+		if p.scheduler != nil {
+			for _, statsReporter := range p.StatsReporters {
+				statsReporter.ReportMetricGauge("scheduled_messages", float64(p.scheduler.size()), "", "")
+			}
+		}
 		time.Sleep(30 * time.Second)
 	}
 }
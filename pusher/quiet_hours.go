@@ -0,0 +1,207 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package pusher
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/topfreegames/pusher/interfaces"
+)
+
+// criticalPriority is the metadata priority of the messages sent even during
+// quiet hours
+const criticalPriority = "critical"
+
+var offsetRegex = regexp.MustCompile(`^([+-])(\d{2}):?(\d{2})$`)
+
+// quietHoursRule holds the non-critical messages of a game sent between start
+// and end, in minutes since midnight, in the timezone of the recipient or in
+// location if the message has none
+type quietHoursRule struct {
+	start    int
+	end      int
+	drop     bool
+	location *time.Location
+}
+
+// quietHours holds the quiet hours rules of the games, as configured in
+// quietHours.games
+type quietHours struct {
+	rules map[string]*quietHoursRule
+	mutex sync.RWMutex
+}
+
+// newQuietHours returns quiet hours without rules
+func newQuietHours() *quietHours {
+	return &quietHours{rules: map[string]*quietHoursRule{}}
+}
+
+// newQuietHoursRule returns the rule of the game, or nil if it has none
+func newQuietHoursRule(config *viper.Viper, game string) (*quietHoursRule, error) {
+	rule, err := parseQuietHoursRule(config, "quietHours.games."+game)
+	if err != nil {
+		return nil, fmt.Errorf("invalid quiet hours of game %s: %s", game, err.Error())
+	}
+	return rule, nil
+}
+
+func parseQuietHoursRule(config *viper.Viper, key string) (*quietHoursRule, error) {
+	start := config.GetString(key + ".start")
+	end := config.GetString(key + ".end")
+	if start == "" && end == "" {
+		return nil, nil
+	}
+	r := &quietHoursRule{}
+	var err error
+	if r.start, err = parseClock(start); err != nil {
+		return nil, err
+	}
+	if r.end, err = parseClock(end); err != nil {
+		return nil, err
+	}
+	if r.start == r.end {
+		return nil, fmt.Errorf("start and end are both %s", start)
+	}
+	switch action := config.GetString(key + ".action"); action {
+	case "", "defer":
+	case "drop":
+		r.drop = true
+	default:
+		return nil, fmt.Errorf("unknown action %s", action)
+	}
+	// the token tables are not read, so without it messages without
+	// metadata.tz would never be in quiet hours
+	tz := config.GetString(key + ".tz")
+	if tz == "" {
+		return nil, fmt.Errorf("tz is required for the messages without metadata.tz")
+	}
+	if r.location, err = parseTimezone(tz); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// parseClock returns the minutes since midnight of a HH:MM time
+func parseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", clock)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// parseTimezone accepts offsets, as stored in the token tables (e.g. -0300),
+// and IANA names (e.g. America/Sao_Paulo)
+func parseTimezone(tz string) (*time.Location, error) {
+	if m := offsetRegex.FindStringSubmatch(tz); m != nil {
+		hours, _ := strconv.Atoi(m[2])
+		minutes, _ := strconv.Atoi(m[3])
+		offset := hours*3600 + minutes*60
+		if m[1] == "-" {
+			offset = -offset
+		}
+		return time.FixedZone(tz, offset), nil
+	}
+	location, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %q", tz)
+	}
+	return location, nil
+}
+
+// windowEnd returns when the quiet hours that now is in end, in the location,
+// and false if now is not in quiet hours
+func (r *quietHoursRule) windowEnd(now time.Time, location *time.Location) (time.Time, bool) {
+	local := now.In(location)
+	minutes := local.Hour()*60 + local.Minute()
+	endOfDay := func(days int) time.Time {
+		return time.Date(local.Year(), local.Month(), local.Day()+days, r.end/60, r.end%60, 0, 0, location)
+	}
+	if r.start < r.end {
+		if minutes >= r.start && minutes < r.end {
+			return endOfDay(0), true
+		}
+		return time.Time{}, false
+	}
+	if minutes >= r.start {
+		return endOfDay(1), true
+	}
+	if minutes < r.end {
+		return endOfDay(0), true
+	}
+	return time.Time{}, false
+}
+
+// check returns when the quiet hours of the game of the message end, or the
+// zero time if it can be sent now, and whether it must be dropped instead of
+// deferred. Critical messages are never in quiet hours, and messages with an
+// invalid metadata.tz are checked in the timezone of the rule
+func (q *quietHours) check(message interfaces.KafkaMessage, now time.Time) (time.Time, bool, error) {
+	q.mutex.RLock()
+	rule, ok := q.rules[message.Game]
+	q.mutex.RUnlock()
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	m := &struct {
+		Metadata struct {
+			Priority string `json:"priority"`
+			Timezone string `json:"tz"`
+		} `json:"metadata"`
+	}{}
+	json.Unmarshal(message.Value, m)
+	if m.Metadata.Priority == criticalPriority {
+		return time.Time{}, false, nil
+	}
+	location := rule.location
+	var err error
+	if m.Metadata.Timezone != "" {
+		if l, tzErr := parseTimezone(m.Metadata.Timezone); tzErr == nil {
+			location = l
+		} else {
+			err = tzErr
+		}
+	}
+	end, quiet := rule.windowEnd(now, location)
+	if !quiet {
+		return time.Time{}, false, err
+	}
+	return end, rule.drop, err
+}
+
+// set sets the rule of the game, or removes it if rule is nil
+func (q *quietHours) set(game string, rule *quietHoursRule) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if rule == nil {
+		delete(q.rules, game)
+		return
+	}
+	q.rules[game] = rule
+}
@@ -0,0 +1,219 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package pusher
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/pusher/interfaces"
+)
+
+var _ = Describe("Quiet Hours", func() {
+	var config *viper.Viper
+	saoPaulo := time.FixedZone("-0300", -3*3600)
+
+	BeforeEach(func() {
+		config = viper.New()
+		config.Set("quietHours.games.game.start", "22:00")
+		config.Set("quietHours.games.game.end", "08:00")
+		config.Set("quietHours.games.game.tz", "+0900")
+	})
+
+	newRules := func() *quietHours {
+		q := newQuietHours()
+		rule, err := newQuietHoursRule(config, "game")
+		Expect(err).NotTo(HaveOccurred())
+		q.set("game", rule)
+		return q
+	}
+
+	message := func(metadata string) interfaces.KafkaMessage {
+		return interfaces.KafkaMessage{
+			Game:  "game",
+			Value: []byte(`{"to": "token", "metadata": ` + metadata + `}`),
+		}
+	}
+
+	Describe("[Unit]", func() {
+		Describe("Parsing timezones", func() {
+			It("should parse offsets", func() {
+				l, err := parseTimezone("-0300")
+				Expect(err).NotTo(HaveOccurred())
+				_, offset := time.Now().In(l).Zone()
+				Expect(offset).To(Equal(-3 * 3600))
+
+				l, err = parseTimezone("+05:30")
+				Expect(err).NotTo(HaveOccurred())
+				_, offset = time.Now().In(l).Zone()
+				Expect(offset).To(Equal(5*3600 + 30*60))
+			})
+
+			It("should parse timezone names", func() {
+				l, err := parseTimezone("UTC")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(l).To(Equal(time.UTC))
+			})
+
+			It("should return an error for invalid timezones", func() {
+				_, err := parseTimezone("Nowhere/Else")
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		Describe("Creating quiet hours rules", func() {
+			It("should have no rule without quiet hours", func() {
+				rule, err := newQuietHoursRule(config, "other")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(rule).To(BeNil())
+			})
+
+			It("should configure the rule of the game", func() {
+				rule, err := newQuietHoursRule(config, "game")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(rule.start).To(Equal(22 * 60))
+				Expect(rule.end).To(Equal(8 * 60))
+				Expect(rule.drop).To(BeFalse())
+				Expect(rule.location.String()).To(Equal("+0900"))
+			})
+
+			It("should return an error for invalid rules", func() {
+				config.Set("quietHours.games.game.end", "8h")
+				_, err := newQuietHoursRule(config, "game")
+				Expect(err).To(HaveOccurred())
+
+				config.Set("quietHours.games.game.end", "22:00")
+				_, err = newQuietHoursRule(config, "game")
+				Expect(err).To(HaveOccurred())
+
+				config.Set("quietHours.games.game.end", "08:00")
+				config.Set("quietHours.games.game.action", "postpone")
+				_, err = newQuietHoursRule(config, "game")
+				Expect(err).To(HaveOccurred())
+			})
+
+			It("should require the timezone of the messages without one", func() {
+				config.Set("quietHours.games.game.tz", "")
+				_, err := newQuietHoursRule(config, "game")
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal("invalid quiet hours of game game: tz is required for the messages without metadata.tz"))
+			})
+		})
+
+		Describe("Quiet hours window", func() {
+			It("should end the next day if it spans midnight", func() {
+				r := &quietHoursRule{start: 22 * 60, end: 8 * 60}
+				end, quiet := r.windowEnd(time.Date(2017, 3, 10, 23, 30, 0, 0, saoPaulo), saoPaulo)
+				Expect(quiet).To(BeTrue())
+				Expect(end).To(BeTemporally("==", time.Date(2017, 3, 11, 8, 0, 0, 0, saoPaulo)))
+
+				end, quiet = r.windowEnd(time.Date(2017, 3, 10, 7, 59, 0, 0, saoPaulo), saoPaulo)
+				Expect(quiet).To(BeTrue())
+				Expect(end).To(BeTemporally("==", time.Date(2017, 3, 10, 8, 0, 0, 0, saoPaulo)))
+
+				_, quiet = r.windowEnd(time.Date(2017, 3, 10, 8, 0, 0, 0, saoPaulo), saoPaulo)
+				Expect(quiet).To(BeFalse())
+			})
+
+			It("should end the same day if it doesn't span midnight", func() {
+				r := &quietHoursRule{start: 12 * 60, end: 14 * 60}
+				end, quiet := r.windowEnd(time.Date(2017, 3, 10, 13, 0, 0, 0, time.UTC), time.UTC)
+				Expect(quiet).To(BeTrue())
+				Expect(end).To(BeTemporally("==", time.Date(2017, 3, 10, 14, 0, 0, 0, time.UTC)))
+
+				_, quiet = r.windowEnd(time.Date(2017, 3, 10, 23, 0, 0, 0, time.UTC), time.UTC)
+				Expect(quiet).To(BeFalse())
+			})
+
+			It("should use the time in the location", func() {
+				r := &quietHoursRule{start: 22 * 60, end: 8 * 60}
+				now := time.Date(2017, 3, 11, 2, 0, 0, 0, time.UTC)
+				_, quiet := r.windowEnd(now, saoPaulo)
+				Expect(quiet).To(BeTrue())
+				_, quiet = r.windowEnd(now, time.FixedZone("+0900", 9*3600))
+				Expect(quiet).To(BeFalse())
+			})
+		})
+
+		Describe("Checking messages", func() {
+			var q *quietHours
+			night := time.Date(2017, 3, 11, 2, 0, 0, 0, time.UTC)
+
+			BeforeEach(func() {
+				q = newRules()
+			})
+
+			It("should hold messages in quiet hours of the recipient", func() {
+				end, drop, err := q.check(message(`{"tz": "-0300"}`), night)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(drop).To(BeFalse())
+				Expect(end).To(BeTemporally("==", time.Date(2017, 3, 11, 8, 0, 0, 0, saoPaulo)))
+
+				end, _, err = q.check(message(`{"tz": "+0900"}`), night)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(end.IsZero()).To(BeTrue())
+			})
+
+			It("should let critical messages through", func() {
+				end, _, err := q.check(message(`{"tz": "-0300", "priority": "critical"}`), night)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(end.IsZero()).To(BeTrue())
+			})
+
+			It("should let messages of games without rules through", func() {
+				m := message(`{"tz": "-0300"}`)
+				m.Game = "other"
+				end, _, err := q.check(m, night)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(end.IsZero()).To(BeTrue())
+			})
+
+			It("should use the timezone of the rule for messages without one", func() {
+				end, _, err := q.check(message(`{}`), night)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(end.IsZero()).To(BeTrue())
+
+				config.Set("quietHours.games.game.tz", "-0300")
+				q = newRules()
+				end, _, err = q.check(message(`{}`), night)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(end.IsZero()).To(BeFalse())
+
+				end, _, err = q.check(message(`{"tz": "invalid"}`), night)
+				Expect(err).To(HaveOccurred())
+				Expect(end.IsZero()).To(BeFalse())
+			})
+
+			It("should return whether messages are dropped", func() {
+				config.Set("quietHours.games.game.action", "drop")
+				q = newRules()
+
+				_, drop, err := q.check(message(`{"tz": "-0300"}`), night)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(drop).To(BeTrue())
+			})
+		})
+	})
+})
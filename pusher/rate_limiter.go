@@ -0,0 +1,153 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package pusher
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// tokenBucket allows rate messages per second on average, in bursts of up to
+// burst messages
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	mutex  sync.Mutex
+}
+
+// newTokenBucket returns a full bucket, or nil if the rate is not positive
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = math.Max(1, rate)
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// reserve takes a token and returns how long to wait until it is available.
+// The tokens go negative while reserved, so concurrent callers wait in turn
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if now.After(b.last) {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+	}
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// rateLimiter limits the messages sent globally, per platform and per game,
+// as configured in rateLimit. Games and platforms without a rate configured
+// are not limited
+type rateLimiter struct {
+	global    *tokenBucket
+	platforms map[string]*tokenBucket
+	games     map[string]*tokenBucket
+	config    *viper.Viper
+	mutex     sync.RWMutex
+}
+
+func newRateLimiter(config *viper.Viper, platforms, games []string) *rateLimiter {
+	bucket := func(key string) *tokenBucket {
+		return newTokenBucket(config.GetFloat64(key+".rate"), config.GetFloat64(key+".burst"))
+	}
+	r := &rateLimiter{
+		global:    bucket("rateLimit.global"),
+		platforms: map[string]*tokenBucket{},
+		games:     map[string]*tokenBucket{},
+		config:    config,
+	}
+	for _, platform := range platforms {
+		if b := bucket("rateLimit.platforms." + platform); b != nil {
+			r.platforms[platform] = b
+		}
+	}
+	for _, game := range games {
+		r.addGame(game)
+	}
+	return r
+}
+
+// addGame limits the game, if it has a rate configured and isn't limited yet
+func (r *rateLimiter) addGame(game string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.games[game]; ok {
+		return
+	}
+	key := "rateLimit.games." + game
+	if b := newTokenBucket(r.config.GetFloat64(key+".rate"), r.config.GetFloat64(key+".burst")); b != nil {
+		r.games[game] = b
+	}
+}
+
+// removeGame stops limiting the game
+func (r *rateLimiter) removeGame(game string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.games, game)
+}
+
+// reserve takes a token of each limit of the game and platform, returning
+// how long to wait until all are available and the limit that took longest
+func (r *rateLimiter) reserve(game, platform string) (time.Duration, string) {
+	now := time.Now()
+	var wait time.Duration
+	limit := ""
+	r.mutex.RLock()
+	gameBucket := r.games[game]
+	r.mutex.RUnlock()
+	for _, l := range []struct {
+		name   string
+		bucket *tokenBucket
+	}{
+		{"global", r.global},
+		{"platform", r.platforms[platform]},
+		{"game", gameBucket},
+	} {
+		if l.bucket == nil {
+			continue
+		}
+		if w := l.bucket.reserve(now); w > wait {
+			wait = w
+			limit = l.name
+		}
+	}
+	return wait, limit
+}
@@ -0,0 +1,103 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package pusher
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+)
+
+var _ = Describe("Rate Limiter", func() {
+	Describe("[Unit]", func() {
+		Describe("Token bucket", func() {
+			It("should not be created without a rate", func() {
+				Expect(newTokenBucket(0, 10)).To(BeNil())
+			})
+
+			It("should default the burst to the rate", func() {
+				Expect(newTokenBucket(50, 0).burst).To(Equal(float64(50)))
+				Expect(newTokenBucket(0.5, 0).burst).To(Equal(float64(1)))
+			})
+
+			It("should allow bursts and then wait for the rate", func() {
+				b := newTokenBucket(10, 2)
+				now := b.last
+				Expect(b.reserve(now)).To(BeZero())
+				Expect(b.reserve(now)).To(BeZero())
+				Expect(b.reserve(now)).To(Equal(100 * time.Millisecond))
+				Expect(b.reserve(now)).To(Equal(200 * time.Millisecond))
+			})
+
+			It("should refill up to the burst", func() {
+				b := newTokenBucket(10, 2)
+				now := b.last
+				b.reserve(now)
+				b.reserve(now)
+				Expect(b.reserve(now.Add(100 * time.Millisecond))).To(BeZero())
+				Expect(b.reserve(now.Add(time.Hour))).To(BeZero())
+				Expect(b.tokens).To(Equal(float64(1)))
+			})
+		})
+
+		Describe("Rate limiter", func() {
+			var config *viper.Viper
+
+			BeforeEach(func() {
+				config = viper.New()
+			})
+
+			It("should not limit without rates", func() {
+				r := newRateLimiter(config, []string{"apns"}, []string{"game"})
+				for i := 0; i < 100; i++ {
+					wait, _ := r.reserve("game", "apns")
+					Expect(wait).To(BeZero())
+				}
+			})
+
+			It("should return the longest wait and its limit", func() {
+				config.Set("rateLimit.global.rate", 100)
+				config.Set("rateLimit.global.burst", 1)
+				config.Set("rateLimit.platforms.apns.rate", 10)
+				config.Set("rateLimit.platforms.apns.burst", 1)
+				config.Set("rateLimit.games.game.rate", 1)
+				config.Set("rateLimit.games.game.burst", 1)
+				r := newRateLimiter(config, []string{"apns", "gcm"}, []string{"game", "other"})
+
+				wait, _ := r.reserve("game", "apns")
+				Expect(wait).To(BeZero())
+				wait, limit := r.reserve("game", "apns")
+				Expect(wait).To(BeNumerically("~", time.Second, 10*time.Millisecond))
+				Expect(limit).To(Equal("game"))
+				wait, limit = r.reserve("other", "apns")
+				Expect(wait).To(BeNumerically("~", 200*time.Millisecond, 10*time.Millisecond))
+				Expect(limit).To(Equal("platform"))
+				wait, limit = r.reserve("other", "gcm")
+				Expect(wait).To(BeNumerically("~", 30*time.Millisecond, 10*time.Millisecond))
+				Expect(limit).To(Equal("global"))
+			})
+		})
+	})
+})
@@ -0,0 +1,198 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package pusher
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/topfreegames/pusher/extensions"
+	"github.com/topfreegames/pusher/util"
+)
+
+// appRegistry keeps the message handlers of the pusher in sync with its apps,
+// the ones in <platform>.apps and, if registry.source is pg, the ones in the
+// apps table, which override the credentials of the config
+type appRegistry struct {
+	pusher      *Pusher
+	platforms   []string
+	store       *extensions.AppStore
+	running     map[string]map[string]string
+	interval    time.Duration
+	stopChannel chan struct{}
+	logger      *logrus.Logger
+}
+
+func newAppRegistry(
+	pusher *Pusher,
+	platforms []string,
+	store *extensions.AppStore,
+	interval time.Duration,
+) *appRegistry {
+	r := &appRegistry{
+		pusher:      pusher,
+		platforms:   platforms,
+		store:       store,
+		running:     map[string]map[string]string{},
+		interval:    interval,
+		stopChannel: make(chan struct{}),
+		logger:      pusher.Logger,
+	}
+	for _, platform := range platforms {
+		r.running[platform] = map[string]string{}
+	}
+	return r
+}
+
+// load returns the apps of the platform by game, and false if the apps table
+// could not be read
+func (r *appRegistry) load(platform string) (map[string]*extensions.App, bool) {
+	apps := map[string]*extensions.App{}
+	for _, game := range strings.Split(r.pusher.Config.GetString(platform+".apps"), ",") {
+		if game = strings.TrimSpace(game); game != "" {
+			apps[game] = &extensions.App{Game: game, Platform: platform}
+		}
+	}
+	if r.store == nil {
+		return apps, true
+	}
+	stored, err := r.store.List(platform)
+	if err != nil {
+		r.logger.WithFields(logrus.Fields{
+			"method":   "load",
+			"platform": platform,
+		}).WithError(err).Error("error listing apps")
+		return apps, false
+	}
+	for _, app := range stored {
+		apps[app.Game] = app
+	}
+	return apps, true
+}
+
+// fingerprint identifies the credentials of the app, so the app is updated
+// when they change
+func fingerprint(app *extensions.App) string {
+	b, _ := json.Marshal(app.Credentials)
+	return string(b)
+}
+
+// sync starts the handlers of the new apps and of the ones that failed to
+// initialize before, replaces the handlers of the apps whose credentials
+// changed and removes the ones of the apps that are gone. It returns how many
+// apps are running
+func (r *appRegistry) sync() int {
+	count := 0
+	for _, platform := range r.platforms {
+		apps, complete := r.load(platform)
+		running := r.running[platform]
+		for game, app := range apps {
+			f := fingerprint(app)
+			if current, ok := running[game]; ok && current == f && app.Err == nil {
+				continue
+			}
+			// an app that fails to update keeps its previous handler
+			if err := r.start(app); err == nil {
+				running[game] = f
+			}
+		}
+		// the apps that could not be listed keep running
+		if complete {
+			for game := range running {
+				if _, ok := apps[game]; !ok {
+					r.logger.WithFields(logrus.Fields{
+						"method":   "sync",
+						"platform": platform,
+						"game":     game,
+					}).Info("removing app")
+					r.pusher.removeHandler(platform, game)
+					delete(running, game)
+				}
+			}
+		}
+		count += len(running)
+	}
+	return count
+}
+
+// start replaces the handler of the app. The handler gets a copy of the config
+// with the credentials of the app, so the config is never changed while others
+// read it and the credentials removed from the app fall back to the config
+func (r *appRegistry) start(app *extensions.App) error {
+	l := r.logger.WithFields(logrus.Fields{
+		"method":   "start",
+		"platform": app.Platform,
+		"game":     app.Game,
+	})
+	if app.Err != nil {
+		l.WithError(app.Err).Error("skipping app with invalid credentials")
+		r.initializeFailure(app)
+		return app.Err
+	}
+	rule, err := r.pusher.quietHoursRule(app.Game)
+	if err != nil {
+		l.WithError(err).Error("failed to configure quiet hours")
+		r.initializeFailure(app)
+		return err
+	}
+	config := util.CopyConfig(r.pusher.Config)
+	for key, value := range app.Credentials {
+		config.Set(fmt.Sprintf("%s.certs.%s.%s", app.Platform, app.Game, key), value)
+	}
+	handler, err := r.pusher.newHandler(app.Platform, app.Game, config)
+	if err != nil {
+		return err
+	}
+	// the quiet hours are only set for the apps that start
+	r.pusher.quietHours.set(app.Game, rule)
+	r.pusher.setHandler(app.Platform, app.Game, handler)
+	return nil
+}
+
+func (r *appRegistry) initializeFailure(app *extensions.App) {
+	for _, statsReporter := range r.pusher.StatsReporters {
+		statsReporter.InitializeFailure(app.Game, app.Platform)
+	}
+}
+
+// run syncs the apps every interval, retrying the ones that failed, until
+// stop is called
+func (r *appRegistry) run() {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.sync()
+		case <-r.stopChannel:
+			return
+		}
+	}
+}
+
+func (r *appRegistry) stop() {
+	close(r.stopChannel)
+}
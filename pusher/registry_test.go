@@ -0,0 +1,307 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package pusher
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/pusher/extensions"
+	"github.com/topfreegames/pusher/interfaces"
+	"github.com/topfreegames/pusher/mocks"
+	. "github.com/topfreegames/pusher/testing"
+	"github.com/topfreegames/pusher/util"
+)
+
+type appRow struct {
+	Game        string
+	Platform    string
+	Credentials string
+}
+
+var _ = Describe("App Registry", func() {
+	var config *viper.Viper
+	var mockDb *mocks.PGMock
+	var mockStatsDClient *mocks.StatsDClientMock
+	logger, hook := test.NewNullLogger()
+
+	BeforeEach(func() {
+		var err error
+		config, err = util.NewViperWithConfigFile("../config/test.yaml")
+		Expect(err).NotTo(HaveOccurred())
+		mockDb = mocks.NewPGMock(0, 1)
+		mockStatsDClient = mocks.NewStatsDClientMock()
+		hook.Reset()
+	})
+
+	newPusher := func() (*AllPusher, error) {
+		return NewAllPusher(false, config, logger, mockStatsDClient, mockDb, mocks.NewGCMClientMock())
+	}
+
+	Describe("[Unit]", func() {
+		Describe("Apps from the config", func() {
+			It("should retry apps that failed to initialize", func() {
+				config.Set("apns.apps", "game,other")
+				pusher, err := newPusher()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(pusher.MessageHandlers[APNSPlatform]).To(HaveLen(1))
+				Expect(mockStatsDClient.Counts["initialize_failure"]).To(Equal(int64(1)))
+
+				config.Set("apns.certs.other", config.Get("apns.certs.game"))
+				Expect(pusher.registry.sync()).To(Equal(3))
+				Expect(pusher.MessageHandlers[APNSPlatform]).To(HaveKey("other"))
+			})
+
+			It("should not recreate apps that didn't change", func() {
+				pusher, err := newPusher()
+				Expect(err).NotTo(HaveOccurred())
+				handler := pusher.MessageHandlers[GCMPlatform]["game"]
+
+				pusher.registry.sync()
+				Expect(pusher.MessageHandlers[GCMPlatform]["game"]).To(BeIdenticalTo(handler))
+			})
+
+			It("should remove apps that are gone", func() {
+				pusher, err := newPusher()
+				Expect(err).NotTo(HaveOccurred())
+				pusher.GracefulShutdownTimeout = 0
+				pusher.configureHandlerQueues()
+
+				config.Set("gcm.apps", "")
+				Expect(pusher.registry.sync()).To(Equal(1))
+				Expect(pusher.MessageHandlers[GCMPlatform]).To(BeEmpty())
+				Expect(pusher.handlerQueues[GCMPlatform]).To(BeEmpty())
+				Expect(pusher.MessageHandlers[APNSPlatform]).To(HaveKey("game"))
+			})
+
+			It("should not set the quiet hours of apps that failed to initialize", func() {
+				config.Set("apns.apps", "game,other")
+				config.Set("quietHours.games.other.start", "22:00")
+				config.Set("quietHours.games.other.end", "08:00")
+				config.Set("quietHours.games.other.tz", "UTC")
+				config.Set("quietHours.games.other.action", "drop")
+				pusher, err := newPusher()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(pusher.MessageHandlers[APNSPlatform]).NotTo(HaveKey("other"))
+				Expect(pusher.quietHours.rules).NotTo(HaveKey("other"))
+			})
+
+			It("should remove the quiet hours and rate limit of the games that are gone", func() {
+				config.Set("quietHours.games.game.start", "22:00")
+				config.Set("quietHours.games.game.end", "08:00")
+				config.Set("quietHours.games.game.tz", "UTC")
+				config.Set("quietHours.games.game.action", "drop")
+				config.Set("rateLimit.games.game.rate", 10)
+				pusher, err := newPusher()
+				Expect(err).NotTo(HaveOccurred())
+				pusher.GracefulShutdownTimeout = 0
+				Expect(pusher.quietHours.rules).To(HaveKey("game"))
+				Expect(pusher.limiter.games).To(HaveKey("game"))
+
+				config.Set("gcm.apps", "")
+				pusher.registry.sync()
+				Expect(pusher.quietHours.rules).To(HaveKey("game"))
+				Expect(pusher.limiter.games).To(HaveKey("game"))
+
+				config.Set("apns.apps", "")
+				Expect(pusher.registry.sync()).To(BeZero())
+				Expect(pusher.quietHours.rules).NotTo(HaveKey("game"))
+				Expect(pusher.limiter.games).NotTo(HaveKey("game"))
+			})
+
+			It("should fail without any app", func() {
+				config.Set("apns.apps", "")
+				config.Set("gcm.apps", "")
+				_, err := newPusher()
+				Expect(err).To(HaveOccurred())
+			})
+
+			It("should fail with an unknown source", func() {
+				config.Set("registry.source", "file")
+				_, err := newPusher()
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		Describe("Apps from the apps table", func() {
+			var rows []appRow
+
+			BeforeEach(func() {
+				config.Set("registry.source", "pg")
+				config.Set("gcm.apps", "")
+				config.Set("apns.apps", "")
+				rows = []appRow{{Game: "pggame", Platform: GCMPlatform, Credentials: `{"apiKey": "key", "senderID": "123"}`}}
+				mockDb.QueryModel = func(model interface{}) {
+					m := model.(*[]struct {
+						Game        string
+						Platform    string
+						Credentials string
+					})
+					query := mockDb.Execs[len(mockDb.Execs)-1]
+					platform := query[2].([]interface{})[0]
+					for _, row := range rows {
+						if row.Platform == platform {
+							*m = append(*m, row)
+						}
+					}
+				}
+			})
+
+			It("should start the apps of the table with their credentials", func() {
+				pusher, err := newPusher()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(pusher.MessageHandlers[GCMPlatform]).To(HaveKey("pggame"))
+				handler := pusher.MessageHandlers[GCMPlatform]["pggame"].(*extensions.GCMMessageHandler)
+				Expect(handler.Config.GetString("gcm.certs.pggame.apiKey")).To(Equal("key"))
+				Expect(config.IsSet("gcm.certs.pggame.apiKey")).To(BeFalse())
+				Expect(mockDb.Execs[len(mockDb.Execs)-1][1]).To(ContainSubstring("FROM apps WHERE platform = ?0"))
+			})
+
+			It("should start without apps", func() {
+				rows = nil
+				pusher, err := newPusher()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(pusher.MessageHandlers[GCMPlatform]).To(BeEmpty())
+			})
+
+			It("should replace apps whose credentials changed", func() {
+				pusher, err := newPusher()
+				Expect(err).NotTo(HaveOccurred())
+				pusher.GracefulShutdownTimeout = 0
+				handler := pusher.MessageHandlers[GCMPlatform]["pggame"]
+
+				rows[0].Credentials = `{"apiKey": "other-key", "senderID": "123"}`
+				Expect(pusher.registry.sync()).To(Equal(1))
+				Expect(pusher.MessageHandlers[GCMPlatform]["pggame"]).NotTo(BeIdenticalTo(handler))
+				replaced := pusher.MessageHandlers[GCMPlatform]["pggame"].(*extensions.GCMMessageHandler)
+				Expect(replaced.Config.GetString("gcm.certs.pggame.apiKey")).To(Equal("other-key"))
+			})
+
+			It("should use the config for the credentials removed from the table", func() {
+				config.Set("gcm.certs.pggame.senderID", "456")
+				pusher, err := newPusher()
+				Expect(err).NotTo(HaveOccurred())
+				pusher.GracefulShutdownTimeout = 0
+				handler := pusher.MessageHandlers[GCMPlatform]["pggame"].(*extensions.GCMMessageHandler)
+				Expect(handler.Config.GetString("gcm.certs.pggame.senderID")).To(Equal("123"))
+
+				rows[0].Credentials = `{"apiKey": "key"}`
+				Expect(pusher.registry.sync()).To(Equal(1))
+				replaced := pusher.MessageHandlers[GCMPlatform]["pggame"].(*extensions.GCMMessageHandler)
+				Expect(replaced.Config.GetString("gcm.certs.pggame.senderID")).To(Equal("456"))
+			})
+
+			It("should skip the apps with invalid credentials", func() {
+				rows = append(rows, appRow{Game: "broken", Platform: GCMPlatform, Credentials: `{"apiKey": `})
+				pusher, err := newPusher()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(pusher.MessageHandlers[GCMPlatform]).To(HaveKey("pggame"))
+				Expect(pusher.MessageHandlers[GCMPlatform]).NotTo(HaveKey("broken"))
+				Expect(mockStatsDClient.Counts["initialize_failure"]).To(Equal(int64(1)))
+				Expect(hook.Entries).To(ContainLogMessage("skipping app with invalid credentials"))
+			})
+
+			It("should keep the handler of an app whose credentials became invalid", func() {
+				pusher, err := newPusher()
+				Expect(err).NotTo(HaveOccurred())
+				handler := pusher.MessageHandlers[GCMPlatform]["pggame"]
+
+				rows[0].Credentials = `{"apiKey": `
+				Expect(pusher.registry.sync()).To(Equal(1))
+				Expect(pusher.MessageHandlers[GCMPlatform]["pggame"]).To(BeIdenticalTo(handler))
+				Expect(mockStatsDClient.Counts["initialize_failure"]).To(Equal(int64(1)))
+			})
+
+			It("should keep the apps running if the table can't be read", func() {
+				pusher, err := newPusher()
+				Expect(err).NotTo(HaveOccurred())
+
+				mockDb.Error = errors.New("pg: connection refused")
+				Expect(pusher.registry.sync()).To(Equal(1))
+				Expect(pusher.MessageHandlers[GCMPlatform]).To(HaveKey("pggame"))
+			})
+
+			It("should remove apps deleted from the table", func() {
+				pusher, err := newPusher()
+				Expect(err).NotTo(HaveOccurred())
+				pusher.GracefulShutdownTimeout = 0
+
+				rows = nil
+				Expect(pusher.registry.sync()).To(BeZero())
+				Expect(pusher.MessageHandlers[GCMPlatform]).To(BeEmpty())
+			})
+		})
+
+		Describe("Replacing handlers", func() {
+			var pusher *AllPusher
+
+			BeforeEach(func() {
+				var err error
+				pusher, err = newPusher()
+				Expect(err).NotTo(HaveOccurred())
+				pusher.GracefulShutdownTimeout = 0
+			})
+
+			It("should handle the messages queued to the previous handler", func() {
+				previous := &blockingMessageHandler{release: make(chan struct{})}
+				pusher.MessageHandlers = map[string]map[string]interfaces.MessageHandler{
+					GCMPlatform: {"game": previous},
+				}
+				pusher.configureHandlerQueues()
+				message := interfaces.KafkaMessage{Game: "game", Platform: GCMPlatform}
+				pusher.route(message)
+				pusher.route(message)
+
+				handler := &blockingMessageHandler{release: make(chan struct{})}
+				close(handler.release)
+				pusher.setHandler(GCMPlatform, "game", handler)
+				pusher.route(message)
+				Eventually(handler.handledCount).Should(Equal(1))
+
+				close(previous.release)
+				Eventually(previous.handledCount).Should(Equal(2))
+				Expect(handler.handledCount()).To(Equal(1))
+			})
+
+			It("should keep handling the other games", func() {
+				other := &blockingMessageHandler{release: make(chan struct{})}
+				close(other.release)
+				pusher.MessageHandlers = map[string]map[string]interfaces.MessageHandler{
+					GCMPlatform: {
+						"game":  &blockingMessageHandler{release: make(chan struct{})},
+						"other": other,
+					},
+				}
+				pusher.configureHandlerQueues()
+
+				pusher.removeHandler(GCMPlatform, "game")
+				pusher.route(interfaces.KafkaMessage{Game: "other", Platform: GCMPlatform})
+				Eventually(other.handledCount).Should(Equal(1))
+				Expect(pusher.handlerQueues[GCMPlatform]).NotTo(HaveKey("game"))
+			})
+		})
+	})
+})
@@ -33,6 +33,7 @@ import (
 
 type statsReporterInitializer func(*viper.Viper, *logrus.Logger, interfaces.StatsDClient) (interfaces.StatsReporter, error)
 type feedbackReporterInitializer func(*viper.Viper, *logrus.Logger) (interfaces.FeedbackReporter, error)
+type upstreamReporterInitializer func(*viper.Viper, *logrus.Logger) (interfaces.UpstreamReporter, error)
 
 //AvailableStatsReporters contains functions to initialize all stats reporters
 var AvailableStatsReporters = map[string]statsReporterInitializer{
@@ -48,6 +49,13 @@ var AvailableFeedbackReporters = map[string]feedbackReporterInitializer{
 	},
 }
 
+//AvailableUpstreamReporters contains functions to initialize all upstream reporters
+var AvailableUpstreamReporters = map[string]upstreamReporterInitializer{
+	"kafka": func(config *viper.Viper, logger *logrus.Logger) (interfaces.UpstreamReporter, error) {
+		return extensions.NewKafkaUpstreamReporter(config, logger)
+	},
+}
+
 func configureStatsReporters(config *viper.Viper, logger *logrus.Logger, clientOrNil interfaces.StatsDClient) ([]interfaces.StatsReporter, error) {
 	reporters := []interfaces.StatsReporter{}
 	reporterNames := config.GetStringSlice("stats.reporters")
@@ -85,3 +93,22 @@ func configureFeedbackReporters(config *viper.Viper, logger *logrus.Logger) ([]i
 
 	return reporters, nil
 }
+
+func configureUpstreamReporters(config *viper.Viper, logger *logrus.Logger) ([]interfaces.UpstreamReporter, error) {
+	reporters := []interfaces.UpstreamReporter{}
+	reporterNames := config.GetStringSlice("upstream.reporters")
+	for _, reporterName := range reporterNames {
+		reporterFunc, ok := AvailableUpstreamReporters[reporterName]
+		if !ok {
+			return nil, fmt.Errorf("Failed to initialize %s. Upstream Reporter not available.", reporterName)
+		}
+
+		r, err := reporterFunc(config, logger)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to initialize %s. %s", reporterName, err.Error())
+		}
+		reporters = append(reporters, r)
+	}
+
+	return reporters, nil
+}
@@ -78,5 +78,23 @@ var _ = Describe("Reporters", func() {
 				Expect(handlers).To(BeNil())
 			})
 		})
+
+		Describe("Configuring upstream reporters", func() {
+			It("should return upstream reporter list", func() {
+				config.Set("upstream.reporters", []string{"kafka"})
+				handlers, err := configureUpstreamReporters(config, logger)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(handlers).To(HaveLen(1))
+				Expect(handlers[0]).NotTo(BeNil())
+			})
+
+			It("should return an error if upstream reporter is not available", func() {
+				config.Set("upstream.reporters", []string{"notAvailable"})
+				handlers, err := configureUpstreamReporters(config, logger)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal("Failed to initialize notAvailable. Upstream Reporter not available."))
+				Expect(handlers).To(BeNil())
+			})
+		})
 	})
 })
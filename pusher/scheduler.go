@@ -0,0 +1,256 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package pusher
+
+import (
+	"container/heap"
+	"encoding/json"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/topfreegames/pusher/extensions"
+	"github.com/topfreegames/pusher/interfaces"
+)
+
+// scheduledHeap indexes the scheduled messages by send_at
+type scheduledHeap []*extensions.ScheduledMessage
+
+func (h scheduledHeap) Len() int            { return len(h) }
+func (h scheduledHeap) Less(i, j int) bool  { return h[i].SendAt < h[j].SendAt }
+func (h scheduledHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *scheduledHeap) Push(x interface{}) { *h = append(*h, x.(*extensions.ScheduledMessage)) }
+func (h *scheduledHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	m := old[n-1]
+	*h = old[:n-1]
+	return m
+}
+
+// scheduler keeps the messages consumed before their send_at in a durable
+// store shared by the pushers, and releases them when they are due. The
+// messages scheduled by this pusher are indexed in memory to be released on
+// time, and the store is polled for the due messages of the games it serves,
+// e.g. scheduled before a restart or by a pusher that stopped. Each message is
+// released by the pusher that deletes it from the store
+type scheduler struct {
+	store          *extensions.ScheduledMessageStore
+	served         func() map[string][]string
+	pollInterval   time.Duration
+	batchSize      int
+	index          scheduledHeap
+	mutex          sync.Mutex
+	wake           chan struct{}
+	stopChannel    chan struct{}
+	release        func(interfaces.KafkaMessage)
+	statsReporters []interfaces.StatsReporter
+	logger         *logrus.Logger
+}
+
+// newScheduler returns a scheduler that polls the store for the due messages
+// of the games returned by served, by platform
+func newScheduler(
+	store *extensions.ScheduledMessageStore,
+	served func() map[string][]string,
+	pollInterval time.Duration,
+	batchSize int,
+	release func(interfaces.KafkaMessage),
+	statsReporters []interfaces.StatsReporter,
+	logger *logrus.Logger,
+) *scheduler {
+	return &scheduler{
+		store:          store,
+		served:         served,
+		pollInterval:   pollInterval,
+		batchSize:      batchSize,
+		wake:           make(chan struct{}, 1),
+		stopChannel:    make(chan struct{}),
+		release:        release,
+		statsReporters: statsReporters,
+		logger:         logger,
+	}
+}
+
+// sendAt returns the send_at of the message, in milliseconds
+func sendAt(message interfaces.KafkaMessage) int64 {
+	m := &struct {
+		SendAt int64 `json:"send_at"`
+	}{}
+	json.Unmarshal(message.Value, m)
+	return m.SendAt
+}
+
+func nowInMilliseconds() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+// claimStored releases the stored messages of the served games that are due
+func (s *scheduler) claimStored() {
+	for platform, games := range s.served() {
+		for {
+			messages, err := s.store.ClaimDue(platform, games, nowInMilliseconds(), s.batchSize)
+			if err != nil {
+				s.logger.WithFields(logrus.Fields{
+					"method":   "claimStored",
+					"platform": platform,
+				}).WithError(err).Error("error claiming scheduled messages")
+				break
+			}
+			for _, m := range messages {
+				s.releaseMessage(m)
+			}
+			if len(messages) < s.batchSize {
+				break
+			}
+		}
+	}
+}
+
+func (s *scheduler) releaseMessage(m *extensions.ScheduledMessage) {
+	s.release(interfaces.KafkaMessage{
+		Game:     m.Game,
+		Platform: m.Platform,
+		Topic:    m.Topic,
+		Value:    []byte(m.Value),
+	})
+}
+
+// schedule stores the message to be released at sendAt. A message that can't
+// be stored is still indexed, so it is only lost if pusher restarts first
+func (s *scheduler) schedule(message interfaces.KafkaMessage, sendAt int64) {
+	m := &extensions.ScheduledMessage{
+		ID:       uuid.NewV4().String(),
+		Game:     message.Game,
+		Platform: message.Platform,
+		Topic:    message.Topic,
+		Value:    string(message.Value),
+		SendAt:   sendAt,
+	}
+	err := s.store.Save(m)
+	if err != nil {
+		s.logger.WithFields(logrus.Fields{
+			"method": "schedule",
+			"game":   message.Game,
+		}).WithError(err).Error("error storing scheduled message, keeping it only in memory")
+		for _, statsReporter := range s.statsReporters {
+			statsReporter.ReportMetricCount("schedule_store_error", 1, message.Game, message.Platform)
+		}
+		m.ID = ""
+	}
+	for _, statsReporter := range s.statsReporters {
+		statsReporter.ReportMetricCount("scheduled", 1, message.Game, message.Platform)
+	}
+	s.mutex.Lock()
+	heap.Push(&s.index, m)
+	s.mutex.Unlock()
+	s.notify()
+}
+
+func (s *scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run releases the messages when they are due until stop is called
+func (s *scheduler) run() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+	s.claimStored()
+	for {
+		wait := s.releaseDue()
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		var due <-chan time.Time
+		if wait >= 0 {
+			timer.Reset(wait)
+			due = timer.C
+		}
+		select {
+		case <-s.wake:
+		case <-due:
+		case <-ticker.C:
+			s.claimStored()
+		case <-s.stopChannel:
+			return
+		}
+	}
+}
+
+// releaseDue releases the due messages of the index and returns how long
+// until the next one is due, or -1 if none is scheduled. Stored messages are
+// only released if they weren't claimed by a poll yet
+func (s *scheduler) releaseDue() time.Duration {
+	for {
+		now := nowInMilliseconds()
+		s.mutex.Lock()
+		if len(s.index) == 0 {
+			s.mutex.Unlock()
+			return -1
+		}
+		if next := s.index[0].SendAt; next > now {
+			s.mutex.Unlock()
+			return time.Duration(next-now) * time.Millisecond
+		}
+		m := heap.Pop(&s.index).(*extensions.ScheduledMessage)
+		s.mutex.Unlock()
+
+		if m.ID == "" {
+			s.releaseMessage(m)
+			continue
+		}
+		claimed, err := s.store.Claim(m.ID)
+		if err != nil {
+			// the message is still stored, so a later poll releases it
+			s.logger.WithFields(logrus.Fields{
+				"method": "releaseDue",
+				"id":     m.ID,
+			}).WithError(err).Error("error claiming scheduled message, leaving it to the next poll")
+			continue
+		}
+		if claimed {
+			s.releaseMessage(m)
+		}
+	}
+}
+
+// size returns the number of messages scheduled by this pusher that are
+// waiting
+func (s *scheduler) size() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.index)
+}
+
+func (s *scheduler) stop() {
+	close(s.stopChannel)
+}
@@ -0,0 +1,253 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package pusher
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/pusher/extensions"
+	"github.com/topfreegames/pusher/interfaces"
+	"github.com/topfreegames/pusher/mocks"
+	. "github.com/topfreegames/pusher/testing"
+	"github.com/topfreegames/pusher/util"
+)
+
+var _ = Describe("Scheduler", func() {
+	var config *viper.Viper
+	var mockDb *mocks.PGMock
+	var mockStatsDClient *mocks.StatsDClientMock
+	var s *scheduler
+	var released []interfaces.KafkaMessage
+	var served map[string][]string
+	var mutex sync.Mutex
+	logger, hook := test.NewNullLogger()
+
+	releasedMessages := func() []interfaces.KafkaMessage {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]interfaces.KafkaMessage{}, released...)
+	}
+
+	BeforeEach(func() {
+		var err error
+		config, err = util.NewViperWithConfigFile("../config/test.yaml")
+		Expect(err).NotTo(HaveOccurred())
+		mockDb = mocks.NewPGMock(0, 1)
+		mockStatsDClient = mocks.NewStatsDClientMock()
+		statsD, err := extensions.NewStatsD(config, logger, mockStatsDClient)
+		Expect(err).NotTo(HaveOccurred())
+		store, err := extensions.NewScheduledMessageStore(config, mockDb)
+		Expect(err).NotTo(HaveOccurred())
+		released = nil
+		served = map[string][]string{"gcm": {"game"}}
+		s = newScheduler(store, func() map[string][]string {
+			return served
+		}, 10*time.Millisecond, 2, func(message interfaces.KafkaMessage) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			released = append(released, message)
+		}, []interfaces.StatsReporter{statsD}, logger)
+		hook.Reset()
+	})
+
+	Describe("[Unit]", func() {
+		Describe("Send at", func() {
+			It("should return the send_at of the message", func() {
+				Expect(sendAt(interfaces.KafkaMessage{Value: []byte(`{"send_at": 1500000000000}`)})).To(Equal(int64(1500000000000)))
+			})
+
+			It("should return zero without send_at", func() {
+				Expect(sendAt(interfaces.KafkaMessage{Value: []byte(`{"to": "token"}`)})).To(BeZero())
+				Expect(sendAt(interfaces.KafkaMessage{Value: []byte(`not json`)})).To(BeZero())
+			})
+		})
+
+		Describe("Scheduling messages", func() {
+			It("should store the message and keep it until it is due", func() {
+				message := interfaces.KafkaMessage{
+					Game:     "game",
+					Platform: "gcm",
+					Topic:    "push-game_gcm",
+					Value:    []byte(`{"to": "token"}`),
+				}
+				s.schedule(message, nowInMilliseconds()+int64(time.Hour/time.Millisecond))
+
+				Expect(s.size()).To(Equal(1))
+				Expect(mockDb.Execs).To(HaveLen(2))
+				Expect(mockDb.Execs[1][0]).To(ContainSubstring("INSERT INTO scheduled_messages"))
+				Expect(mockStatsDClient.Counts["scheduled"]).To(Equal(int64(1)))
+				Expect(s.releaseDue()).To(BeNumerically(">", 59*time.Minute))
+				Expect(releasedMessages()).To(BeEmpty())
+			})
+
+			It("should release due messages in send_at order and delete them", func() {
+				mockDb.RowsAffected = 1
+				now := nowInMilliseconds()
+				for i := 3; i > 0; i-- {
+					s.schedule(interfaces.KafkaMessage{
+						Game:     "game",
+						Platform: "apns",
+						Value:    []byte(fmt.Sprintf(`{"send_at": %d}`, now-int64(i))),
+					}, now-int64(i))
+				}
+
+				Expect(s.releaseDue()).To(Equal(time.Duration(-1)))
+				Expect(s.size()).To(BeZero())
+				messages := releasedMessages()
+				Expect(messages).To(HaveLen(3))
+				for i, m := range messages {
+					Expect(sendAt(m)).To(Equal(now - int64(3-i)))
+					Expect(m.Game).To(Equal("game"))
+					Expect(m.Platform).To(Equal("apns"))
+				}
+				Expect(mockDb.Execs).To(HaveLen(7))
+				Expect(mockDb.Execs[6][0]).To(ContainSubstring("DELETE FROM scheduled_messages"))
+			})
+
+			It("should not release stored messages claimed by another pusher", func() {
+				s.schedule(interfaces.KafkaMessage{Game: "game", Platform: "gcm"}, nowInMilliseconds())
+				mockDb.RowsAffected = 0
+
+				Expect(s.releaseDue()).To(Equal(time.Duration(-1)))
+				Expect(releasedMessages()).To(BeEmpty())
+				Expect(mockDb.Execs[len(mockDb.Execs)-1][0]).To(ContainSubstring("DELETE FROM scheduled_messages WHERE id = ?0"))
+			})
+
+			It("should leave stored messages to the next poll if they can't be claimed", func() {
+				s.schedule(interfaces.KafkaMessage{Game: "game", Platform: "gcm"}, nowInMilliseconds())
+				mockDb.Error = errors.New("pg: connection refused")
+
+				Expect(s.releaseDue()).To(Equal(time.Duration(-1)))
+				Expect(releasedMessages()).To(BeEmpty())
+				Expect(hook.Entries).To(ContainLogMessage("error claiming scheduled message, leaving it to the next poll"))
+			})
+
+			It("should keep the message in memory if it can't be stored", func() {
+				mockDb.Error = errors.New("pg: connection refused")
+				s.schedule(interfaces.KafkaMessage{Game: "game", Platform: "gcm"}, nowInMilliseconds())
+
+				Expect(s.size()).To(Equal(1))
+				Expect(mockStatsDClient.Counts["schedule_store_error"]).To(Equal(int64(1)))
+				Expect(s.index[0].ID).To(BeEmpty())
+
+				execs := len(mockDb.Execs)
+				s.releaseDue()
+				Expect(releasedMessages()).To(HaveLen(1))
+				Expect(mockDb.Execs).To(HaveLen(execs))
+			})
+
+			It("should release messages when they are due", func() {
+				mockDb.RowsAffected = 1
+				go s.run()
+				defer s.stop()
+				s.schedule(interfaces.KafkaMessage{Game: "game", Platform: "gcm"}, nowInMilliseconds()+50)
+
+				Consistently(releasedMessages, 20*time.Millisecond).Should(BeEmpty())
+				Eventually(releasedMessages).Should(HaveLen(1))
+			})
+		})
+
+		Describe("Claiming stored messages", func() {
+			It("should release the due messages of the served games", func() {
+				now := nowInMilliseconds()
+				mockDb.QueryModel = func(model interface{}) {
+					messages := model.(*[]*extensions.ScheduledMessage)
+					*messages = append(*messages,
+						&extensions.ScheduledMessage{ID: "id1", Game: "game", Platform: "gcm", SendAt: now - 1000},
+					)
+				}
+				s.claimStored()
+
+				Expect(releasedMessages()).To(HaveLen(1))
+				Expect(releasedMessages()[0].Game).To(Equal("game"))
+				query := mockDb.Execs[len(mockDb.Execs)-1]
+				Expect(query[1]).To(ContainSubstring("DELETE FROM scheduled_messages WHERE id IN"))
+				Expect(query[1]).To(ContainSubstring("platform = ?0 AND game = ANY(?1) AND send_at <= ?2"))
+				Expect(query[1]).To(ContainSubstring("FOR UPDATE SKIP LOCKED"))
+				params := query[2].([]interface{})
+				Expect(params[0]).To(Equal("gcm"))
+				Expect(params[2]).To(BeNumerically(">=", now))
+				Expect(params[3]).To(Equal(2))
+			})
+
+			It("should claim again while the batches are full", func() {
+				queries := 0
+				mockDb.QueryModel = func(model interface{}) {
+					queries++
+					if queries > 2 {
+						return
+					}
+					messages := model.(*[]*extensions.ScheduledMessage)
+					*messages = append(*messages,
+						&extensions.ScheduledMessage{ID: "id1", Game: "game", Platform: "gcm"},
+						&extensions.ScheduledMessage{ID: "id2", Game: "game", Platform: "gcm"},
+					)
+				}
+				s.claimStored()
+
+				Expect(queries).To(Equal(3))
+				Expect(releasedMessages()).To(HaveLen(4))
+			})
+
+			It("should not claim messages without served games", func() {
+				served = map[string][]string{"apns": {}}
+				execs := len(mockDb.Execs)
+				s.claimStored()
+
+				Expect(mockDb.Execs).To(HaveLen(execs))
+			})
+
+			It("should log if the messages can't be claimed", func() {
+				mockDb.Error = errors.New("pg: connection refused")
+				s.claimStored()
+
+				Expect(releasedMessages()).To(BeEmpty())
+				Expect(hook.Entries).To(ContainLogMessage("error claiming scheduled messages"))
+			})
+
+			It("should poll the store while running", func() {
+				polls := 0
+				mockDb.QueryModel = func(model interface{}) {
+					mutex.Lock()
+					defer mutex.Unlock()
+					polls++
+				}
+				go s.run()
+				defer s.stop()
+
+				Eventually(func() int {
+					mutex.Lock()
+					defer mutex.Unlock()
+					return polls
+				}).Should(BeNumerically(">", 2))
+			})
+		})
+	})
+})
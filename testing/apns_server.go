@@ -0,0 +1,189 @@
+/*
+ * Copyright (c) 2026 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package testing
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"golang.org/x/net/http2"
+)
+
+// APNSResponse is a scripted response of the fake APNS server
+type APNSResponse struct {
+	StatusCode int
+	Reason     string
+	// Latency delays the response
+	Latency time.Duration
+	// DropConnection resets the request instead of responding
+	DropConnection bool
+}
+
+// APNSRequest is a request received by the fake APNS server
+type APNSRequest struct {
+	DeviceToken string
+	ApnsID      string
+	Topic       string
+	PushType    string
+	Priority    string
+	Expiration  string
+	CollapseID  string
+	Payload     []byte
+	Header      http.Header
+}
+
+// APNSServer is a fake APNS server speaking HTTP/2 over TLS. Set apns.host to
+// its URL and apns.insecureSkipVerify to true to send pushes to it
+type APNSServer struct {
+	URL       string
+	server    *httptest.Server
+	mutex     sync.Mutex
+	responses map[string][]*APNSResponse
+	requests  []*APNSRequest
+}
+
+// NewAPNSServer starts a fake APNS server using the certificate and key in
+// certFile, e.g. tls/self_signed_cert.pem
+func NewAPNSServer(certFile string) (*APNSServer, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, certFile)
+	if err != nil {
+		return nil, err
+	}
+	s := &APNSServer{
+		responses: map[string][]*APNSResponse{},
+	}
+	s.server = httptest.NewUnstartedServer(http.HandlerFunc(s.handle))
+	s.server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{http2.NextProtoTLS},
+	}
+	if err := http2.ConfigureServer(s.server.Config, nil); err != nil {
+		return nil, err
+	}
+	s.server.StartTLS()
+	s.URL = s.server.URL
+	return s, nil
+}
+
+// ScriptResponses sets the responses for a device token. They are used in
+// order and the last one is repeated. Tokens without responses get a 200
+func (s *APNSServer) ScriptResponses(deviceToken string, responses ...*APNSResponse) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.responses[deviceToken] = responses
+}
+
+// Requests returns the requests received so far
+func (s *APNSServer) Requests() []*APNSRequest {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]*APNSRequest{}, s.requests...)
+}
+
+// Reset forgets the scripted responses and the received requests
+func (s *APNSServer) Reset() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.responses = map[string][]*APNSResponse{}
+	s.requests = nil
+}
+
+// Close stops the server
+func (s *APNSServer) Close() {
+	s.server.Close()
+}
+
+func (s *APNSServer) nextResponse(deviceToken string) *APNSResponse {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	responses := s.responses[deviceToken]
+	if len(responses) == 0 {
+		return &APNSResponse{StatusCode: http.StatusOK}
+	}
+	if len(responses) > 1 {
+		s.responses[deviceToken] = responses[1:]
+	}
+	return responses[0]
+}
+
+func (s *APNSServer) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeAPNSError(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, "/3/device/") {
+		writeAPNSError(w, http.StatusNotFound, "BadPath")
+		return
+	}
+	payload, _ := ioutil.ReadAll(r.Body)
+	req := &APNSRequest{
+		DeviceToken: strings.TrimPrefix(r.URL.Path, "/3/device/"),
+		ApnsID:      r.Header.Get("apns-id"),
+		Topic:       r.Header.Get("apns-topic"),
+		PushType:    r.Header.Get("apns-push-type"),
+		Priority:    r.Header.Get("apns-priority"),
+		Expiration:  r.Header.Get("apns-expiration"),
+		CollapseID:  r.Header.Get("apns-collapse-id"),
+		Payload:     payload,
+		Header:      r.Header,
+	}
+	if req.ApnsID == "" {
+		req.ApnsID = uuid.NewV4().String()
+	}
+	s.mutex.Lock()
+	s.requests = append(s.requests, req)
+	s.mutex.Unlock()
+
+	res := s.nextResponse(req.DeviceToken)
+	if res.Latency > 0 {
+		time.Sleep(res.Latency)
+	}
+	if res.DropConnection {
+		// makes the server reset the stream without a response
+		panic(http.ErrAbortHandler)
+	}
+	w.Header().Set("apns-id", req.ApnsID)
+	if res.StatusCode == http.StatusOK || res.StatusCode == 0 {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	writeAPNSError(w, res.StatusCode, res.Reason)
+}
+
+func writeAPNSError(w http.ResponseWriter, statusCode int, reason string) {
+	body := map[string]interface{}{"reason": reason}
+	if statusCode == http.StatusGone {
+		// unregistered tokens come with the time they became invalid
+		body["timestamp"] = time.Now().UnixNano() / int64(time.Millisecond)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,241 @@
+/*
+ * Copyright (c) 2026 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package testing
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+)
+
+// DeviceGroupServer is a fake FCM server keeping device groups in memory and
+// answering messages sent to them. Set gcm.deviceGroups.host to its URL to
+// manage and send to device groups with it
+type DeviceGroupServer struct {
+	URL            string
+	server         *httptest.Server
+	mutex          sync.Mutex
+	groups         map[string]map[string]bool
+	keys           map[string]string
+	failingTokens  map[string]bool
+	messages       []map[string]interface{}
+	authorizations []string
+}
+
+// NewDeviceGroupServer starts a fake device group server
+func NewDeviceGroupServer() *DeviceGroupServer {
+	s := &DeviceGroupServer{}
+	s.Reset()
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	s.URL = s.server.URL
+	return s
+}
+
+// SetFailingToken makes the messages sent to the groups of the token fail
+// for it
+func (s *DeviceGroupServer) SetFailingToken(token string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.failingTokens[token] = true
+}
+
+// Tokens returns the sorted tokens of the device group
+func (s *DeviceGroupServer) Tokens(name string) []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	tokens := []string{}
+	for token := range s.groups[name] {
+		tokens = append(tokens, token)
+	}
+	sort.Strings(tokens)
+	return tokens
+}
+
+// NotificationKey returns the notification key of the device group, empty if
+// it doesn't exist
+func (s *DeviceGroupServer) NotificationKey(name string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.groups[name]; !ok {
+		return ""
+	}
+	return s.keys[name]
+}
+
+// Messages returns the messages sent to device groups
+func (s *DeviceGroupServer) Messages() []map[string]interface{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]map[string]interface{}{}, s.messages...)
+}
+
+// Authorizations returns the Authorization headers of the requests received
+func (s *DeviceGroupServer) Authorizations() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string{}, s.authorizations...)
+}
+
+// Reset forgets the device groups, failing tokens and received requests
+func (s *DeviceGroupServer) Reset() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.groups = map[string]map[string]bool{}
+	s.keys = map[string]string{}
+	s.failingTokens = map[string]bool{}
+	s.messages = nil
+	s.authorizations = nil
+}
+
+// Close stops the server
+func (s *DeviceGroupServer) Close() {
+	s.server.Close()
+}
+
+func (s *DeviceGroupServer) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodGet {
+		writeDeviceGroupResponse(w, http.StatusMethodNotAllowed, map[string]interface{}{"error": "MethodNotAllowed"})
+		return
+	}
+	authorization := r.Header.Get("Authorization")
+	if authorization == "" || r.Header.Get("project_id") == "" {
+		writeDeviceGroupResponse(w, http.StatusUnauthorized, map[string]interface{}{"error": "Unauthorized"})
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.authorizations = append(s.authorizations, authorization)
+	switch {
+	case r.URL.Path == "/fcm/notification" && r.Method == http.MethodGet:
+		s.handleGetKey(w, r)
+	case r.URL.Path == "/fcm/notification":
+		s.handleOperation(w, r)
+	case r.URL.Path == "/fcm/send":
+		s.handleSend(w, r)
+	default:
+		writeDeviceGroupResponse(w, http.StatusNotFound, map[string]interface{}{"error": "NotFound"})
+	}
+}
+
+func (s *DeviceGroupServer) handleGetKey(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("notification_key_name")
+	if _, ok := s.groups[name]; !ok {
+		writeDeviceGroupResponse(w, http.StatusBadRequest, map[string]interface{}{"error": "notification_key not found"})
+		return
+	}
+	writeDeviceGroupResponse(w, http.StatusOK, map[string]interface{}{"notification_key": s.keys[name]})
+}
+
+func (s *DeviceGroupServer) handleOperation(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		Operation           string   `json:"operation"`
+		NotificationKeyName string   `json:"notification_key_name"`
+		NotificationKey     string   `json:"notification_key"`
+		RegistrationIDs     []string `json:"registration_ids"`
+	}{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.NotificationKeyName == "" || len(req.RegistrationIDs) == 0 {
+		writeDeviceGroupResponse(w, http.StatusBadRequest, map[string]interface{}{"error": "InvalidParameters"})
+		return
+	}
+	name := req.NotificationKeyName
+	group, exists := s.groups[name]
+	switch req.Operation {
+	case "create":
+		if exists {
+			writeDeviceGroupResponse(w, http.StatusBadRequest, map[string]interface{}{"error": "notification_key already exists"})
+			return
+		}
+		group = map[string]bool{}
+		s.groups[name] = group
+		if _, ok := s.keys[name]; !ok {
+			s.keys[name] = fmt.Sprintf("notification-key-%s", name)
+		}
+	case "add", "remove":
+		if !exists || req.NotificationKey != s.keys[name] {
+			writeDeviceGroupResponse(w, http.StatusBadRequest, map[string]interface{}{"error": "notification_key not found"})
+			return
+		}
+	default:
+		writeDeviceGroupResponse(w, http.StatusBadRequest, map[string]interface{}{"error": "InvalidParameters"})
+		return
+	}
+	for _, token := range req.RegistrationIDs {
+		if req.Operation == "remove" {
+			delete(group, token)
+		} else {
+			group[token] = true
+		}
+	}
+	// groups without tokens are deleted
+	if len(group) == 0 {
+		delete(s.groups, name)
+	}
+	writeDeviceGroupResponse(w, http.StatusOK, map[string]interface{}{"notification_key": s.keys[name]})
+}
+
+func (s *DeviceGroupServer) handleSend(w http.ResponseWriter, r *http.Request) {
+	msg := map[string]interface{}{}
+	err := json.NewDecoder(r.Body).Decode(&msg)
+	if err != nil {
+		writeDeviceGroupResponse(w, http.StatusBadRequest, map[string]interface{}{"error": "InvalidParameters"})
+		return
+	}
+	s.messages = append(s.messages, msg)
+	var group map[string]bool
+	for name, key := range s.keys {
+		if key == msg["to"] {
+			group = s.groups[name]
+		}
+	}
+	if group == nil {
+		writeDeviceGroupResponse(w, http.StatusOK, map[string]interface{}{
+			"success": 0, "failure": 1, "results": []map[string]string{{"error": "NotRegistered"}},
+		})
+		return
+	}
+	success := 0
+	failed := []string{}
+	for token := range group {
+		if s.failingTokens[token] {
+			failed = append(failed, token)
+		} else {
+			success++
+		}
+	}
+	sort.Strings(failed)
+	res := map[string]interface{}{"success": success, "failure": len(failed)}
+	if len(failed) > 0 {
+		res["failed_registration_ids"] = failed
+	}
+	writeDeviceGroupResponse(w, http.StatusOK, res)
+}
+
+func writeDeviceGroupResponse(w http.ResponseWriter, statusCode int, body map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,505 @@
+/*
+ * Copyright (c) 2026 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package testing
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-xmpp"
+)
+
+const (
+	gcmNSStream  = "http://etherx.jabber.org/streams"
+	gcmNSSASL    = "urn:ietf:params:xml:ns:xmpp-sasl"
+	gcmNSBind    = "urn:ietf:params:xml:ns:xmpp-bind"
+	gcmNSSession = "urn:ietf:params:xml:ns:xmpp-session"
+	gcmNSData    = "google:mobile:data"
+)
+
+// GCMResponse is a scripted response of the fake CCS server
+type GCMResponse struct {
+	// Error nacks the message with the error, e.g. DEVICE_UNREGISTERED
+	Error            string
+	ErrorDescription string
+	// RegistrationID is acked as the canonical registration id of the token
+	RegistrationID string
+	// Receipt sends a delivery receipt after the ack
+	Receipt bool
+	// Latency delays the response
+	Latency time.Duration
+	// Drop doesn't answer the message
+	Drop bool
+}
+
+// GCMMessage is a message received by the fake CCS server
+type GCMMessage struct {
+	To        string
+	MessageID string
+	Payload   map[string]interface{}
+}
+
+// GCMServer is a fake GCM CCS server speaking XMPP over TLS. The go-gcm
+// clients connect to it while routed with Route
+type GCMServer struct {
+	Addr         string
+	listener     net.Listener
+	tlsConfig    *tls.Config
+	senderID     string
+	apiKey       string
+	mutex        sync.Mutex
+	responses    map[string][]*GCMResponse
+	messages     []*GCMMessage
+	acks         []string
+	connections  map[*gcmServerConnection]bool
+	authFailures int
+	ignorePings  bool
+}
+
+// bufferedConn reads the bytes already buffered by the server before the
+// TLS handshake
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+type gcmServerConnection struct {
+	conn      net.Conn
+	decoder   *xml.Decoder
+	reader    *bufio.Reader
+	writeLock sync.Mutex
+}
+
+func (c *gcmServerConnection) write(format string, args ...interface{}) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	_, err := fmt.Fprintf(c.conn, format, args...)
+	return err
+}
+
+func (c *gcmServerConnection) writeCCS(payload map[string]interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	var escaped bytes.Buffer
+	xml.EscapeText(&escaped, body)
+	return c.write("<message><gcm xmlns='%s'>%s</gcm></message>", gcmNSData, escaped.String())
+}
+
+func (c *gcmServerConnection) nextElement() (*xml.StartElement, error) {
+	for {
+		t, err := c.decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch e := t.(type) {
+		case xml.StartElement:
+			return &e, nil
+		case xml.EndElement:
+			if e.Name.Space == gcmNSStream && e.Name.Local == "stream" {
+				return nil, io.EOF
+			}
+		}
+	}
+}
+
+// openStream waits for the client stream and answers it with the features
+func (c *gcmServerConnection) openStream(features string) error {
+	c.decoder = xml.NewDecoder(c.reader)
+	e, err := c.nextElement()
+	if err != nil {
+		return err
+	}
+	if e.Name.Local != "stream" {
+		return fmt.Errorf("expected stream, got %s", e.Name.Local)
+	}
+	return c.write(
+		"<?xml version='1.0'?><stream:stream from='fcm.googleapis.com' id='%d' xmlns='jabber:client' "+
+			"xmlns:stream='%s' version='1.0'><stream:features>%s</stream:features>",
+		time.Now().UnixNano(), gcmNSStream, features,
+	)
+}
+
+// NewGCMServer starts a fake CCS server accepting the sender id and api key,
+// using the certificate and key in certFile, e.g. tls/self_signed_cert.pem
+func NewGCMServer(certFile, senderID, apiKey string) (*GCMServer, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, certFile)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &GCMServer{
+		Addr:     listener.Addr().String(),
+		listener: listener,
+		tlsConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+		},
+		senderID:    senderID,
+		apiKey:      apiKey,
+		connections: map[*gcmServerConnection]bool{},
+	}
+	s.Reset()
+	go s.accept()
+	return s, nil
+}
+
+// Route makes the go-gcm clients created until the returned func is called
+// connect to the server. go-gcm always dials Google, but its XMPP client
+// tunnels the connections through HTTP_PROXY, so the server answers the
+// CONNECT requests itself. The check of its certificate is skipped as well
+func (s *GCMServer) Route() func() {
+	proxy, hasProxy := os.LookupEnv("HTTP_PROXY")
+	insecureSkipVerify := xmpp.DefaultConfig.InsecureSkipVerify
+	os.Setenv("HTTP_PROXY", "http://"+s.Addr)
+	xmpp.DefaultConfig.InsecureSkipVerify = true
+	return func() {
+		if hasProxy {
+			os.Setenv("HTTP_PROXY", proxy)
+		} else {
+			os.Unsetenv("HTTP_PROXY")
+		}
+		xmpp.DefaultConfig.InsecureSkipVerify = insecureSkipVerify
+	}
+}
+
+// ScriptResponses sets the responses for a token. They are used in order and
+// the last one is repeated. Tokens without responses are acked
+func (s *GCMServer) ScriptResponses(token string, responses ...*GCMResponse) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.responses[token] = responses
+}
+
+// Messages returns the messages received so far
+func (s *GCMServer) Messages() []*GCMMessage {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]*GCMMessage{}, s.messages...)
+}
+
+// Acks returns the ids of the upstream messages and receipts acked by clients
+func (s *GCMServer) Acks() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string{}, s.acks...)
+}
+
+// Connections returns the number of open authenticated connections
+func (s *GCMServer) Connections() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.connections)
+}
+
+// AuthFailures returns the number of connections rejected for bad credentials
+func (s *GCMServer) AuthFailures() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.authFailures
+}
+
+// SetIgnorePings makes the server stop answering pings, so clients time out
+func (s *GCMServer) SetIgnorePings(ignore bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.ignorePings = ignore
+}
+
+// SendDraining sends a CONNECTION_DRAINING control message to all connections
+func (s *GCMServer) SendDraining() {
+	s.broadcast(map[string]interface{}{
+		"message_type": "control",
+		"control_type": "CONNECTION_DRAINING",
+	})
+}
+
+// SendUpstream sends an upstream message from the token to all connections
+func (s *GCMServer) SendUpstream(from, messageID, category string, data map[string]interface{}) {
+	s.broadcast(map[string]interface{}{
+		"from":       from,
+		"message_id": messageID,
+		"category":   category,
+		"data":       data,
+	})
+}
+
+func (s *GCMServer) broadcast(payload map[string]interface{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for c := range s.connections {
+		c.writeCCS(payload)
+	}
+}
+
+// Disconnect closes all connections without closing the streams
+func (s *GCMServer) Disconnect() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for c := range s.connections {
+		c.conn.Close()
+	}
+}
+
+// Reset forgets the scripted responses and the received messages
+func (s *GCMServer) Reset() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.responses = map[string][]*GCMResponse{}
+	s.messages = nil
+	s.acks = nil
+	s.authFailures = 0
+	s.ignorePings = false
+}
+
+// Close stops the server and closes all connections
+func (s *GCMServer) Close() {
+	s.listener.Close()
+	s.Disconnect()
+}
+
+func (s *GCMServer) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.serve(conn)
+	}
+}
+
+func (s *GCMServer) serve(conn net.Conn) {
+	defer conn.Close()
+	conn, err := s.handshake(conn)
+	if err != nil {
+		return
+	}
+	c := &gcmServerConnection{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+	}
+	defer func() {
+		s.mutex.Lock()
+		delete(s.connections, c)
+		s.mutex.Unlock()
+		conn.Close()
+	}()
+	if !s.authenticate(c) {
+		return
+	}
+	err = c.openStream(fmt.Sprintf("<bind xmlns='%s'/><session xmlns='%s'/>", gcmNSBind, gcmNSSession))
+	if err != nil {
+		return
+	}
+	s.mutex.Lock()
+	s.connections[c] = true
+	s.mutex.Unlock()
+	for {
+		e, err := c.nextElement()
+		if err != nil {
+			return
+		}
+		switch e.Name.Local {
+		case "iq":
+			s.handleIQ(c, e)
+		case "message":
+			m := &struct {
+				Data string `xml:"google:mobile:data gcm"`
+			}{}
+			if c.decoder.DecodeElement(m, e) != nil {
+				return
+			}
+			s.handleMessage(c, m.Data)
+		default:
+			c.decoder.Skip()
+		}
+	}
+}
+
+// handshake answers the CONNECT request of clients routed through the server
+// as a proxy and starts TLS
+func (s *GCMServer) handshake(conn net.Conn) (net.Conn, error) {
+	reader := bufio.NewReader(conn)
+	start, err := reader.Peek(len("CONNECT"))
+	if err != nil {
+		return nil, err
+	}
+	if string(start) == "CONNECT" {
+		req, err := http.ReadRequest(reader)
+		if err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		if _, err := fmt.Fprint(conn, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+			return nil, err
+		}
+	}
+	tlsConn := tls.Server(&bufferedConn{Conn: conn, reader: reader}, s.tlsConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+func (s *GCMServer) authenticate(c *gcmServerConnection) bool {
+	err := c.openStream(fmt.Sprintf("<mechanisms xmlns='%s'><mechanism>PLAIN</mechanism></mechanisms>", gcmNSSASL))
+	if err != nil {
+		return false
+	}
+	e, err := c.nextElement()
+	if err != nil || e.Name.Local != "auth" {
+		return false
+	}
+	auth := &struct {
+		Mechanism string `xml:"mechanism,attr"`
+		Value     string `xml:",chardata"`
+	}{}
+	if c.decoder.DecodeElement(auth, e) != nil {
+		return false
+	}
+	credentials, _ := base64.StdEncoding.DecodeString(auth.Value)
+	parts := strings.Split(string(credentials), "\x00")
+	if auth.Mechanism != "PLAIN" || len(parts) != 3 ||
+		strings.Split(parts[1], "@")[0] != s.senderID || parts[2] != s.apiKey {
+		s.mutex.Lock()
+		s.authFailures++
+		s.mutex.Unlock()
+		c.write("<failure xmlns='%s'><not-authorized/></failure></stream:stream>", gcmNSSASL)
+		return false
+	}
+	return c.write("<success xmlns='%s'/>", gcmNSSASL) == nil
+}
+
+func (s *GCMServer) handleIQ(c *gcmServerConnection, e *xml.StartElement) {
+	iq := &struct {
+		ID   string    `xml:"id,attr"`
+		Type string    `xml:"type,attr"`
+		Bind *struct{} `xml:"urn:ietf:params:xml:ns:xmpp-bind bind"`
+		Ping *struct{} `xml:"urn:xmpp:ping ping"`
+	}{}
+	if c.decoder.DecodeElement(iq, e) != nil || iq.Type == "result" {
+		return
+	}
+	s.mutex.Lock()
+	ignorePings := s.ignorePings
+	s.mutex.Unlock()
+	switch {
+	case iq.Bind != nil:
+		c.write(
+			"<iq type='result' id='%s'><bind xmlns='%s'><jid>%s@fcm.googleapis.com/pusher</jid></bind></iq>",
+			iq.ID, gcmNSBind, s.senderID,
+		)
+	case iq.Ping != nil && ignorePings:
+	default:
+		c.write("<iq type='result' id='%s'/>", iq.ID)
+	}
+}
+
+func (s *GCMServer) handleMessage(c *gcmServerConnection, data string) {
+	payload := map[string]interface{}{}
+	if json.Unmarshal([]byte(data), &payload) != nil {
+		return
+	}
+	to, _ := payload["to"].(string)
+	messageID, _ := payload["message_id"].(string)
+	s.mutex.Lock()
+	if messageType, _ := payload["message_type"].(string); messageType == "ack" || messageType == "nack" {
+		s.acks = append(s.acks, messageID)
+		s.mutex.Unlock()
+		return
+	}
+	s.messages = append(s.messages, &GCMMessage{
+		To:        to,
+		MessageID: messageID,
+		Payload:   payload,
+	})
+	res := &GCMResponse{}
+	if responses := s.responses[to]; len(responses) > 0 {
+		res = responses[0]
+		if len(responses) > 1 {
+			s.responses[to] = responses[1:]
+		}
+	}
+	s.mutex.Unlock()
+	if res.Drop {
+		return
+	}
+	respond := func() {
+		if res.Error != "" {
+			c.writeCCS(map[string]interface{}{
+				"from":              to,
+				"message_id":        messageID,
+				"message_type":      "nack",
+				"error":             res.Error,
+				"error_description": res.ErrorDescription,
+			})
+			return
+		}
+		ack := map[string]interface{}{
+			"from":         to,
+			"message_id":   messageID,
+			"message_type": "ack",
+		}
+		if res.RegistrationID != "" {
+			ack["registration_id"] = res.RegistrationID
+		}
+		c.writeCCS(ack)
+		if res.Receipt {
+			c.writeCCS(map[string]interface{}{
+				"from":         "fcm.googleapis.com",
+				"message_id":   "dr2:" + messageID,
+				"message_type": "receipt",
+				"category":     "com.pusher",
+				"data": map[string]interface{}{
+					"message_status":         "MESSAGE_SENT_TO_DEVICE",
+					"original_message_id":    messageID,
+					"device_registration_id": to,
+				},
+			})
+		}
+	}
+	if res.Latency > 0 {
+		time.AfterFunc(res.Latency, respond)
+	} else {
+		respond()
+	}
+}
@@ -0,0 +1,149 @@
+/*
+ * Copyright (c) 2026 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package testing
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// InstanceIDServer is a fake Instance ID server keeping topic subscriptions
+// in memory. Set gcm.iid.host to its URL to manage subscriptions with it
+type InstanceIDServer struct {
+	URL            string
+	server         *httptest.Server
+	mutex          sync.Mutex
+	subscriptions  map[string]map[string]bool
+	tokenErrors    map[string]string
+	authorizations []string
+}
+
+// NewInstanceIDServer starts a fake Instance ID server
+func NewInstanceIDServer() *InstanceIDServer {
+	s := &InstanceIDServer{}
+	s.Reset()
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	s.URL = s.server.URL
+	return s
+}
+
+// SetTokenError makes requests for the token fail with the error, e.g.
+// NOT_FOUND or INVALID_ARGUMENT
+func (s *InstanceIDServer) SetTokenError(token, err string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.tokenErrors[token] = err
+}
+
+// Subscriptions returns the sorted tokens subscribed to the topic
+func (s *InstanceIDServer) Subscriptions(topic string) []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	tokens := []string{}
+	for token := range s.subscriptions[strings.TrimPrefix(topic, "/topics/")] {
+		tokens = append(tokens, token)
+	}
+	sort.Strings(tokens)
+	return tokens
+}
+
+// Authorizations returns the Authorization headers of the requests received
+func (s *InstanceIDServer) Authorizations() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string{}, s.authorizations...)
+}
+
+// Reset forgets the subscriptions, token errors and received requests
+func (s *InstanceIDServer) Reset() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.subscriptions = map[string]map[string]bool{}
+	s.tokenErrors = map[string]string{}
+	s.authorizations = nil
+}
+
+// Close stops the server
+func (s *InstanceIDServer) Close() {
+	s.server.Close()
+}
+
+func (s *InstanceIDServer) handle(w http.ResponseWriter, r *http.Request) {
+	var add bool
+	switch r.URL.Path {
+	case "/iid/v1:batchAdd":
+		add = true
+	case "/iid/v1:batchRemove":
+	default:
+		writeInstanceIDResponse(w, http.StatusNotFound, map[string]interface{}{"error": "NotFound"})
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeInstanceIDResponse(w, http.StatusMethodNotAllowed, map[string]interface{}{"error": "MethodNotAllowed"})
+		return
+	}
+	authorization := r.Header.Get("Authorization")
+	if authorization == "" {
+		writeInstanceIDResponse(w, http.StatusUnauthorized, map[string]interface{}{"error": "Unauthorized"})
+		return
+	}
+	req := struct {
+		To                 string   `json:"to"`
+		RegistrationTokens []string `json:"registration_tokens"`
+	}{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || !strings.HasPrefix(req.To, "/topics/") || len(req.RegistrationTokens) == 0 {
+		writeInstanceIDResponse(w, http.StatusBadRequest, map[string]interface{}{"error": "InvalidParameters"})
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.authorizations = append(s.authorizations, authorization)
+	topic := strings.TrimPrefix(req.To, "/topics/")
+	if s.subscriptions[topic] == nil {
+		s.subscriptions[topic] = map[string]bool{}
+	}
+	results := make([]map[string]string, len(req.RegistrationTokens))
+	for i, token := range req.RegistrationTokens {
+		results[i] = map[string]string{}
+		if tokenErr, ok := s.tokenErrors[token]; ok {
+			results[i]["error"] = tokenErr
+		} else if add {
+			s.subscriptions[topic][token] = true
+		} else {
+			delete(s.subscriptions[topic], token)
+		}
+	}
+	writeInstanceIDResponse(w, http.StatusOK, map[string]interface{}{"results": results})
+}
+
+func writeInstanceIDResponse(w http.ResponseWriter, statusCode int, body map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(body)
+}
@@ -41,3 +41,16 @@ func NewViperWithConfigFile(configFile string) (*viper.Viper, error) {
 	}
 	return v, nil
 }
+
+// CopyConfig returns a viper with the values of config, so they can be changed
+// without affecting config or racing with its readers
+func CopyConfig(config *viper.Viper) *viper.Viper {
+	v := viper.New()
+	v.SetEnvPrefix("pusher")
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+	for _, key := range config.AllKeys() {
+		v.Set(key, config.Get(key))
+	}
+	return v
+}
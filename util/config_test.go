@@ -43,5 +43,31 @@ var _ = Describe("Config", func() {
 				Expect(config).To(BeNil())
 			})
 		})
+
+		Describe("Copy config", func() {
+			It("should copy the values of the config", func() {
+				config, err := NewViperWithConfigFile("../config/test.yaml")
+				Expect(err).NotTo(HaveOccurred())
+				config.SetDefault("copy.default", 10)
+				config.Set("copy.topics.voip", "com.game.voip")
+
+				copied := CopyConfig(config)
+				Expect(copied.GetString("gcm.apps")).To(Equal(config.GetString("gcm.apps")))
+				Expect(copied.GetInt("copy.default")).To(Equal(10))
+				Expect(copied.GetStringMapString("copy.topics")).To(Equal(map[string]string{"voip": "com.game.voip"}))
+			})
+
+			It("should not change the config when the copy changes", func() {
+				config, err := NewViperWithConfigFile("../config/test.yaml")
+				Expect(err).NotTo(HaveOccurred())
+				apps := config.GetString("gcm.apps")
+
+				copied := CopyConfig(config)
+				copied.Set("gcm.apps", "other")
+				copied.Set("gcm.certs.other.apiKey", "key")
+				Expect(config.GetString("gcm.apps")).To(Equal(apps))
+				Expect(config.IsSet("gcm.certs.other.apiKey")).To(BeFalse())
+			})
+		})
 	})
 })